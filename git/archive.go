@@ -0,0 +1,217 @@
+package git
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"gitsnap/options"
+	"gitsnap/util"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+)
+
+// archiveWriter collects the dumped files of a snapshot into a single archive file instead of the output directory
+type archiveWriter interface {
+	addFile(name string, mode os.FileMode, contents []byte) error
+	entryCount() int
+	close() error
+	abort()
+}
+
+func isArchiveFormat(format string) bool {
+	return format == options.FORMAT_TAR || format == options.FORMAT_TAR_GZ || format == options.FORMAT_ZIP
+}
+
+// createArchive creates the archive file, or writes the archive to stream when the archive path is -
+func createArchive(archivePath string, stream io.Writer, format string, compressionLevel int, modTime time.Time) (archiveWriter, error) {
+	if format == options.FORMAT_ZIP {
+		archive, err := createZipArchive(archivePath, stream, compressionLevel, modTime)
+		if err != nil {
+			return nil, err
+		}
+		return archive, nil
+	}
+	archive, err := createTarArchive(archivePath, stream, compressionLevel, format == options.FORMAT_TAR_GZ, modTime)
+	if err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// tarArchive streams dumped files into a single tar file instead of writing them to the output directory
+type tarArchive struct {
+	mutex   sync.Mutex
+	path    string
+	file    io.Writer
+	gzip    *gzip.Writer
+	writer  *tar.Writer
+	modTime time.Time
+	entries int
+}
+
+func createArchiveFile(archivePath string, stream io.Writer) (io.Writer, error) {
+	if archivePath == options.OUTPUT_STDOUT {
+		return stream, nil
+	}
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+			InternalError: fmt.Errorf("failed to create archive at '%v': %v", archivePath, err),
+		}
+	}
+	return file, nil
+}
+
+// closeArchiveFile closes the archive file, unless it is the stream which its owner still uses
+func closeArchiveFile(archivePath string, file io.Writer) error {
+	if archivePath == options.OUTPUT_STDOUT {
+		return nil
+	}
+	return file.(io.Closer).Close()
+}
+
+// removeArchiveFile removes a partially written archive, a partial stream can not be taken back
+func removeArchiveFile(archivePath string) {
+	if archivePath != options.OUTPUT_STDOUT {
+		_ = os.Remove(archivePath)
+	}
+}
+
+func createTarArchive(archivePath string, stream io.Writer, compressionLevel int, compress bool, modTime time.Time) (*tarArchive, error) {
+	file, err := createArchiveFile(archivePath, stream)
+	if err != nil {
+		return nil, err
+	}
+	archive := &tarArchive{
+		path:    archivePath,
+		file:    file,
+		modTime: modTime,
+	}
+	var output io.Writer = file
+	if compress {
+		archive.gzip, err = gzip.NewWriterLevel(file, compressionLevel)
+		if err != nil {
+			_ = closeArchiveFile(archivePath, file)
+			removeArchiveFile(archivePath)
+			return nil, fmt.Errorf("failed to compress archive at '%v': %v", archivePath, err)
+		}
+		output = archive.gzip
+	}
+	archive.writer = tar.NewWriter(output)
+	return archive, nil
+}
+
+func (archive *tarArchive) addFile(name string, mode os.FileMode, contents []byte) error {
+	archive.mutex.Lock()
+	defer archive.mutex.Unlock()
+
+	err := archive.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Size:     int64(len(contents)),
+		ModTime:  archive.modTime,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write archive header of '%v': %v", name, err)
+	}
+	_, err = archive.writer.Write(contents)
+	if err != nil {
+		return fmt.Errorf("failed to write '%v' to archive: %v", name, err)
+	}
+	archive.entries++
+	return nil
+}
+
+func (archive *tarArchive) entryCount() int {
+	archive.mutex.Lock()
+	defer archive.mutex.Unlock()
+	return archive.entries
+}
+
+// close writes the tar footer and the gzip trailer, if compressed, before closing the file
+func (archive *tarArchive) close() error {
+	err := archive.writer.Close()
+	if archive.gzip != nil {
+		gzipErr := archive.gzip.Close()
+		if err == nil {
+			err = gzipErr
+		}
+	}
+	closeErr := closeArchiveFile(archive.path, archive.file)
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to finish archive at '%v': %v", archive.path, err)
+	}
+	return nil
+}
+
+// abort closes the archive and removes it, so a failed run never leaves a truncated archive behind
+func (archive *tarArchive) abort() {
+	_ = archive.close()
+	removeArchiveFile(archive.path)
+}
+
+func (provider *repositoryProvider) archiveFile(filePath string, targetFilePath string, mode filemode.FileMode, digest string, contents []byte) error {
+	osMode, err := mode.ToOSFileMode()
+	if err != nil {
+		return fmt.Errorf("failed to get file mode of '%v': %v", filePath, err)
+	}
+	err = provider.archive.addFile(targetFilePath, osMode, contents)
+	if err != nil {
+		return err
+	}
+	provider.verboseLog("+++ '%v' to '%v' in archive", filePath, targetFilePath)
+
+	if provider.opts.CreateHashMarkers {
+		return provider.archive.addFile(fmt.Sprintf("%v.hash", targetFilePath), osMode, []byte(digest))
+	}
+	return nil
+}
+
+// finishArchive adds the index, if any, and closes the archive after making sure it holds an entry for every dumped file
+func (provider *repositoryProvider) finishArchive(records []*indexRecord, index *bytes.Buffer, indexFilePath string) error {
+	archive := provider.archive
+
+	expectedEntries := 0
+	for _, record := range records {
+		if record.snapped && record.entry.Mode.IsFile() {
+			expectedEntries++
+		}
+	}
+	if provider.opts.CreateHashMarkers {
+		expectedEntries *= 2
+	}
+
+	if index != nil {
+		err := archive.addFile(filepath.Base(indexFilePath), TARGET_PERMISSIONS, index.Bytes())
+		if err != nil {
+			archive.abort()
+			return err
+		}
+		expectedEntries++
+	}
+
+	if archive.entryCount() != expectedEntries {
+		archive.abort()
+		return &util.ErrorWithCode{
+			StatusCode:    util.ERROR_FILES_DISCREPANCY,
+			InternalError: fmt.Errorf("archive has %v entries, but %v were expected", archive.entryCount(), expectedEntries),
+		}
+	}
+
+	err := archive.close()
+	if err != nil {
+		archive.abort()
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"gitsnap/options"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type archiveEntry struct {
+	mode     int64
+	contents string
+}
+
+func readTarEntries(t *testing.T, archivePath string) map[string]archiveEntry {
+	file, err := os.Open(archivePath)
+	require.Nil(t, err)
+	defer file.Close()
+	return readTarStream(t, file)
+}
+
+func readTarStream(t *testing.T, stream io.Reader) map[string]archiveEntry {
+	entries := map[string]archiveEntry{}
+	reader := tar.NewReader(stream)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return entries
+		}
+		require.Nil(t, err)
+		contents, err := io.ReadAll(reader)
+		require.Nil(t, err)
+		entries[header.Name] = archiveEntry{mode: header.Mode, contents: string(contents)}
+	}
+}
+
+func TestSnapshotToTar(t *testing.T) {
+	clonePath, _ := createLocalRepo(map[string]string{
+		"a.txt":         "a",
+		"nested/b.txt":  "b",
+		"nested/run.sh": "#!/bin/sh",
+	})
+	defer os.RemoveAll(clonePath)
+	runCommandIn(clonePath, "chmod", "+x", "nested/run.sh")
+	runCommandIn(clonePath, "ln", "-s", "a.txt", "link.txt")
+	revision := commitFiles(clonePath, map[string]string{}, "tester <tester@example.com>")
+
+	outputPath := filepath.Join(t.TempDir(), "snapshot.tar")
+	err := Snapshot(&options.Options{
+		ClonePath:             clonePath,
+		Revision:              revision,
+		OutputPath:            outputPath,
+		OptionalIndexFilePath: filepath.Join(t.TempDir(), "index.tsv"),
+		IncludePatterns:       []string{},
+		ExcludePatterns:       []string{},
+		CreateHashMarkers:     true,
+		Format:                options.FORMAT_TAR,
+	})
+	require.Nil(t, err)
+
+	entries := readTarEntries(t, outputPath)
+	require.Len(t, entries, 7)
+	require.Equal(t, "a", entries["a.txt"].contents)
+	require.Equal(t, "b", entries["nested/b.txt"].contents)
+	require.Equal(t, int64(0644), entries["a.txt"].mode)
+	require.Equal(t, int64(0755), entries["nested/run.sh"].mode)
+	require.Contains(t, entries, "nested/b.txt.hash")
+	require.Contains(t, entries["index.tsv"].contents, "nested/run.sh")
+	require.NotContains(t, entries, "link.txt")
+}
+
+func TestSnapshotToTarGz(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":        "a",
+		"nested/b.txt": "b",
+	})
+	defer os.RemoveAll(clonePath)
+
+	for includePattern, expectedEntries := range map[string]int{"*.txt": 2, "*.none": 0} {
+		outputPath := filepath.Join(t.TempDir(), "snapshot.tar.gz")
+		err := Snapshot(&options.Options{
+			ClonePath:        clonePath,
+			Revision:         revision,
+			OutputPath:       outputPath,
+			IncludePatterns:  []string{includePattern},
+			ExcludePatterns:  []string{},
+			Format:           options.FORMAT_TAR_GZ,
+			CompressionLevel: gzip.BestCompression,
+		})
+		require.Nil(t, err)
+
+		file, err := os.Open(outputPath)
+		require.Nil(t, err)
+		defer file.Close()
+		gzipReader, err := gzip.NewReader(file)
+		require.Nil(t, err)
+		entries := readTarStream(t, gzipReader)
+		require.Len(t, entries, expectedEntries)
+		if expectedEntries > 0 {
+			require.Equal(t, "b", entries["nested/b.txt"].contents)
+		}
+	}
+}
+
+func TestSnapshotToZip(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("dir%v/file%v.txt", i%3, i)] = fmt.Sprintf("content %v", i)
+	}
+	clonePath, revision := createLocalRepo(files)
+	defer os.RemoveAll(clonePath)
+	authorDate, err := time.Parse(time.RFC3339, runGit(clonePath, "log", "-1", "--format=%aI"))
+	require.Nil(t, err)
+
+	var archives [][]byte
+	for i := 0; i < 2; i++ {
+		outputPath := filepath.Join(t.TempDir(), "snapshot.zip")
+		err := Snapshot(&options.Options{
+			ClonePath:        clonePath,
+			Revision:         revision,
+			OutputPath:       outputPath,
+			IncludePatterns:  []string{},
+			ExcludePatterns:  []string{},
+			Format:           options.FORMAT_ZIP,
+			CompressionLevel: -1,
+			Workers:          8,
+		})
+		require.Nil(t, err)
+		archive, err := os.ReadFile(outputPath)
+		require.Nil(t, err)
+		archives = append(archives, archive)
+	}
+	require.Equal(t, archives[0], archives[1])
+
+	reader, err := zip.NewReader(bytes.NewReader(archives[0]), int64(len(archives[0])))
+	require.Nil(t, err)
+	require.Len(t, reader.File, len(files))
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+		require.True(t, file.Modified.Equal(authorDate), "%v vs %v", file.Modified, authorDate)
+		contents, err := file.Open()
+		require.Nil(t, err)
+		written, err := io.ReadAll(contents)
+		require.Nil(t, err)
+		require.Equal(t, files[file.Name], string(written))
+	}
+	require.True(t, sort.StringsAreSorted(names))
+}
+
+func TestSnapshotToStdout(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":        "a",
+		"nested/b.txt": "b",
+	})
+	defer os.RemoveAll(clonePath)
+
+	reader, writer, err := os.Pipe()
+	require.Nil(t, err)
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	streamed := make(chan []byte)
+	go func() {
+		contents, _ := io.ReadAll(reader)
+		streamed <- contents
+	}()
+
+	err = Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      options.OUTPUT_STDOUT,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+		Format:          options.FORMAT_TAR,
+	})
+	require.Nil(t, err)
+	require.Nil(t, writer.Close())
+
+	entries := readTarStream(t, bytes.NewReader(<-streamed))
+	require.Len(t, entries, 2)
+	require.Equal(t, "b", entries["nested/b.txt"].contents)
+}
@@ -0,0 +1,66 @@
+package git
+
+import (
+	"encoding/csv"
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readIndexPaths(t *testing.T, indexPath string) []string {
+	file, err := os.Open(indexPath)
+	require.Nil(t, err)
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comma = '\t'
+	lines, err := reader.ReadAll()
+	require.Nil(t, err)
+	var paths []string
+	for _, line := range lines[1:] {
+		if line[2] == "true" {
+			paths = append(paths, line[0])
+		}
+	}
+	return paths
+}
+
+func TestSnapshotWithPathsAndIndexFlags(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"src/main.go":  "package main",
+		"src/util.go":  "package main",
+		"docs/read.md": "# docs",
+	})
+	defer os.RemoveAll(clonePath)
+
+	pathsFilePath := filepath.Join(t.TempDir(), "paths.txt")
+	require.Nil(t, os.WriteFile(pathsFilePath, []byte("src/main.go\ndocs/read.md\n"), 0644))
+	args := []string{"--src", clonePath, "--rev", revision}
+
+	// --paths-file limits the snapshot to the listed paths
+	outputPath := t.TempDir()
+	opts, err := options.ParseArgs(append(args, "--out", outputPath, "--paths-file", pathsFilePath))
+	require.Nil(t, err)
+	require.Nil(t, Snapshot(opts))
+	requireFileContents(t, filepath.Join(outputPath, "src", "main.go"), "package main")
+	requireFileContents(t, filepath.Join(outputPath, "docs", "read.md"), "# docs")
+	require.NoFileExists(t, filepath.Join(outputPath, "src", "util.go"))
+
+	// --index-file writes the index along with the files
+	outputPath = t.TempDir()
+	indexPath := filepath.Join(t.TempDir(), "index.tsv")
+	opts, err = options.ParseArgs(append(args, "--out", outputPath, "--index-file", indexPath))
+	require.Nil(t, err)
+	require.Nil(t, Snapshot(opts))
+	require.FileExists(t, filepath.Join(outputPath, "src", "util.go"))
+	require.ElementsMatch(t, []string{"src/main.go", "src/util.go", "docs/read.md"}, readIndexPaths(t, indexPath))
+
+	// --index-only writes the index alone
+	indexPath = filepath.Join(t.TempDir(), "index.tsv")
+	opts, err = options.ParseArgs(append(args, "--index-file", indexPath, "--index-only", "--paths-file", pathsFilePath))
+	require.Nil(t, err)
+	require.Nil(t, Snapshot(opts))
+	require.ElementsMatch(t, []string{"src/main.go", "docs/read.md"}, readIndexPaths(t, indexPath))
+}
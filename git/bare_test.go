@@ -0,0 +1,34 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotFromBareRepository(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"main.go":        "package main",
+		"nested/util.go": "package nested",
+	})
+	defer os.RemoveAll(clonePath)
+	barePath := filepath.Join(t.TempDir(), "bare.git")
+	runGit(clonePath, "clone", "-q", "--bare", clonePath, barePath)
+	require.NoDirExists(t, filepath.Join(barePath, ".git"))
+	require.Nil(t, options.ValidateClonePath(barePath))
+
+	outputPath := t.TempDir()
+	err := Snapshot(&options.Options{
+		ClonePath:       barePath,
+		Revision:        revision,
+		OutputPath:      outputPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+	})
+	require.Nil(t, err)
+	requireFileContents(t, filepath.Join(outputPath, "main.go"), "package main")
+	requireFileContents(t, filepath.Join(outputPath, "nested", "util.go"), "package nested")
+}
@@ -0,0 +1,45 @@
+package git
+
+import (
+	"bytes"
+	"gitsnap/util"
+	"path/filepath"
+)
+
+// the byte order marks charset.DetermineEncoding recognizes, which decodeContents skips when counting lines
+var byteOrderMarks = [][]byte{
+	{0xef, 0xbb, 0xbf},
+	{0xff, 0xfe},
+	{0xfe, 0xff},
+}
+
+// starts with the UTF-16LE byte order mark, but it is not stripped as one
+var utf32LEByteOrderMark = []byte{0xff, 0xfe, 0x00, 0x00}
+
+// stripByteOrderMark returns the contents without their leading byte order mark, if any
+func stripByteOrderMark(contents []byte) []byte {
+	if bytes.HasPrefix(contents, utf32LEByteOrderMark) {
+		return contents
+	}
+	for _, byteOrderMark := range byteOrderMarks {
+		if bytes.HasPrefix(contents, byteOrderMark) {
+			return contents[len(byteOrderMark):]
+		}
+	}
+	return contents
+}
+
+// stripsByteOrderMark checks whether --strip-bom applies to the file
+func (provider *repositoryProvider) stripsByteOrderMark(filePath string) bool {
+	return provider.opts.StripBOM && provider.isTextFile(filePath)
+}
+
+// isTextFile classifies a file as text by .gitattributes, or else by its extension. UTF-16 contents hold NUL bytes,
+// so they are not checked.
+func (provider *repositoryProvider) isTextFile(filePath string) bool {
+	text, explicit := provider.textAttributes.isText(provider.repositoryPath(filePath))
+	if explicit {
+		return text
+	}
+	return !util.NotTextExt(filepath.Ext(filePath))
+}
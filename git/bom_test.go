@@ -0,0 +1,74 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	UTF8_BOM    = "\xef\xbb\xbf"
+	UTF16LE_BOM = "\xff\xfe"
+)
+
+func TestStripByteOrderMark(t *testing.T) {
+	require.Equal(t, "text", string(stripByteOrderMark([]byte(UTF8_BOM+"text"))))
+	require.Equal(t, "t\x00", string(stripByteOrderMark([]byte(UTF16LE_BOM+"t\x00"))))
+	require.Equal(t, "\x00t", string(stripByteOrderMark([]byte("\xfe\xff\x00t"))))
+	require.Equal(t, "text"+UTF8_BOM, string(stripByteOrderMark([]byte("text"+UTF8_BOM))))
+	// a UTF-32LE byte order mark is kept whole
+	require.Equal(t, "\xff\xfe\x00\x00t\x00\x00\x00", string(stripByteOrderMark([]byte("\xff\xfe\x00\x00t\x00\x00\x00"))))
+	require.Empty(t, stripByteOrderMark([]byte{}))
+}
+
+func TestSnapshotWithStripBOM(t *testing.T) {
+	files := map[string]string{
+		"utf8.txt":       UTF8_BOM + "hello",
+		"utf16le.txt":    UTF16LE_BOM + "h\x00i\x00",
+		"plain.go":       "package main",
+		"image.png":      UTF8_BOM + "\x89PNG",
+		"data.bin":       UTF8_BOM + "declared text",
+		".gitattributes": "*.bin text\n",
+	}
+	clonePath, revision := createLocalRepo(files)
+	defer os.RemoveAll(clonePath)
+
+	for _, stripBOM := range []bool{false, true} {
+		outputPath := t.TempDir()
+		result, err := SnapshotWithResult(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			StripBOM:        stripBOM,
+			VerifyContent:   true,
+		})
+		require.Nil(t, err)
+		requireFileContents(t, filepath.Join(outputPath, "plain.go"), "package main")
+		requireFileContents(t, filepath.Join(outputPath, "image.png"), UTF8_BOM+"\x89PNG")
+		if !stripBOM {
+			requireFileContents(t, filepath.Join(outputPath, "utf8.txt"), files["utf8.txt"])
+			requireFileContents(t, filepath.Join(outputPath, "utf16le.txt"), files["utf16le.txt"])
+			requireFileContents(t, filepath.Join(outputPath, "data.bin"), files["data.bin"])
+			continue
+		}
+		requireFileContents(t, filepath.Join(outputPath, "utf8.txt"), "hello")
+		requireFileContents(t, filepath.Join(outputPath, "utf16le.txt"), "h\x00i\x00")
+		requireFileContents(t, filepath.Join(outputPath, "data.bin"), "declared text")
+		// the written sizes are counted
+		expectedBytes := int64(0)
+		for filePath, contents := range files {
+			expectedBytes += int64(len(contents))
+			if filePath == "utf8.txt" || filePath == "data.bin" {
+				expectedBytes -= int64(len(UTF8_BOM))
+			} else if filePath == "utf16le.txt" {
+				expectedBytes -= int64(len(UTF16LE_BOM))
+			}
+		}
+		require.Equal(t, expectedBytes, result.BytesWritten)
+	}
+}
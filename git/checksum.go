@@ -0,0 +1,45 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// snapshotChecksum digests the number of written files and their sorted (path, blob id) pairs, so it only
+// depends on the commit and the filters. with --hash-algo sha256 the SHA-256 of the contents replaces the blob id.
+func snapshotChecksum(records []*indexRecord) string {
+	var written []*indexRecord
+	for _, record := range records {
+		if record.snapped && record.entry.Mode.IsFile() {
+			written = append(written, record)
+		}
+	}
+	sort.Slice(written, func(i, j int) bool {
+		return written[i].path < written[j].path
+	})
+
+	digest := sha256.New()
+	_, _ = fmt.Fprintf(digest, "%v\n", len(written))
+	for _, record := range written {
+		fileDigest := record.digest
+		if fileDigest == "" {
+			fileDigest = record.entry.Hash.String()
+		}
+		// paths may hold any character but NUL
+		_, _ = fmt.Fprintf(digest, "%v\x00%v\n", record.path, fileDigest)
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+func (provider *repositoryProvider) writeChecksum(checksumPath string, records []*indexRecord) error {
+	checksum := snapshotChecksum(records)
+	err := os.WriteFile(checksumPath, []byte(checksum+"\n"), TARGET_PERMISSIONS)
+	if err != nil {
+		return fmt.Errorf("failed to write checksum file '%v': %v", checksumPath, err)
+	}
+	provider.logger.Infof("written snapshot checksum %v to '%v'", checksum, checksumPath)
+	return nil
+}
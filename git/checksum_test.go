@@ -0,0 +1,55 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithChecksum(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":        "a",
+		"a/b.txt":      "b",
+		"skipped.java": "class Skipped {}",
+	})
+	defer os.RemoveAll(clonePath)
+
+	snapshotChecksum := func(format string, includePatterns []string) string {
+		checksumPath := filepath.Join(t.TempDir(), "checksum")
+		outputPath := t.TempDir()
+		if format != options.FORMAT_DIR {
+			outputPath = filepath.Join(outputPath, "snapshot."+format)
+		}
+		err := Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: includePatterns,
+			ExcludePatterns: []string{},
+			Format:          format,
+			ChecksumPath:    checksumPath,
+		})
+		require.Nil(t, err)
+		contents, err := os.ReadFile(checksumPath)
+		require.Nil(t, err)
+		return strings.TrimSuffix(string(contents), "\n")
+	}
+
+	checksum := snapshotChecksum(options.FORMAT_DIR, []string{"**.txt"})
+	require.Equal(t, checksum, snapshotChecksum(options.FORMAT_DIR, []string{"**.txt"}))
+	require.Equal(t, checksum, snapshotChecksum(options.FORMAT_ZIP, []string{"**.txt"}))
+	require.NotEqual(t, checksum, snapshotChecksum(options.FORMAT_DIR, []string{}))
+
+	blobId := func(filePath string) string {
+		return runGit(clonePath, "rev-parse", revision+":"+filePath)
+	}
+	expected := sha256.Sum256([]byte(fmt.Sprintf("2\na.txt\x00%v\na/b.txt\x00%v\n", blobId("a.txt"), blobId("a/b.txt"))))
+	require.Equal(t, hex.EncodeToString(expected[:]), checksum)
+}
@@ -0,0 +1,150 @@
+package git
+
+// commentSyntax tells countLinesOfCode how a language marks its comments and strings
+type commentSyntax struct {
+	// start comments which run to the end of the line
+	lineComments []string
+	// delimit block comments, empty for languages without them
+	blockStart string
+	blockEnd   string
+	// a block comment opened inside another one needs its own end
+	nestedBlocks bool
+	// block comments start only at the start of a line, like python docstrings or ruby and perl documentation,
+	// rather than anywhere outside a string
+	blocksAtLineStart bool
+	// start and end string literals, in which comment markers are not comments. a ` string may span lines, the
+	// others end with their line.
+	quotes string
+}
+
+var (
+	cStyleCommentSyntax = commentSyntax{
+		lineComments: []string{"//"},
+		blockStart:   "/*",
+		blockEnd:     "*/",
+		quotes:       `"'`,
+	}
+	nestedCStyleCommentSyntax = commentSyntax{
+		lineComments: []string{"//"},
+		blockStart:   "/*",
+		blockEnd:     "*/",
+		nestedBlocks: true,
+		quotes:       `"'`,
+	}
+	hashCommentSyntax = commentSyntax{
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	}
+	// ' also starts quoted forms, which are not closed
+	semicolonCommentSyntax = commentSyntax{
+		lineComments: []string{";"},
+		quotes:       `"`,
+	}
+	markupCommentSyntax = commentSyntax{
+		blockStart: "<!--",
+		blockEnd:   "-->",
+	}
+
+	// the comment syntax of every built-in language, by its name in stats.GetLanguageFromExtension
+	commentSyntaxes = map[string]commentSyntax{
+		"java":        cStyleCommentSyntax,
+		"csharp":      cStyleCommentSyntax,
+		"objective-c": cStyleCommentSyntax,
+		"cpp":         cStyleCommentSyntax,
+		"dart":        cStyleCommentSyntax,
+		"scss":        cStyleCommentSyntax,
+		"kotlin":      nestedCStyleCommentSyntax,
+		"scala":       nestedCStyleCommentSyntax,
+		"swift":       nestedCStyleCommentSyntax,
+		"node": {
+			lineComments: []string{"//"},
+			blockStart:   "/*",
+			blockEnd:     "*/",
+			quotes:       "\"'`",
+		},
+		"go": {
+			lineComments: []string{"//"},
+			blockStart:   "/*",
+			blockEnd:     "*/",
+			quotes:       "\"'`",
+		},
+		// ' also starts lifetimes, which are not closed
+		"rust": {
+			lineComments: []string{"//"},
+			blockStart:   "/*",
+			blockEnd:     "*/",
+			nestedBlocks: true,
+			quotes:       `"`,
+		},
+		"php": {
+			lineComments: []string{"//", "#"},
+			blockStart:   "/*",
+			blockEnd:     "*/",
+			quotes:       `"'`,
+		},
+		"css": {
+			blockStart: "/*",
+			blockEnd:   "*/",
+			quotes:     `"'`,
+		},
+		"python": {
+			lineComments:      []string{"#"},
+			blockStart:        `"""`,
+			blockEnd:          `"""`,
+			blocksAtLineStart: true,
+			quotes:            `"'`,
+		},
+		"ruby": {
+			lineComments:      []string{"#"},
+			blockStart:        "=begin",
+			blockEnd:          "=end",
+			blocksAtLineStart: true,
+			quotes:            `"'`,
+		},
+		"perl": {
+			lineComments:      []string{"#"},
+			blockStart:        "=pod",
+			blockEnd:          "=cut",
+			blocksAtLineStart: true,
+			quotes:            `"'`,
+		},
+		"shell":  hashCommentSyntax,
+		"elixir": hashCommentSyntax,
+		"r":      hashCommentSyntax,
+		"yaml":   hashCommentSyntax,
+		"sql": {
+			lineComments: []string{"--"},
+			blockStart:   "/*",
+			blockEnd:     "*/",
+			quotes:       `"'`,
+		},
+		"lua": {
+			lineComments: []string{"--"},
+			blockStart:   "--[[",
+			blockEnd:     "]]",
+			quotes:       `"'`,
+		},
+		// ' also ends primed names, which are not opened
+		"haskell": {
+			lineComments: []string{"--"},
+			blockStart:   "{-",
+			blockEnd:     "-}",
+			nestedBlocks: true,
+			quotes:       `"`,
+		},
+		"clojure": semicolonCommentSyntax,
+		"lisp":    semicolonCommentSyntax,
+		"html":    markupCommentSyntax,
+		"xml":     markupCommentSyntax,
+	}
+)
+
+// commentSyntaxOf returns the comment syntax of the language. languages without one, such as those added by
+// --lang-map, are counted with the syntax of C.
+func commentSyntaxOf(language string) commentSyntax {
+	syntax, found := commentSyntaxes[language]
+	if !found {
+		return cStyleCommentSyntax
+	}
+	return syntax
+}
@@ -0,0 +1,26 @@
+package git
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountLinesOfCodeByCommentSyntax(t *testing.T) {
+	for language, syntax := range commentSyntaxes {
+		contents := "a = 1\n"
+		expected := lineCounts{code: 1}
+		for _, lineComment := range syntax.lineComments {
+			contents += lineComment + " comment\n"
+			expected.comment++
+		}
+		if syntax.blockStart != "" {
+			contents += syntax.blockStart + " block\ncomment " + syntax.blockEnd + "\n"
+			expected.comment += 2
+		}
+		require.Equal(t, expected, countLinesOfCode([]byte(contents), language), "%v: %q", language, contents)
+	}
+
+	// languages without a syntax of their own, such as those of --lang-map, are counted like C
+	require.Equal(t, lineCounts{code: 1, comment: 2}, countLinesOfCode([]byte("// a\n/* b */\nc = 1\n"), "custom"))
+}
@@ -0,0 +1,128 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type CompareReport struct {
+	Missing   []string `json:"missing"`
+	Extra     []string `json:"extra"`
+	Differing []string `json:"differing"`
+}
+
+func (provider *repositoryProvider) compareToDir(commit *object.Commit, dirPath string) error {
+	report, err := provider.compare(commit, dirPath)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(provider.outputStream())
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(report)
+	if err != nil {
+		return fmt.Errorf("failed to write compare report: %v", err)
+	}
+
+	provider.logger.Infof("compared to '%v': %v missing, %v extra, %v differing files", dirPath, len(report.Missing), len(report.Extra), len(report.Differing))
+	return nil
+}
+
+func (provider *repositoryProvider) compare(commit *object.Commit, dirPath string) (*CompareReport, error) {
+	tree, err := provider.getSnapshotTree(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CompareReport{
+		Missing:   []string{},
+		Extra:     []string{},
+		Differing: []string{},
+	}
+	expected := map[string]bool{}
+
+	treeWalker := provider.newSnapshotWalker(commit, tree)
+	defer treeWalker.Close()
+
+	for {
+		name, entry, walkErr := treeWalker.Next()
+		if walkErr == io.EOF {
+			break
+		}
+		if walkErr != nil {
+			return nil, fmt.Errorf("failed to iterate files of %v: %v", commit.Hash, walkErr)
+		}
+
+		if !provider.shouldInclude(name, entry.Mode) {
+			continue
+		}
+
+		blob, err := provider.storedBlob(entry.Hash)
+		if err != nil {
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				provider.logger.Infof("Can't get blob %s: %s", name, err)
+				continue
+			}
+			return nil, fmt.Errorf("failed to get blob of '%v': %v", name, err)
+		}
+		if provider.exceedsLimits(name, blob.Size) {
+			continue
+		}
+		expected[name] = true
+
+		contents, err := os.ReadFile(filepath.Join(dirPath, name))
+		if os.IsNotExist(err) {
+			provider.verboseLog("--- '%v' is missing", name)
+			report.Missing = append(report.Missing, name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%v' from '%v': %v", name, dirPath, err)
+		}
+		if plumbing.ComputeHash(plumbing.BlobObject, contents) != entry.Hash {
+			provider.verboseLog("*** '%v' differs", name)
+			report.Differing = append(report.Differing, name)
+		}
+	}
+
+	err = filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		if expected[relativePath] {
+			return nil
+		}
+		if provider.opts.CreateHashMarkers && expected[strings.TrimSuffix(relativePath, ".hash")] {
+			return nil
+		}
+		provider.verboseLog("+++ '%v' is extra", relativePath)
+		report.Extra = append(report.Extra, relativePath)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk '%v': %v", dirPath, err)
+	}
+
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra)
+	sort.Strings(report.Differing)
+	return report, nil
+}
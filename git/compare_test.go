@@ -0,0 +1,58 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"gitsnap/options"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareToDir(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"same.txt":          "same",
+		"changed.txt":       "original",
+		"missing.txt":       "missing",
+		"nested/same.java":  "class Same {}",
+		"excluded/file.txt": "excluded",
+	})
+	defer os.RemoveAll(clonePath)
+
+	dirPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dirPath)
+	writeFiles(dirPath, map[string]string{
+		"same.txt":         "same",
+		"changed.txt":      "modified",
+		"nested/same.java": "class Same {}",
+		"nested/extra.txt": "extra",
+	})
+
+	opts := &options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		CompareToDir:    dirPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{"excluded/**"},
+	}
+	provider, err := newRepositoryProvider(opts)
+	require.Nil(t, err)
+	commit, err := provider.getCommit(revision)
+	require.Nil(t, err)
+
+	report, err := provider.compare(commit, dirPath)
+	require.Nil(t, err)
+	require.Equal(t, []string{"missing.txt"}, report.Missing)
+	require.Equal(t, []string{"nested/extra.txt"}, report.Extra)
+	require.Equal(t, []string{"changed.txt"}, report.Differing)
+
+	output := &bytes.Buffer{}
+	opts.OutputWriter = output
+	err = Snapshot(opts)
+	require.Nil(t, err)
+	var written CompareReport
+	require.Nil(t, json.Unmarshal(output.Bytes(), &written))
+	require.Equal(t, *report, written)
+}
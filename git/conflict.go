@@ -0,0 +1,141 @@
+package git
+
+import (
+	"fmt"
+	"gitsnap/options"
+	"gitsnap/util"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type pathSet struct {
+	mutex sync.Mutex
+	paths map[string]bool
+}
+
+func newPathSet() *pathSet {
+	return &pathSet{
+		paths: map[string]bool{},
+	}
+}
+
+// add returns false if the path was already in the set
+func (set *pathSet) add(path string) bool {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	if set.paths[path] {
+		return false
+	}
+	set.paths[path] = true
+	return true
+}
+
+// claimTargetPath registers the target path as written by the current run, applying the conflict
+// strategy if it was already written. an empty path means the file should be skipped.
+func (provider *repositoryProvider) claimTargetPath(filePath string, targetFilePath string) (string, error) {
+	if provider.writtenPaths.add(targetFilePath) {
+		return targetFilePath, nil
+	}
+
+	switch provider.opts.OnConflict {
+	case options.ON_CONFLICT_SKIP:
+		provider.logger.Infof("--- skipping '%v' - target path '%v' was already written", filePath, targetFilePath)
+		return "", nil
+	case options.ON_CONFLICT_RENAME:
+		extension := filepath.Ext(targetFilePath)
+		base := strings.TrimSuffix(targetFilePath, extension)
+		for i := 1; ; i++ {
+			renamedTargetFilePath := fmt.Sprintf("%v_%v%v", base, i, extension)
+			if provider.writtenPaths.add(renamedTargetFilePath) {
+				provider.verboseLog("*** renaming '%v' to '%v' - target path was already written", filePath, renamedTargetFilePath)
+				return renamedTargetFilePath, nil
+			}
+		}
+	default:
+		return "", fmt.Errorf("target path '%v' of '%v' was already written", targetFilePath, filePath)
+	}
+}
+
+// isWithinPath returns whether the cleaned absolute target path is under the root path
+func isWithinPath(rootPath string, targetPath string) bool {
+	absoluteRootPath, err := filepath.Abs(rootPath)
+	if err != nil {
+		return false
+	}
+	absoluteTargetPath, err := filepath.Abs(targetPath)
+	if err != nil {
+		return false
+	}
+	relativePath, err := filepath.Rel(absoluteRootPath, absoluteTargetPath)
+	if err != nil {
+		return false
+	}
+	return relativePath != "." && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
+
+// findWrongTypePath returns the first existing component of the target file path which has the wrong type -
+// a non-directory where a directory is needed, or a directory where the file itself should be
+func findWrongTypePath(outputPath string, targetFilePath string) string {
+	relativePath, err := filepath.Rel(outputPath, targetFilePath)
+	if err != nil {
+		return ""
+	}
+	components := strings.Split(relativePath, string(filepath.Separator))
+	currentPath := outputPath
+	for i, component := range components {
+		currentPath = filepath.Join(currentPath, component)
+		info, err := os.Lstat(currentPath)
+		if err != nil {
+			return ""
+		}
+		isFileComponent := i == len(components)-1
+		if isFileComponent == info.IsDir() {
+			return currentPath
+		}
+	}
+	return ""
+}
+
+func writeFileCreatingDirs(targetFilePath string, contents []byte, perm os.FileMode) error {
+	targetDirectoryPath := filepath.Dir(targetFilePath)
+	err := os.MkdirAll(targetDirectoryPath, TARGET_DIRECTORY_PERMISSIONS)
+	if err != nil {
+		return fmt.Errorf("failed to create target directory at '%v': %w", targetDirectoryPath, err)
+	}
+	return os.WriteFile(targetFilePath, contents, perm)
+}
+
+func (provider *repositoryProvider) writeTargetFile(outputPath string, targetFilePath string, contents []byte, perm os.FileMode) error {
+	return provider.writeReplacingConflicts(outputPath, targetFilePath, func() error {
+		return writeFileCreatingDirs(targetFilePath, contents, perm)
+	})
+}
+
+// writeReplacingConflicts runs write, and if it fails since a path on the way has the wrong type, removes it and retries
+// with --replace-conflicting-paths
+func (provider *repositoryProvider) writeReplacingConflicts(outputPath string, targetFilePath string, write func() error) error {
+	err := write()
+	if err == nil {
+		return nil
+	}
+
+	conflictingPath := findWrongTypePath(outputPath, targetFilePath)
+	if conflictingPath == "" {
+		return err
+	}
+	if !provider.opts.ReplaceConflictingPaths {
+		return &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+			InternalError: fmt.Errorf("cannot write '%v' since '%v' already exists with a different type (left by a previous snapshot?) - remove it or use --replace-conflicting-paths", targetFilePath, conflictingPath),
+		}
+	}
+
+	provider.logger.Infof("removing '%v' which conflicts with target path '%v'", conflictingPath, targetFilePath)
+	err = os.RemoveAll(conflictingPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove conflicting path '%v': %v", conflictingPath, err)
+	}
+	return write()
+}
@@ -0,0 +1,152 @@
+package git
+
+import (
+	"errors"
+	"gitsnap/options"
+	"gitsnap/util"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimTargetPath(t *testing.T) {
+	for _, onConflict := range []string{options.ON_CONFLICT_ERROR, options.ON_CONFLICT_SKIP, options.ON_CONFLICT_RENAME} {
+		provider := &repositoryProvider{
+			opts:         &options.Options{OnConflict: onConflict},
+			writtenPaths: newPathSet(),
+			logger:       options.NewStdLogger(),
+		}
+
+		targetFilePath, err := provider.claimTargetPath("a/b.txt", "/out/a/b.txt")
+		require.Nil(t, err)
+		require.Equal(t, "/out/a/b.txt", targetFilePath)
+
+		targetFilePath, err = provider.claimTargetPath("a/B.txt", "/out/a/b.txt")
+		switch onConflict {
+		case options.ON_CONFLICT_ERROR:
+			require.NotNil(t, err)
+		case options.ON_CONFLICT_SKIP:
+			require.Nil(t, err)
+			require.Empty(t, targetFilePath)
+		case options.ON_CONFLICT_RENAME:
+			require.Nil(t, err)
+			require.Equal(t, "/out/a/b_1.txt", targetFilePath)
+			targetFilePath, err = provider.claimTargetPath("A/b.txt", "/out/a/b.txt")
+			require.Nil(t, err)
+			require.Equal(t, "/out/a/b_2.txt", targetFilePath)
+		}
+	}
+}
+
+func TestSnapshotWithConflictingPathTypes(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":        "a",
+		"nested/b.txt": "b",
+	})
+	defer os.RemoveAll(clonePath)
+
+	for _, replace := range []bool{false, true} {
+		outputPath, err := os.MkdirTemp("", "")
+		require.Nil(t, err)
+		defer os.RemoveAll(outputPath)
+		writeFiles(outputPath, map[string]string{
+			"nested":        "file where a directory is needed",
+			"a.txt/old.txt": "directory where a file is needed",
+		})
+
+		err = Snapshot(&options.Options{
+			ClonePath:               clonePath,
+			Revision:                revision,
+			OutputPath:              outputPath,
+			IncludePatterns:         []string{},
+			ExcludePatterns:         []string{},
+			ReplaceConflictingPaths: replace,
+		})
+		if !replace {
+			var errorWithCode *util.ErrorWithCode
+			require.ErrorAs(t, err, &errorWithCode)
+			require.Equal(t, util.ERROR_BAD_OUTPUT_PATH, errorWithCode.StatusCode)
+			require.Contains(t, err.Error(), filepath.Join(outputPath, "a.txt"))
+			continue
+		}
+		require.Nil(t, err)
+		require.FileExists(t, filepath.Join(outputPath, "a.txt"))
+		require.FileExists(t, filepath.Join(outputPath, "nested", "b.txt"))
+	}
+}
+
+func TestIsWithinPath(t *testing.T) {
+	require.True(t, isWithinPath("/out", "/out/a.txt"))
+	require.True(t, isWithinPath("out", "out/a/../b.txt"))
+	require.True(t, isWithinPath("/out", "/out/..a.txt"))
+	require.False(t, isWithinPath("/out", "/out"))
+	require.False(t, isWithinPath("/out", "/out/../a.txt"))
+	require.False(t, isWithinPath("/out", "/outside/a.txt"))
+	require.False(t, isWithinPath("out", "out/a/../../../a.txt"))
+}
+
+func storeObject(t *testing.T, storer storage.Storer, encode func(object plumbing.EncodedObject) error) plumbing.Hash {
+	encoded := storer.NewEncodedObject()
+	require.Nil(t, encode(encoded))
+	hash, err := storer.SetEncodedObject(encoded)
+	require.Nil(t, err)
+	return hash
+}
+
+func TestSnapshotRefusesPathTraversal(t *testing.T) {
+	clonePath, _ := createLocalRepo(map[string]string{"a.txt": "a"})
+	defer os.RemoveAll(clonePath)
+
+	// git refuses such trees, so the commit is crafted directly in the object store
+	repository, err := git.PlainOpen(clonePath)
+	require.Nil(t, err)
+	storer := repository.Storer
+	blobHash := storeObject(t, storer, func(encoded plumbing.EncodedObject) error {
+		encoded.SetType(plumbing.BlobObject)
+		writer, err := encoded.Writer()
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write([]byte("evil"))
+		if err != nil {
+			return err
+		}
+		return writer.Close()
+	})
+	innerTreeHash := storeObject(t, storer, (&object.Tree{Entries: []object.TreeEntry{
+		{Name: "evil.txt", Mode: filemode.Regular, Hash: blobHash},
+	}}).Encode)
+	treeHash := storeObject(t, storer, (&object.Tree{Entries: []object.TreeEntry{
+		{Name: "..", Mode: filemode.Dir, Hash: innerTreeHash},
+		{Name: "a.txt", Mode: filemode.Regular, Hash: blobHash},
+	}}).Encode)
+	signature := object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()}
+	commitHash := storeObject(t, storer, (&object.Commit{
+		Author:    signature,
+		Committer: signature,
+		Message:   "traversal",
+		TreeHash:  treeHash,
+	}).Encode)
+
+	parentPath := t.TempDir()
+	outputPath := filepath.Join(parentPath, "out")
+	err = Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        commitHash.String(),
+		OutputPath:      outputPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+	})
+	var errorWithCode *util.ErrorWithCode
+	require.True(t, errors.As(err, &errorWithCode), "unexpected error: %v", err)
+	require.Equal(t, util.ERROR_PATH_TRAVERSAL, errorWithCode.StatusCode)
+	require.NoFileExists(t, filepath.Join(parentPath, "evil.txt"))
+}
@@ -0,0 +1,88 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// dedupKey identifies the written copies a file may be hard linked to. links share the permissions of the file,
+// so files of the same blob with different permissions are written separately.
+type dedupKey struct {
+	hash plumbing.Hash
+	perm os.FileMode
+}
+
+// dedupEntry is the first copy of a blob written by --dedup, closing written once it was written
+type dedupEntry struct {
+	written chan struct{}
+	// empty when the write failed
+	targetFilePath string
+}
+
+// writeDeduplicated writes a file with --dedup. the first file of a blob is written, and the others are hard linked
+// to it, falling back to writing them when the link fails, such as on file systems without hard links.
+// it returns whether the file was linked.
+// files are linked to a written copy and never to the object store - loose objects are zlib compressed with a
+// "blob <size>" header, and packed ones may be deltas, so no object file holds the contents as they are.
+func (provider *repositoryProvider) writeDeduplicated(outputPath string, targetFilePath string, hash plumbing.Hash, perm os.FileMode, write func() error) (bool, error) {
+	key := dedupKey{hash: hash, perm: perm}
+	provider.dedupMutex.Lock()
+	entry, found := provider.dedupEntries[key]
+	if !found {
+		entry = &dedupEntry{written: make(chan struct{})}
+		provider.dedupEntries[key] = entry
+	}
+	provider.dedupMutex.Unlock()
+
+	// a file left by a previous snapshot may be linked to others, which writing it in place would change as well
+	err := removeFile(targetFilePath)
+	if err != nil {
+		return false, err
+	}
+
+	if !found {
+		err = write()
+		if err == nil {
+			entry.targetFilePath = targetFilePath
+		}
+		close(entry.written)
+		return false, err
+	}
+
+	<-entry.written
+	if entry.targetFilePath != "" {
+		err = provider.writeReplacingConflicts(outputPath, targetFilePath, func() error {
+			return linkCreatingDirs(entry.targetFilePath, targetFilePath)
+		})
+		if err == nil {
+			return true, nil
+		}
+		provider.verboseLog("*** copying '%v' - failed to hard link it to '%v': %v", targetFilePath, entry.targetFilePath, err)
+	}
+	return false, write()
+}
+
+func linkCreatingDirs(existingFilePath string, targetFilePath string) error {
+	targetDirectoryPath := filepath.Dir(targetFilePath)
+	err := os.MkdirAll(targetDirectoryPath, TARGET_DIRECTORY_PERMISSIONS)
+	if err != nil {
+		return fmt.Errorf("failed to create target directory at '%v': %w", targetDirectoryPath, err)
+	}
+	return os.Link(existingFilePath, targetFilePath)
+}
+
+// removeFile removes a file, if there is one at the path
+func removeFile(filePath string) error {
+	info, err := os.Lstat(filePath)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	err = os.Remove(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to remove existing file at '%v': %v", filePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package git
+
+import (
+	"encoding/json"
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requireSameFile(t *testing.T, expected bool, firstPath string, secondPath string) {
+	first, err := os.Stat(firstPath)
+	require.Nil(t, err)
+	second, err := os.Stat(secondPath)
+	require.Nil(t, err)
+	require.Equal(t, expected, os.SameFile(first, second), "'%v' and '%v'", firstPath, secondPath)
+}
+
+func TestSnapshotWithDedup(t *testing.T) {
+	clonePath, firstRevision := createLocalRepo(map[string]string{
+		"a.txt":        "shared",
+		"vendor/a.txt": "shared",
+		"bin/run.sh":   "shared",
+		"b.txt":        "other",
+	})
+	defer os.RemoveAll(clonePath)
+	require.Nil(t, os.Chmod(filepath.Join(clonePath, "bin", "run.sh"), 0755))
+	firstRevision = commitFiles(clonePath, map[string]string{}, "tester <tester@example.com>")
+	secondRevision := commitFiles(clonePath, map[string]string{"vendor/a.txt": "changed"}, "tester <tester@example.com>")
+
+	outputPath := t.TempDir()
+	snapshot := func(revision string) *SnapshotResult {
+		result, err := SnapshotWithResult(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			Dedup:           true,
+		})
+		require.Nil(t, err)
+		return result
+	}
+
+	result := snapshot(firstRevision)
+	require.Equal(t, 4, result.FilesWritten)
+	require.Equal(t, 1, result.DedupedFiles)
+	require.Equal(t, int64(len("shared")), result.DedupedBytes)
+	requireSameFile(t, true, filepath.Join(outputPath, "a.txt"), filepath.Join(outputPath, "vendor", "a.txt"))
+	// links share the permissions, so the executable copy is written on its own
+	requireSameFile(t, false, filepath.Join(outputPath, "a.txt"), filepath.Join(outputPath, "bin", "run.sh"))
+	requireSameFile(t, false, filepath.Join(outputPath, "a.txt"), filepath.Join(outputPath, "b.txt"))
+
+	// rewriting a linked file doesn't change the file it was linked to
+	result = snapshot(secondRevision)
+	require.Equal(t, 0, result.DedupedFiles)
+	requireFileContents(t, filepath.Join(outputPath, "a.txt"), "shared")
+	requireFileContents(t, filepath.Join(outputPath, "vendor", "a.txt"), "changed")
+}
+
+func TestManifestWithDedup(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":        "shared",
+		"vendor/a.txt": "shared",
+		"b.txt":        "other",
+	})
+	defer os.RemoveAll(clonePath)
+
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	err := Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		ManifestPath:    manifestPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+		Dedup:           true,
+	})
+	require.Nil(t, err)
+
+	contents, err := os.ReadFile(manifestPath)
+	require.Nil(t, err)
+	manifest := &Manifest{}
+	require.Nil(t, json.Unmarshal(contents, manifest))
+	duplicates := map[string]string{}
+	for _, entry := range manifest.Files {
+		duplicates[entry.Path] = entry.DuplicateOf
+	}
+	require.Equal(t, map[string]string{"a.txt": "", "b.txt": "", "vendor/a.txt": "a.txt"}, duplicates)
+}
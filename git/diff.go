@@ -0,0 +1,131 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// loadChanges diffs the snapshot trees of the base and target commits, so only files added or modified since
+// the base are snapshotted. renames are seen as a deletion and an addition, unless --detect-renames is set.
+func (provider *repositoryProvider) loadChanges(baseCommit *object.Commit, commit *object.Commit) error {
+	baseTree, err := provider.getSnapshotTree(baseCommit)
+	if err != nil {
+		return err
+	}
+	tree, err := provider.getSnapshotTree(commit)
+	if err != nil {
+		return err
+	}
+
+	changes, err := provider.diffTrees(baseTree, tree)
+	if err != nil {
+		return fmt.Errorf("failed to diff '%v' and '%v': %v", baseCommit.Hash, commit.Hash, err)
+	}
+
+	provider.changedPaths = map[string]bool{}
+	provider.deletions = []*indexRecord{}
+	provider.renames = []*ManifestRename{}
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			return fmt.Errorf("failed to diff '%v' and '%v': %v", baseCommit.Hash, commit.Hash, err)
+		}
+		if action == merkletrie.Modify && change.From.Name != change.To.Name {
+			provider.addRename(change)
+			continue
+		}
+		switch action {
+		case merkletrie.Insert, merkletrie.Modify:
+			provider.changedPaths[change.To.Name] = true
+		case merkletrie.Delete:
+			provider.addDeletion(change)
+		}
+	}
+	sort.Slice(provider.deletions, func(i, j int) bool {
+		return provider.deletions[i].path < provider.deletions[j].path
+	})
+	sort.Slice(provider.renames, func(i, j int) bool {
+		return provider.renames[i].To < provider.renames[j].To
+	})
+
+	provider.verboseLog("%v files changed, %v deleted and %v renamed since '%v'", len(provider.changedPaths), len(provider.deletions), len(provider.renames), baseCommit.Hash)
+	return nil
+}
+
+func (provider *repositoryProvider) diffTrees(baseTree *object.Tree, tree *object.Tree) (object.Changes, error) {
+	if !provider.opts.DetectRenames {
+		return object.DiffTree(baseTree, tree)
+	}
+	// similarity is computed from the contents of the deleted and added blobs
+	return object.DiffTreeWithOptions(context.Background(), baseTree, tree, &object.DiffTreeOptions{
+		DetectRenames: true,
+		RenameScore:   uint(provider.opts.RenameThreshold),
+	})
+}
+
+func (provider *repositoryProvider) addDeletion(change *object.Change) {
+	if provider.matchesFilters(change.From.Name, change.From.TreeEntry.Mode) {
+		entry := change.From.TreeEntry
+		provider.deletions = append(provider.deletions, &indexRecord{path: change.From.Name, entry: &entry, linesOfCode: -1})
+	}
+}
+
+// addRename records a detected rename. the new path is snapshotted like an addition, and a rename from or to
+// a filtered out path is seen as a deletion or an addition only.
+func (provider *repositoryProvider) addRename(change *object.Change) {
+	provider.changedPaths[change.To.Name] = true
+	if !provider.matchesFilters(change.To.Name, change.To.TreeEntry.Mode) {
+		provider.addDeletion(change)
+		return
+	}
+	if provider.matchesFilters(change.From.Name, change.From.TreeEntry.Mode) {
+		provider.renames = append(provider.renames, &ManifestRename{From: change.From.Name, To: change.To.Name, Renamed: true})
+	}
+}
+
+func (provider *repositoryProvider) deletedPaths() []string {
+	if provider.deletions == nil {
+		return nil
+	}
+	paths := make([]string, len(provider.deletions))
+	for i, record := range provider.deletions {
+		paths[i] = record.path
+	}
+	return paths
+}
+
+// writeDeletionsFile lists the paths deleted since the base revision, in the format of the index file
+func (provider *repositoryProvider) writeDeletionsFile(deletionsFilePath string) error {
+	deletionsFile, err := os.Create(deletionsFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to create deletions file '%v': %v", deletionsFilePath, err)
+	}
+	defer deletionsFile.Close()
+
+	index, err := newIndexWriter(deletionsFile, provider.opts.IndexFormat, []string{"Path", "BlobId", "IsFile"})
+	if err != nil {
+		return fmt.Errorf("failed to write deletions file '%v': %v", deletionsFilePath, err)
+	}
+	for _, record := range provider.deletions {
+		err = index.write([]indexField{
+			{header: "Path", value: record.path},
+			{header: "BlobId", value: record.entry.Hash.String()},
+			{header: "IsFile", value: record.entry.Mode.IsFile()},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write deletions file '%v': %v", deletionsFilePath, err)
+		}
+	}
+	err = index.flush()
+	if err != nil {
+		return fmt.Errorf("failed to write deletions file '%v': %v", deletionsFilePath, err)
+	}
+
+	provider.logger.Infof("written %v deleted paths to '%v'", len(provider.deletions), deletionsFilePath)
+	return nil
+}
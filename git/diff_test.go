@@ -0,0 +1,142 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithBaseRevision(t *testing.T) {
+	clonePath, baseRevision := createLocalRepo(map[string]string{
+		"unchanged.txt":      "same",
+		"modified.txt":       "before",
+		"deleted.txt":        "gone",
+		"deleted.md":         "gone",
+		"nested/renamed.txt": "moved",
+	})
+	defer os.RemoveAll(clonePath)
+	runGit(clonePath, "rm", "-q", "deleted.txt", "deleted.md")
+	runGit(clonePath, "mv", "nested/renamed.txt", "nested/moved.txt")
+	revision := commitFiles(clonePath, map[string]string{
+		"modified.txt":     "after",
+		"nested/added.txt": "new",
+		"added.md":         "new",
+	}, "tester <tester@example.com>")
+
+	outputPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(outputPath)
+
+	deletionsFilePath := filepath.Join(t.TempDir(), "deletions.tsv")
+	err = Snapshot(&options.Options{
+		ClonePath:         clonePath,
+		Revision:          revision,
+		BaseRevision:      baseRevision,
+		OutputPath:        outputPath,
+		DeletionsFilePath: deletionsFilePath,
+		IncludePatterns:   []string{"**.txt"},
+		ExcludePatterns:   []string{},
+	})
+	require.Nil(t, err)
+
+	deletions, err := os.ReadFile(deletionsFilePath)
+	require.Nil(t, err)
+	require.Equal(t, fmt.Sprintf("Path\tBlobId\tIsFile\ndeleted.txt\t%v\ttrue\nnested/renamed.txt\t%v\ttrue\n",
+		runGit(clonePath, "rev-parse", baseRevision+":deleted.txt"),
+		runGit(clonePath, "rev-parse", baseRevision+":nested/renamed.txt"),
+	), string(deletions))
+
+	var written []string
+	err = filepath.WalkDir(outputPath, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			relativePath, _ := filepath.Rel(outputPath, path)
+			written = append(written, filepath.ToSlash(relativePath))
+		}
+		return err
+	})
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"modified.txt", "nested/added.txt", "nested/moved.txt"}, written)
+
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	err = Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		BaseRevision:    baseRevision,
+		ManifestPath:    manifestPath,
+		IncludePatterns: []string{"**.txt"},
+		ExcludePatterns: []string{},
+	})
+	require.Nil(t, err)
+
+	contents, err := os.ReadFile(manifestPath)
+	require.Nil(t, err)
+	manifest := &Manifest{}
+	require.Nil(t, json.Unmarshal(contents, manifest))
+	require.Len(t, manifest.Files, 3)
+	require.Equal(t, []string{"deleted.txt", "nested/renamed.txt"}, manifest.Deleted)
+}
+
+func TestSnapshotWithDetectRenames(t *testing.T) {
+	lines := ""
+	for i := 0; i < 20; i++ {
+		lines += fmt.Sprintf("line %v\n", i)
+	}
+	clonePath, baseRevision := createLocalRepo(map[string]string{
+		"moved.txt":    lines,
+		"edited.txt":   "edited\n" + lines,
+		"filtered.txt": lines + "filtered\n",
+		"deleted.txt":  "gone",
+	})
+	defer os.RemoveAll(clonePath)
+	runGit(clonePath, "rm", "-q", "deleted.txt", "moved.txt", "edited.txt", "filtered.txt")
+	revision := commitFiles(clonePath, map[string]string{
+		"nested/moved.txt":  lines,
+		"nested/edited.txt": "edited again\n" + lines,
+		"filtered.md":       lines + "filtered\n",
+	}, "tester <tester@example.com>")
+
+	snapshotManifest := func(renameThreshold int) *Manifest {
+		manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+		err := Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			BaseRevision:    baseRevision,
+			ManifestPath:    manifestPath,
+			IncludePatterns: []string{"**.txt"},
+			ExcludePatterns: []string{},
+			DetectRenames:   true,
+			RenameThreshold: renameThreshold,
+		})
+		require.Nil(t, err)
+		contents, err := os.ReadFile(manifestPath)
+		require.Nil(t, err)
+		manifest := &Manifest{}
+		require.Nil(t, json.Unmarshal(contents, manifest))
+		return manifest
+	}
+
+	manifest := snapshotManifest(options.DEFAULT_RENAME_THRESHOLD)
+	require.Equal(t, []*ManifestRename{
+		{From: "edited.txt", To: "nested/edited.txt", Renamed: true},
+		{From: "moved.txt", To: "nested/moved.txt", Renamed: true},
+	}, manifest.Renames)
+	// a rename to a filtered out path is a deletion
+	require.Equal(t, []string{"deleted.txt", "filtered.txt"}, manifest.Deleted)
+	var paths []string
+	for _, file := range manifest.Files {
+		paths = append(paths, file.Path)
+	}
+	require.ElementsMatch(t, []string{"nested/edited.txt", "nested/moved.txt"}, paths)
+
+	// only the identical file is similar enough
+	manifest = snapshotManifest(100)
+	require.Equal(t, []*ManifestRename{
+		{From: "moved.txt", To: "nested/moved.txt", Renamed: true},
+	}, manifest.Renames)
+	require.Equal(t, []string{"deleted.txt", "edited.txt", "filtered.txt"}, manifest.Deleted)
+}
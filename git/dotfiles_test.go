@@ -0,0 +1,67 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsHiddenPath(t *testing.T) {
+	require.True(t, isHiddenPath(".env"))
+	require.True(t, isHiddenPath("src/.editorconfig"))
+	require.True(t, isHiddenPath(".github/workflows/ci.yml"))
+	require.True(t, isHiddenPath("infra/.terraform/modules/main.tf"))
+	require.False(t, isHiddenPath("src/main.go"))
+	require.False(t, isHiddenPath("docs/v1.2/notes.md"))
+	require.False(t, isHiddenPath("src/file.env"))
+}
+
+func TestSnapshotWithExcludeDotfiles(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"src/main.go":                      "package main",
+		"docs/v1.2/notes.md":               "notes",
+		".env":                             "SECRET=1",
+		"src/.editorconfig":                "root = true",
+		".github/workflows/ci.yml":         "on: push",
+		"infra/.terraform/modules/main.tf": "module",
+	})
+	defer os.RemoveAll(clonePath)
+	hidden := []string{".env", "src/.editorconfig", ".github/workflows/ci.yml", "infra/.terraform/modules/main.tf"}
+
+	for _, test := range []struct {
+		args     []string
+		written  []string
+		excluded []string
+	}{
+		{
+			args:    []string{},
+			written: append([]string{"src/main.go", "docs/v1.2/notes.md"}, hidden...),
+		},
+		{
+			args:     []string{"--exclude-dotfiles"},
+			written:  []string{"src/main.go", "docs/v1.2/notes.md"},
+			excluded: hidden,
+		},
+		{
+			// hidden paths are skipped even if they match include patterns
+			args:     []string{"--exclude-dotfiles", "--include", "**/*.yml,src/**"},
+			written:  []string{"src/main.go"},
+			excluded: hidden,
+		},
+	} {
+		outputPath := t.TempDir()
+		opts, err := options.ParseArgs(append([]string{"--src", clonePath, "--rev", revision, "--out", outputPath}, test.args...))
+		require.Nil(t, err)
+		require.Nil(t, Snapshot(opts))
+
+		for _, filePath := range test.written {
+			require.FileExists(t, filepath.Join(outputPath, filePath), "%v", test.args)
+		}
+		for _, filePath := range test.excluded {
+			require.NoFileExists(t, filepath.Join(outputPath, filePath), "%v", test.args)
+		}
+	}
+}
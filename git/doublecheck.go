@@ -0,0 +1,87 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gitsnap/util"
+	"hash"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// filesDigest is the digest of the paths and blob ids of files, in tree order, by --double-check hash
+type filesDigest struct {
+	hash  hash.Hash
+	count int
+}
+
+func newFilesDigest() *filesDigest {
+	return &filesDigest{hash: sha256.New()}
+}
+
+func (digest *filesDigest) add(name string, blobHash plumbing.Hash) {
+	_, _ = fmt.Fprintf(digest.hash, "%v\x00%v\n", name, blobHash)
+	digest.count++
+}
+
+func (digest *filesDigest) String() string {
+	return hex.EncodeToString(digest.hash.Sum(nil))
+}
+
+// recordWrittenFiles keeps the digest of the files written by the snapshot, and the paths chosen by selectFile,
+// whose filters read the blobs and are not applied again by verifyWrittenDigest
+func (provider *repositoryProvider) recordWrittenFiles(records []*indexRecord) {
+	provider.writtenDigest = newFilesDigest()
+	provider.selectedPaths = map[string]bool{}
+	for _, record := range records {
+		if !record.entry.Mode.IsFile() {
+			continue
+		}
+		if record.selected {
+			provider.selectedPaths[record.path] = true
+		}
+		if record.snapped {
+			provider.writtenDigest.add(record.path, record.entry.Hash)
+		}
+	}
+}
+
+// verifyWrittenDigest walks the snapshot tree once more, only reading its tree objects, and fails if the digest of the
+// written files doesn't match the one of the entries passing the path filters which selectFile chose, so files which
+// were dropped, skipped or failed to write, as well as entries replaced by others, are caught
+func (provider *repositoryProvider) verifyWrittenDigest(commit *object.Commit) error {
+	tree, err := provider.getSnapshotTree(commit)
+	if err != nil {
+		return err
+	}
+	treeWalker := provider.newSnapshotWalker(commit, tree)
+	defer treeWalker.Close()
+
+	expected := newFilesDigest()
+	for {
+		provider.storeMutex.Lock()
+		name, entry, walkErr := treeWalker.Next()
+		provider.storeMutex.Unlock()
+		if walkErr == io.EOF {
+			break
+		}
+		if walkErr != nil {
+			return fmt.Errorf("failed to iterate files of %v: %v", commit.Hash, walkErr)
+		}
+		if provider.shouldInclude(name, entry.Mode) && provider.selectedPaths[name] {
+			expected.add(name, entry.Hash)
+		}
+	}
+
+	written := provider.writtenDigest
+	if expected.String() != written.String() {
+		return &util.ErrorWithCode{
+			StatusCode:    util.ERROR_FILES_DISCREPANCY,
+			InternalError: fmt.Errorf("digest of the %v files to write is %v, but the %v written files have digest %v", expected.count, expected, written.count, written),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package git
+
+import (
+	"errors"
+	"gitsnap/options"
+	"gitsnap/util"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithDoubleCheckHash(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":         "a",
+		"dir/b.txt":     "b",
+		"dir/sub/c.txt": "c",
+	})
+	defer os.RemoveAll(clonePath)
+
+	opts := &options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      t.TempDir(),
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{"**/b.txt"},
+		DoubleCheck:     options.DOUBLE_CHECK_HASH,
+	}
+	require.Nil(t, Snapshot(opts))
+	requireFileContents(t, filepath.Join(opts.OutputPath, "dir", "sub", "c.txt"), "c")
+	require.NoFileExists(t, filepath.Join(opts.OutputPath, "dir", "b.txt"))
+
+	provider, err := newRepositoryProvider(opts)
+	require.Nil(t, err)
+	commit, err := provider.getCommit(revision)
+	require.Nil(t, err)
+	_, err = provider.snapshot(provider.repository, commit, t.TempDir(), "", false, false)
+	require.Nil(t, err)
+	require.Equal(t, 2, provider.writtenDigest.count)
+	require.Nil(t, provider.verifyWrittenDigest(commit))
+
+	// the entries of another tree don't match the written ones
+	otherRevision := commitFiles(clonePath, map[string]string{"dir/sub/c.txt": "changed"}, "tester <tester@example.com>")
+	otherCommit, err := provider.getCommit(otherRevision)
+	require.Nil(t, err)
+	requireFilesDiscrepancy(t, provider.verifyWrittenDigest(otherCommit))
+}
+
+func TestSnapshotWithDoubleCheckHashFailsOnSkippedFiles(t *testing.T) {
+	_, missingPointer := lfsPointerOf("never fetched")
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":       "a",
+		"missing.bin": missingPointer,
+	})
+	defer os.RemoveAll(clonePath)
+
+	opts := &options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      t.TempDir(),
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+		DoubleCheck:     options.DOUBLE_CHECK_HASH,
+	}
+	require.Nil(t, Snapshot(opts))
+
+	// the missing LFS object is selected, but skipped while writing
+	opts.OutputPath = t.TempDir()
+	opts.ResolveLFS = true
+	requireFilesDiscrepancy(t, Snapshot(opts))
+}
+
+func requireFilesDiscrepancy(t *testing.T, err error) {
+	var errorWithCode *util.ErrorWithCode
+	require.True(t, errors.As(err, &errorWithCode), "%v", err)
+	require.Equal(t, util.ERROR_FILES_DISCREPANCY, errorWithCode.StatusCode)
+}
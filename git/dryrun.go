@@ -0,0 +1,85 @@
+package git
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// listSnapshotFiles writes a tab separated list of the files a snapshot of the commit would write, without writing them
+func (provider *repositoryProvider) listSnapshotFiles(commit *object.Commit, output io.Writer) (int, error) {
+	csvWriter := csv.NewWriter(output)
+	csvWriter.Comma = '\t'
+	defer csvWriter.Flush()
+	err := csvWriter.Write([]string{"Path", "SizeBytes", "BlobId"})
+	if err != nil {
+		return 0, fmt.Errorf("failed to write dry run headers: %v", err)
+	}
+
+	count := 0
+	err = provider.walkSnapshotFiles(commit, func(name string, entry *object.TreeEntry, file *object.File) error {
+		err := csvWriter.Write([]string{name, strconv.FormatInt(file.Size, 10), entry.Hash.String()})
+		if err != nil {
+			return fmt.Errorf("failed to write dry run entry of '%v': %v", name, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// walkSnapshotFiles calls visit with every file a snapshot of the commit would write, applying all the filters
+// without reading the contents of the files, unless a filter needs them
+func (provider *repositoryProvider) walkSnapshotFiles(commit *object.Commit, visit func(name string, entry *object.TreeEntry, file *object.File) error) error {
+	tree, err := provider.getSnapshotTree(commit)
+	if err != nil {
+		return err
+	}
+
+	treeWalker := provider.newSnapshotWalker(commit, tree)
+	defer treeWalker.Close()
+
+	for {
+		name, entry, walkErr := treeWalker.Next()
+		if walkErr == io.EOF {
+			return nil
+		}
+		if walkErr != nil {
+			return fmt.Errorf("failed to iterate files of %v: %v", commit.Hash, walkErr)
+		}
+
+		file, err := provider.selectFile(name, &entry)
+		if err != nil {
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				provider.logger.Infof("--- skipping '%v' - blob %v is missing from the object store (partial clone?): %v", name, entry.Hash, err)
+				continue
+			}
+			return err
+		}
+		if file == nil {
+			continue
+		}
+
+		err = visit(name, &entry, file)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (provider *repositoryProvider) dryRun(commit *object.Commit) error {
+	count, err := provider.listSnapshotFiles(commit, provider.outputStream())
+	if err != nil {
+		return err
+	}
+	provider.logger.Infof("dry run - would write %v files", count)
+	return nil
+}
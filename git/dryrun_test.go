@@ -0,0 +1,55 @@
+package git
+
+import (
+	"bytes"
+	"gitsnap/options"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListSnapshotFiles(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":        "a",
+		"nested/b.txt": "bb",
+		"nested/c.md":  "c",
+	})
+	defer os.RemoveAll(clonePath)
+
+	provider, err := newRepositoryProvider(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		IncludePatterns: []string{"*.txt"},
+		ExcludePatterns: []string{},
+		DryRun:          true,
+	})
+	require.Nil(t, err)
+	commit, err := provider.getCommit(revision)
+	require.Nil(t, err)
+
+	output := &bytes.Buffer{}
+	count, err := provider.listSnapshotFiles(commit, output)
+	require.Nil(t, err)
+	require.Equal(t, 2, count)
+	require.Equal(t, "Path\tSizeBytes\tBlobId\n"+
+		"a.txt\t1\t"+runGit(clonePath, "rev-parse", "HEAD:a.txt")+"\n"+
+		"nested/b.txt\t2\t"+runGit(clonePath, "rev-parse", "HEAD:nested/b.txt")+"\n", output.String())
+}
+
+func TestSnapshotDryRunToOutputWriter(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":       "a",
+		"nested/c.md": "c",
+	})
+	defer os.RemoveAll(clonePath)
+
+	opts, err := options.ParseArgs([]string{"--src", clonePath, "--rev", revision, "--dry-run"})
+	require.Nil(t, err)
+	output := &bytes.Buffer{}
+	opts.OutputWriter = output
+	require.Nil(t, Snapshot(opts))
+	require.Equal(t, "Path\tSizeBytes\tBlobId\n"+
+		"a.txt\t1\t"+runGit(clonePath, "rev-parse", "HEAD:a.txt")+"\n"+
+		"nested/c.md\t1\t"+runGit(clonePath, "rev-parse", "HEAD:nested/c.md")+"\n", output.String())
+}
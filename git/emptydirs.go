@@ -0,0 +1,36 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// placeholder files committed to keep a directory which is otherwise empty, since git doesn't track directories
+var keepFileNames = map[string]bool{
+	".gitkeep": true,
+	".keep":    true,
+}
+
+// createKeptDirectories creates the directories holding a placeholder file which was not written, such as when it
+// is filtered out by the include patterns, so the directories exist in the output like in the tree
+func (provider *repositoryProvider) createKeptDirectories(outputPath string, records []*indexRecord) error {
+	for _, record := range records {
+		if record.snapped || !record.entry.Mode.IsFile() || !keepFileNames[path.Base(record.path)] {
+			continue
+		}
+		dirPath := path.Dir(record.path)
+		targetDirectoryPath := filepath.Join(outputPath, filepath.FromSlash(dirPath))
+		if !isWithinPath(outputPath, targetDirectoryPath) {
+			provider.logger.Infof("--- skipping directory '%v' - it is outside the output path", dirPath)
+			continue
+		}
+		provider.verboseLog("*** creating directory '%v' of '%v'", dirPath, record.path)
+		err := os.MkdirAll(targetDirectoryPath, TARGET_DIRECTORY_PERMISSIONS)
+		if err != nil {
+			return fmt.Errorf("failed to create directory at '%v': %w", targetDirectoryPath, err)
+		}
+	}
+	return nil
+}
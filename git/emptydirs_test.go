@@ -0,0 +1,46 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithKeepEmptyDirs(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"src/main/java/App.java":      "class App {}",
+		"src/main/resources/.gitkeep": "",
+		"logs/.keep":                  "",
+		"docs/readme.md":              "# docs",
+	})
+	defer os.RemoveAll(clonePath)
+
+	for _, keepEmptyDirs := range []bool{false, true} {
+		outputPath := t.TempDir()
+		err := Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{"**/*.java"},
+			ExcludePatterns: []string{},
+			KeepEmptyDirs:   keepEmptyDirs,
+		})
+		require.Nil(t, err)
+
+		require.FileExists(t, filepath.Join(outputPath, "src", "main", "java", "App.java"))
+		require.NoFileExists(t, filepath.Join(outputPath, "src", "main", "resources", ".gitkeep"))
+		require.NoFileExists(t, filepath.Join(outputPath, "logs", ".keep"))
+		// a directory without a placeholder is not kept
+		require.NoDirExists(t, filepath.Join(outputPath, "docs"))
+		if keepEmptyDirs {
+			require.DirExists(t, filepath.Join(outputPath, "src", "main", "resources"))
+			require.DirExists(t, filepath.Join(outputPath, "logs"))
+		} else {
+			require.NoDirExists(t, filepath.Join(outputPath, "src", "main", "resources"))
+			require.NoDirExists(t, filepath.Join(outputPath, "logs"))
+		}
+	}
+}
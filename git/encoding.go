@@ -0,0 +1,38 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"gitsnap/options"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// transcodesContents checks whether --encoding applies to the file
+func (provider *repositoryProvider) transcodesContents(filePath string) bool {
+	return provider.opts.Encoding == options.ENCODING_UTF8 && provider.isTextFile(filePath)
+}
+
+// transcodeToUTF8 decodes the contents from the encoding charset.DetermineEncoding detects, like decodeContents.
+// an empty encoding name means the contents are returned as they are, being UTF-8 already or binary.
+func transcodeToUTF8(contents []byte) ([]byte, string, error) {
+	if utf8.Valid(contents) {
+		return contents, "", nil
+	}
+	encoding, name, _ := charset.DetermineEncoding(contents, "")
+	// the head of the contents is UTF-8, so the rest of them is not guessed at
+	if name == "utf-8" {
+		return contents, "", nil
+	}
+	// only UTF-16 text holds NUL bytes
+	if !strings.HasPrefix(name, "utf-16") && bytes.IndexByte(contents, 0) >= 0 {
+		return contents, "", nil
+	}
+	decoded, err := encoding.NewDecoder().Bytes(contents)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode %v: %v", name, err)
+	}
+	return decoded, name, nil
+}
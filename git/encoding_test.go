@@ -0,0 +1,65 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscodeToUTF8(t *testing.T) {
+	transcoded, encodingName, err := transcodeToUTF8([]byte("caf\xe9 cr\xe8me"))
+	require.Nil(t, err)
+	require.Equal(t, "windows-1252", encodingName)
+	require.Equal(t, "café crème", string(transcoded))
+
+	for _, contents := range []string{"plain", "café", "", "binary\x00\xff"} {
+		transcoded, encodingName, err = transcodeToUTF8([]byte(contents))
+		require.Nil(t, err)
+		require.Empty(t, encodingName)
+		require.Equal(t, contents, string(transcoded))
+	}
+}
+
+func TestSnapshotWithEncoding(t *testing.T) {
+	files := map[string]string{
+		"latin1.txt":  "caf\xe9",
+		"utf8.txt":    "café",
+		"utf16le.txt": UTF16LE_BOM + "h\x00\xe9\x00",
+		"binary.txt":  "\x00\xe9",
+		"image.png":   "\x89PNG\xe9",
+	}
+	clonePath, revision := createLocalRepo(files)
+	defer os.RemoveAll(clonePath)
+
+	for _, stripBOM := range []bool{false, true} {
+		outputPath := t.TempDir()
+		err := Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			Encoding:        options.ENCODING_UTF8,
+			StripBOM:        stripBOM,
+		})
+		require.Nil(t, err)
+
+		requireFileContents(t, filepath.Join(outputPath, "latin1.txt"), "café")
+		contents, err := os.ReadFile(filepath.Join(outputPath, "latin1.txt"))
+		require.Nil(t, err)
+		require.True(t, utf8.Valid(contents))
+		requireFileContents(t, filepath.Join(outputPath, "utf8.txt"), "café")
+		requireFileContents(t, filepath.Join(outputPath, "binary.txt"), files["binary.txt"])
+		requireFileContents(t, filepath.Join(outputPath, "image.png"), files["image.png"])
+		// the byte order mark is transcoded as well, unless it is stripped
+		if stripBOM {
+			requireFileContents(t, filepath.Join(outputPath, "utf16le.txt"), "hé")
+		} else {
+			requireFileContents(t, filepath.Join(outputPath, "utf16le.txt"), UTF8_BOM+"hé")
+		}
+	}
+}
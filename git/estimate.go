@@ -0,0 +1,45 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// SnapshotEstimate is what --estimate reports of the files a snapshot would write
+type SnapshotEstimate struct {
+	Commit string `json:"commit"`
+	Files  int    `json:"files"`
+	// Bytes is the sum of the sizes of the blobs, before --dedup or any other rewriting of the contents
+	Bytes int64 `json:"bytes"`
+	// ExceedsMaxTotalSize tells whether the snapshot would fail by --max-total-size, unset without it
+	ExceedsMaxTotalSize *bool `json:"exceedsMaxTotalSize,omitempty"`
+}
+
+// estimate writes the number and total size of the files a snapshot of the commit would write as JSON, taking the
+// sizes from the blob headers instead of reading their contents
+func (provider *repositoryProvider) estimate(commit *object.Commit) error {
+	estimate := SnapshotEstimate{Commit: commit.Hash.String()}
+	err := provider.walkSnapshotFiles(commit, func(name string, entry *object.TreeEntry, file *object.File) error {
+		estimate.Files++
+		estimate.Bytes += file.Size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if provider.opts.MaxTotalSizeBytes > 0 {
+		exceeds := estimate.Bytes > provider.opts.MaxTotalSizeBytes
+		estimate.ExceedsMaxTotalSize = &exceeds
+	}
+
+	encoder := json.NewEncoder(provider.outputStream())
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(estimate)
+	if err != nil {
+		return fmt.Errorf("failed to write estimate: %v", err)
+	}
+	provider.logger.Infof("estimate - would write %v files of %v bytes", estimate.Files, estimate.Bytes)
+	return nil
+}
@@ -0,0 +1,57 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"gitsnap/options"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotEstimate(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"src/main.go":       "package main",
+		"src/util/util.go":  "package util",
+		"docs/readme.md":    "# docs",
+		"node_modules/a.js": "a",
+		"assets/large.bin":  strings.Repeat("x", 2*1024*1024),
+	})
+	defer os.RemoveAll(clonePath)
+
+	estimate := func(args ...string) SnapshotEstimate {
+		opts, err := options.ParseArgs(append([]string{"--src", clonePath, "--rev", revision, "--estimate"}, args...))
+		require.Nil(t, err)
+		output := &bytes.Buffer{}
+		opts.OutputWriter = output
+		require.Nil(t, Snapshot(opts))
+		var result SnapshotEstimate
+		require.Nil(t, json.Unmarshal(output.Bytes(), &result))
+		require.Equal(t, revision, result.Commit)
+		return result
+	}
+
+	result := estimate()
+	require.Equal(t, 4, result.Files)
+	require.Equal(t, int64(len("package main")+len("package util")+len("# docs")+2*1024*1024), result.Bytes)
+	require.Nil(t, result.ExceedsMaxTotalSize)
+
+	result = estimate("--include", "**/*.go")
+	require.Equal(t, 2, result.Files)
+	require.Equal(t, int64(len("package main")+len("package util")), result.Bytes)
+
+	result = estimate("--exclude", "src/util/**", "--max-size", "1")
+	require.Equal(t, 2, result.Files)
+	require.Equal(t, int64(len("package main")+len("# docs")), result.Bytes)
+
+	result = estimate("--max-total-size", "1")
+	require.Equal(t, 4, result.Files)
+	require.True(t, *result.ExceedsMaxTotalSize)
+
+	// nothing is written, so the output path is not created
+	outputPath := t.TempDir() + "/out"
+	estimate("--out", outputPath)
+	require.NoDirExists(t, outputPath)
+}
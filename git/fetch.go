@@ -0,0 +1,103 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+)
+
+// getBlob returns a blob from the object store, fetching it from the remote with --fetch-missing. the store is not
+// locked during the fetch, so other workers keep reading it.
+func (provider *repositoryProvider) getBlob(hash plumbing.Hash) (*object.Blob, error) {
+	provider.storeMutex.Lock()
+	blob, err := provider.storedBlob(hash)
+	provider.storeMutex.Unlock()
+	if !errors.Is(err, plumbing.ErrObjectNotFound) || !provider.opts.FetchMissing {
+		return blob, err
+	}
+
+	if provider.fetchedCount.Add(1) > int32(provider.opts.MaxFetches) {
+		provider.verboseLog("--- not fetching missing blob %v - reached the limit of %v fetches", hash, provider.opts.MaxFetches)
+		return nil, err
+	}
+
+	fetchErr := provider.fetchObject(hash)
+	if fetchErr != nil {
+		provider.logger.Infof("failed to fetch missing blob %v from '%v': %v", hash, git.DefaultRemoteName, fetchErr)
+		return nil, err
+	}
+	provider.verboseLog("fetched missing blob %v from '%v'", hash, git.DefaultRemoteName)
+
+	provider.storeMutex.Lock()
+	defer provider.storeMutex.Unlock()
+	return object.GetBlob(provider.repository.Storer, hash)
+}
+
+// fetchObject asks the remote for a single object without negotiating local haves,
+// since a regular fetch would omit blobs reachable from commits the clone already has.
+// the pack is received before locking the object store to add it.
+func (provider *repositoryProvider) fetchObject(hash plumbing.Hash) (err error) {
+	remote, err := provider.repository.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return err
+	}
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return fmt.Errorf("remote '%v' has no url", git.DefaultRemoteName)
+	}
+
+	endpoint, err := transport.NewEndpoint(urls[0])
+	if err != nil {
+		return err
+	}
+	transportClient, err := client.NewClient(endpoint)
+	if err != nil {
+		return err
+	}
+	session, err := transportClient.NewUploadPackSession(endpoint, nil)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	advertisedRefs, err := session.AdvertisedReferences()
+	if err != nil {
+		return err
+	}
+
+	request := packp.NewUploadPackRequestFromCapabilities(advertisedRefs.Capabilities)
+	request.Capabilities.Delete(capability.Sideband64k)
+	request.Capabilities.Delete(capability.Sideband)
+	request.Capabilities.Delete(capability.ThinPack)
+	request.Wants = []plumbing.Hash{hash}
+
+	response, err := session.UploadPack(provider.ctx, request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := response.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	pack, err := io.ReadAll(response)
+	if err != nil {
+		return err
+	}
+
+	provider.storeMutex.Lock()
+	defer provider.storeMutex.Unlock()
+	return packfile.UpdateObjectStorage(provider.repository.Storer, bytes.NewReader(pack))
+}
@@ -0,0 +1,46 @@
+package git
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	// replaces the slashes of tree paths in --flatten file names
+	FLATTEN_SEPARATOR = "__"
+	SHORT_HASH_LENGTH = 7
+)
+
+// flatFileName returns the name of a file written by --flatten, its path with the slashes replaced. names too long
+// for the file system keep their end, which holds the extension, prefixed by the short blob hash.
+// room is left for the short hash suffix which is appended on a collision.
+func flatFileName(filePath string, hash plumbing.Hash) string {
+	name := strings.ReplaceAll(filePath, "/", FLATTEN_SEPARATOR)
+	maxLength := MAX_FILE_NAME_LENGTH - len("_") - SHORT_HASH_LENGTH
+	if len(name) <= maxLength {
+		return name
+	}
+	prefix := hash.String()[:SHORT_HASH_LENGTH] + "_"
+	tail := name[len(name)-(maxLength-len(prefix)):]
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+	}
+	return prefix + tail
+}
+
+// claimFlatTargetPath claims the target path of a file written by --flatten under the root, appending the short
+// blob hash to the name if it was already written, and applying --on-conflict if that was written as well
+func (provider *repositoryProvider) claimFlatTargetPath(rootPath string, filePath string, hash plumbing.Hash) (string, error) {
+	targetFilePath := filepath.Join(rootPath, flatFileName(filePath, hash))
+	if provider.writtenPaths.add(targetFilePath) {
+		return targetFilePath, nil
+	}
+	extension := filepath.Ext(targetFilePath)
+	hashedTargetFilePath := fmt.Sprintf("%v_%v%v", strings.TrimSuffix(targetFilePath, extension), hash.String()[:SHORT_HASH_LENGTH], extension)
+	provider.verboseLog("*** renaming '%v' to '%v' - flattened path was already written", filePath, hashedTargetFilePath)
+	return provider.claimTargetPath(filePath, hashedTargetFilePath)
+}
@@ -0,0 +1,90 @@
+package git
+
+import (
+	"encoding/csv"
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlatFileName(t *testing.T) {
+	hash := plumbing.NewHash("0123456789abcdef0123456789abcdef01234567")
+	require.Equal(t, "main.go", flatFileName("main.go", hash))
+	require.Equal(t, "src__main__App.java", flatFileName("src/main/App.java", hash))
+
+	longPath := strings.Repeat("directory/", 30) + "App.java"
+	name := flatFileName(longPath, hash)
+	require.LessOrEqual(t, len(name), MAX_FILE_NAME_LENGTH-len("_")-SHORT_HASH_LENGTH)
+	require.True(t, strings.HasPrefix(name, "0123456_"))
+	require.True(t, strings.HasSuffix(name, "__directory__App.java"))
+
+	// multi-byte characters are not cut
+	name = flatFileName(strings.Repeat("é/", 100)+"App.java", hash)
+	require.True(t, strings.HasPrefix(name, "0123456_"))
+	require.True(t, utf8.ValidString(name))
+}
+
+func TestSnapshotWithFlatten(t *testing.T) {
+	longPath := strings.Repeat("directory/", 30) + "Long.java"
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a/b/c.go":  "package b",
+		"a__b/c.go": "package a__b",
+		"main.go":   "package main",
+		longPath:    "class Long {}",
+	})
+	defer os.RemoveAll(clonePath)
+
+	outputPath := t.TempDir()
+	indexPath := filepath.Join(t.TempDir(), "index.csv")
+	err := Snapshot(&options.Options{
+		ClonePath:             clonePath,
+		Revision:              revision,
+		OutputPath:            outputPath,
+		IncludePatterns:       []string{},
+		ExcludePatterns:       []string{},
+		OptionalIndexFilePath: indexPath,
+		Workers:               1,
+		Flatten:               true,
+	})
+	require.Nil(t, err)
+
+	entries, err := os.ReadDir(outputPath)
+	require.Nil(t, err)
+	for _, entry := range entries {
+		require.False(t, entry.IsDir(), "'%v' is a directory", entry.Name())
+	}
+	require.Len(t, entries, 4)
+
+	file, err := os.Open(indexPath)
+	require.Nil(t, err)
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comma = '\t'
+	records, err := reader.ReadAll()
+	require.Nil(t, err)
+	require.Equal(t, "TargetPath", records[0][len(records[0])-1])
+	targetPaths := map[string]string{}
+	for _, record := range records[1:] {
+		targetPaths[record[0]] = record[len(record)-1]
+	}
+
+	requireFileContents(t, filepath.Join(outputPath, "main.go"), "package main")
+	require.Equal(t, "main.go", targetPaths["main.go"])
+	// the tree walk reaches a/b/c.go first, so the other file gets the short blob id
+	requireFileContents(t, filepath.Join(outputPath, "a__b__c.go"), "package b")
+	require.Equal(t, "a__b__c.go", targetPaths["a/b/c.go"])
+	collided := targetPaths["a__b/c.go"]
+	require.Regexp(t, `^a__b__c_[0-9a-f]{7}\.go$`, collided)
+	requireFileContents(t, filepath.Join(outputPath, collided), "package a__b")
+	// a path too long for a file name is shortened
+	shortened := targetPaths[longPath]
+	require.True(t, strings.HasSuffix(shortened, "__Long.java"))
+	require.LessOrEqual(t, len(shortened), MAX_FILE_NAME_LENGTH)
+	requireFileContents(t, filepath.Join(outputPath, shortened), "class Long {}")
+}
@@ -1,23 +1,32 @@
 package git
 
 import (
+	"bytes"
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"gitsnap/options"
+	"gitsnap/parallel"
+	"gitsnap/stats"
 	"gitsnap/util"
 	"io"
-	"log"
 	"os"
+	"path"
 	"path/filepath"
-	"strconv"
+	"regexp"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode/utf8"
 
 	"github.com/avast/retry-go"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/go-git/go-git/v5/plumbing/object"
 
 	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
@@ -25,46 +34,224 @@ import (
 )
 
 const (
-	TARGET_PERMISSIONS = 0777
+	TARGET_PERMISSIONS           = 0644
+	TARGET_DIRECTORY_PERMISSIONS = 0755
+
+	EXTENSIONS_SECTION   = "extensions"
+	OBJECT_FORMAT_OPTION = "objectformat"
+	OBJECT_FORMAT_SHA1   = "sha1"
 )
 
+// git accepts abbreviated hashes of at least 4 characters
+var shortShaPattern = regexp.MustCompile(`^[0-9a-fA-F]{4,39}$`)
+
 type repositoryProvider struct {
 	repository      *git.Repository
-	includePatterns []glob.Glob
-	excludePatterns []glob.Glob
-	fileListToSnap  map[string]bool
-	opts            *options.Options
+	includePatterns []pathPattern
+	excludePatterns *excludeMatcher
+	// matched against file names, by --name-include and --name-exclude
+	nameIncludePatterns []pathPattern
+	nameExcludePatterns []pathPattern
+	fileListToSnap      map[string]bool
+	opts                *options.Options
+	fetchedCount        atomic.Int32
+	totalSize           atomic.Int64
+	writtenPaths        *pathSet
+	commit              *object.Commit
+	archive             archiveWriter
+	// go-git object storage is not safe for concurrent use, so reads from it are serialized
+	storeMutex sync.Mutex
+
+	generatedAuthorPattern *regexp.Regexp
+	gitignore              gitignore.Matcher
+	textAttributes         *textAttributes
+	changedPaths           map[string]bool
+	deletions              []*indexRecord
+	renames                []*ManifestRename
+	recentChanges          map[string]*object.Commit
+	result                 SnapshotResult
+	logger                 options.Logger
+	progress               *progressReporter
+	// repositories of the submodules opened by --recurse-submodules, by their git directory
+	submodules      map[string]*git.Repository
+	submodulesMutex sync.Mutex
+	// the first written copy of every blob, by --dedup
+	dedupEntries map[dedupKey]*dedupEntry
+	dedupMutex   sync.Mutex
+	// sizes of the files being dumped, by --max-inflight-bytes
+	inflight *parallel.Weighted
+	// digest of the files written by the writing pass, and the paths it selected, by --double-check hash
+	writtenDigest *filesDigest
+	selectedPaths map[string]bool
+	// canceling it stops the walks and the jobs which did not start yet
+	ctx context.Context
 }
 
-func Snapshot(opts *options.Options) (err error) {
+func newRepositoryProvider(opts *options.Options) (provider *repositoryProvider, err error) {
 
-	provider := &repositoryProvider{
+	provider = &repositoryProvider{
 		opts:           opts,
 		fileListToSnap: map[string]bool{},
+		writtenPaths:   newPathSet(),
+		dedupEntries:   map[dedupKey]*dedupEntry{},
+		logger:         opts.Logger,
+		ctx:            context.Background(),
+	}
+	if provider.logger == nil {
+		provider.logger = options.NewStdLogger()
 	}
 
 	err = loadFilePathsList(opts, provider)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	provider.includePatterns, err = provider.compileGlobs(opts.IncludePatterns, "include")
 	if err != nil {
-		return fmt.Errorf("failed to compile include patterns '%v': %v", opts.IncludePatterns, err)
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_PATTERN,
+			InternalError: fmt.Errorf("failed to compile include patterns '%v': %v", opts.IncludePatterns, err),
+		}
 	}
-	provider.excludePatterns, err = provider.compileGlobs(opts.ExcludePatterns, "exclude")
+	provider.excludePatterns, err = provider.compileExcludePatterns(opts.ExcludePatterns)
 	if err != nil {
-		return fmt.Errorf("failed to compile exclude patterns '%v': %v", opts.ExcludePatterns, err)
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_PATTERN,
+			InternalError: fmt.Errorf("failed to compile exclude patterns '%v': %v", opts.ExcludePatterns, err),
+		}
 	}
 
-	provider.repository, err = git.PlainOpen(opts.ClonePath)
+	provider.nameIncludePatterns, err = provider.compileNamePatterns(opts.NameIncludePatterns, "name include")
 	if err != nil {
-		return &util.ErrorWithCode{
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_PATTERN,
+			InternalError: fmt.Errorf("failed to compile name include patterns '%v': %v", opts.NameIncludePatterns, err),
+		}
+	}
+	provider.nameExcludePatterns, err = provider.compileNamePatterns(opts.NameExcludePatterns, "name exclude")
+	if err != nil {
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_PATTERN,
+			InternalError: fmt.Errorf("failed to compile name exclude patterns '%v': %v", opts.NameExcludePatterns, err),
+		}
+	}
+
+	if opts.SkipSingleAuthorGenerated {
+		provider.generatedAuthorPattern, err = regexp.Compile(opts.GeneratedAuthorPattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile generated author pattern '%v': %v", opts.GeneratedAuthorPattern, err)
+		}
+	}
+
+	if opts.StatsDir != "" {
+		// the files are read from the directory, there is no clone to open
+		return provider, nil
+	}
+
+	provider.repository, err = openClone(opts)
+	if err != nil {
+		return nil, &util.ErrorWithCode{
 			StatusCode:    util.ERROR_BAD_CLONE_GIT,
 			InternalError: err,
 		}
 	}
 
+	err = verifyObjectFormat(provider.repository)
+	if err != nil {
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_CLONE_GIT,
+			InternalError: fmt.Errorf("clone at '%v' is not supported: %v", opts.ClonePath, err),
+		}
+	}
+
+	return provider, nil
+}
+
+// verifyObjectFormat fails for clones using the SHA-256 object format, which go-git can't read -
+// its hashes are SHA-1 only, so revisions of such clones would not be found
+func verifyObjectFormat(repository *git.Repository) error {
+	config, err := repository.Config()
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+	objectFormat := config.Raw.Section(EXTENSIONS_SECTION).Option(OBJECT_FORMAT_OPTION)
+	if objectFormat != "" && !strings.EqualFold(objectFormat, OBJECT_FORMAT_SHA1) {
+		return fmt.Errorf("the %v object format is not supported, only %v", objectFormat, OBJECT_FORMAT_SHA1)
+	}
+	return nil
+}
+
+// SnapshotResult summarizes the files written by a snapshot
+type SnapshotResult struct {
+	FilesWritten int
+	BytesWritten int64
+	// SkippedCount is the number of files of the tree which were not written, being filtered out or skipped
+	SkippedCount int
+	// DedupedFiles is the number of written files whose contents were not written again, with --dedup or
+	// --output-layout sharded, and DedupedBytes is their size
+	DedupedFiles int
+	DedupedBytes int64
+	DurationMs   int64
+}
+
+func Snapshot(opts *options.Options) error {
+	return SnapshotContext(context.Background(), opts)
+}
+
+// SnapshotContext snapshots like Snapshot until the context is done, which fails with ERROR_CANCELED. files being
+// written when it is done are completed, the rest are not. stats, manifests and the other modes are canceled alike.
+func SnapshotContext(ctx context.Context, opts *options.Options) error {
+	_, err := SnapshotWithResultContext(ctx, opts)
+	return err
+}
+
+// SnapshotWithResult snapshots like Snapshot and returns what was written. modes writing no files
+// (index only, manifest, compare and dry run) report only the duration.
+func SnapshotWithResult(opts *options.Options) (*SnapshotResult, error) {
+	return SnapshotWithResultContext(context.Background(), opts)
+}
+
+// SnapshotWithResultContext is SnapshotWithResult, canceled like SnapshotContext
+func SnapshotWithResultContext(ctx context.Context, opts *options.Options) (*SnapshotResult, error) {
+	start := time.Now()
+
+	provider, err := newRepositoryProvider(opts)
+	if err != nil {
+		return nil, err
+	}
+	provider.ctx = ctx
+	writesOutputDirectory := provider.writesOutputDirectory()
+	outputWasEmpty := writesOutputDirectory && isEmptyDirectory(opts.OutputPath)
+
+	err = provider.run()
+	if err != nil && ctx.Err() != nil {
+		if writesOutputDirectory {
+			provider.cleanUpPartialOutput(outputWasEmpty, ctx.Err())
+		}
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_CANCELED,
+			InternalError: fmt.Errorf("canceled snapshot of revision '%v' at clone '%v': %v", opts.Revision, opts.ClonePath, ctx.Err()),
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if writesOutputDirectory {
+		provider.removeIncompleteMarker()
+	}
+
+	provider.result.DurationMs = time.Since(start).Milliseconds()
+	return &provider.result, nil
+}
+
+func (provider *repositoryProvider) run() (err error) {
+	opts := provider.opts
+
+	if opts.StatsDir != "" {
+		provider.logger.Infof("collecting stats of directory '%v' to '%v'", opts.StatsDir, opts.StatsPath)
+		return provider.writeStats(nil, opts.StatsPath, opts.StatsDetailsPath)
+	}
+
 	_, _ = provider.getCommit("HEAD")
 
 	var commit *object.Commit
@@ -72,23 +259,110 @@ func Snapshot(opts *options.Options) (err error) {
 	if err != nil || commit == nil {
 		return err
 	}
+	provider.commit = commit
+
+	if opts.VerifySignature {
+		err = verifyCommitSignature(commit, opts.KeyringPath)
+		if err != nil {
+			return err
+		}
+		provider.logger.Infof("verified signature of commit '%v'", commit.ID())
+	}
+
+	if opts.ApplyGitignore {
+		provider.gitignore, err = provider.loadGitignore(commit)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.TextFilesOnly || opts.StripBOM || opts.Encoding != "" {
+		provider.textAttributes, err = provider.loadTextAttributes(commit)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.BaseRevision != "" {
+		var baseCommit *object.Commit
+		baseCommit, err = provider.getCommit(opts.BaseRevision)
+		if err != nil || baseCommit == nil {
+			return err
+		}
+		err = provider.loadChanges(baseCommit, commit)
+		if err != nil {
+			return err
+		}
+		if opts.DeletionsFilePath != "" && !opts.DryRun {
+			err = provider.writeDeletionsFile(opts.DeletionsFilePath)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-	log.Printf("snapshotting commit '%v' for revision '%v' at clone '%v'", commit.ID(), opts.Revision, opts.ClonePath)
+	if !opts.Since.IsZero() {
+		err = provider.loadRecentChanges(commit)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.ManifestPath != "" {
+		provider.logger.Infof("cataloging commit '%v' for revision '%v' at clone '%v' to '%v'", commit.ID(), opts.Revision, opts.ClonePath, opts.ManifestPath)
+		return provider.writeManifest(commit, opts.ManifestPath)
+	}
+
+	if opts.StatsPath != "" {
+		provider.logger.Infof("collecting stats of commit '%v' for revision '%v' at clone '%v' to '%v'", commit.ID(), opts.Revision, opts.ClonePath, opts.StatsPath)
+		return provider.writeStats(commit, opts.StatsPath, opts.StatsDetailsPath)
+	}
+
+	if opts.CompareToDir != "" {
+		provider.logger.Infof("comparing commit '%v' for revision '%v' at clone '%v' to '%v'", commit.ID(), opts.Revision, opts.ClonePath, opts.CompareToDir)
+		return provider.compareToDir(commit, opts.CompareToDir)
+	}
+
+	if opts.Estimate {
+		provider.logger.Infof("estimating files of commit '%v' for revision '%v' at clone '%v'", commit.ID(), opts.Revision, opts.ClonePath)
+		return provider.estimate(commit)
+	}
+
+	if opts.DryRun {
+		provider.logger.Infof("listing files of commit '%v' for revision '%v' at clone '%v'", commit.ID(), opts.Revision, opts.ClonePath)
+		return provider.dryRun(commit)
+	}
+
+	provider.logger.Infof("snapshotting commit '%v' for revision '%v' at clone '%v'", commit.ID(), opts.Revision, opts.ClonePath)
 
 	var filesCount int
 	var filesCountDryRun int
-	if opts.SkipDoubleCheck {
-		filesCount, err = provider.snapshot(provider.repository, commit, opts.OutputPath, opts.OptionalIndexFilePath, opts.IndexOnly, false)
+	if opts.SkipDoubleCheck || opts.DoubleCheck == options.DOUBLE_CHECK_HASH {
+		if opts.Progress != "" {
+			// a cheap pass counting the tree entries, for the progress total
+			filesCountDryRun, err = provider.snapshot(provider.repository, commit, opts.OutputPath, opts.OptionalIndexFilePath, opts.IndexOnly, true)
+			if err != nil {
+				return err
+			}
+		}
+		filesCount, err = provider.snapshotWithProgress(commit, filesCountDryRun)
 		if err != nil {
 			return err
 		}
+		if !opts.SkipDoubleCheck {
+			err = provider.verifyWrittenDigest(commit)
+			if err != nil {
+				return err
+			}
+		}
 	} else {
+		// only the middle pass writes, so this holds when streaming to stdout as well
 		filesCountDryRun, err = provider.snapshot(provider.repository, commit, opts.OutputPath, opts.OptionalIndexFilePath, opts.IndexOnly, true)
 		if err != nil {
 			return err
 		}
 
-		filesCount, err = provider.snapshot(provider.repository, commit, opts.OutputPath, opts.OptionalIndexFilePath, opts.IndexOnly, false)
+		filesCount, err = provider.snapshotWithProgress(commit, filesCountDryRun)
 		if err != nil {
 			return err
 		}
@@ -111,76 +385,282 @@ func Snapshot(opts *options.Options) (err error) {
 		}
 	}
 
-	log.Printf("written %v files to target path '%v'", filesCount, opts.OutputPath)
+	provider.logger.Infof("written %v files to target path '%v'", filesCount, opts.OutputPath)
+	if provider.result.DedupedFiles > 0 {
+		provider.logger.Infof("deduplicated %v files, saving %v bytes", provider.result.DedupedFiles, provider.result.DedupedBytes)
+	}
+
+	if opts.FailOnEmpty && !opts.IndexOnly && provider.result.FilesWritten == 0 {
+		return &util.ErrorWithCode{
+			StatusCode:    util.ERROR_NO_FILES_WRITTEN,
+			InternalError: fmt.Errorf("no files of commit %v were written to '%v' - check the include and exclude patterns", commit.Hash, opts.OutputPath),
+		}
+	}
 	return nil
 }
 
-func loadFilePathsList(opts *options.Options, provider *repositoryProvider) error {
-	if opts.PathsFileLocation != "" {
-		file, err := os.Open(opts.PathsFileLocation)
-		if err != nil {
-			return fmt.Errorf("failed to read paths file from location: '%v', error: '%v'", opts.PathsFileLocation, err)
+// snapshotWithProgress runs the writing pass, reporting its progress with --progress
+func (provider *repositoryProvider) snapshotWithProgress(commit *object.Commit, total int) (int, error) {
+	opts := provider.opts
+	if opts.Progress == options.PROGRESS_JSON {
+		interval := opts.ProgressInterval
+		if interval <= 0 {
+			interval = options.DEFAULT_PROGRESS_INTERVAL
 		}
+		provider.progress = startProgress(os.Stderr, interval, total)
+		defer func() {
+			provider.progress = nil
+		}()
+	}
+	count, err := provider.snapshot(provider.repository, commit, opts.OutputPath, opts.OptionalIndexFilePath, opts.IndexOnly, false)
+	provider.progress.finish(err == nil)
+	return count, err
+}
 
-		reader := csv.NewReader(file)
-		defer file.Close()
+func loadFilePathsList(opts *options.Options, provider *repositoryProvider) error {
+	if opts.PathsFileLocation == "" {
+		return nil
+	}
 
-		lines, err := reader.ReadAll()
-		if err != nil {
-			return fmt.Errorf("failed to read paths file from location: '%v', error: '%v'", opts.PathsFileLocation, err)
-		}
-		for i := range lines {
-			path := lines[i][0]
-			if !utf8.ValidString(path) {
-				provider.verboseLog("skipping invalid UTF-8 path found in the file paths file: %s", lines[i][0])
-				continue
-			}
-			provider.fileListToSnap[path] = true
+	if opts.PathsFileLocation == options.PATHS_FILE_STDIN {
+		return readFilePathsList(os.Stdin, "stdin", provider)
+	}
+
+	file, err := os.Open(opts.PathsFileLocation)
+	if err != nil {
+		return fmt.Errorf("failed to read paths file from location: '%v', error: '%v'", opts.PathsFileLocation, err)
+	}
+	defer file.Close()
+
+	return readFilePathsList(file, opts.PathsFileLocation, provider)
+}
+
+func readFilePathsList(input io.Reader, location string, provider *repositoryProvider) error {
+	reader := csv.NewReader(input)
+
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to read paths file from location: '%v', error: '%v'", location, err)
+	}
+	for i := range lines {
+		path := lines[i][0]
+		if !utf8.ValidString(path) {
+			provider.verboseLog("skipping invalid UTF-8 path found in the file paths file: %s", lines[i][0])
+			continue
 		}
+		provider.fileListToSnap[path] = true
 	}
 	return nil
 }
 
 func (provider *repositoryProvider) getCommit(commitish string) (*object.Commit, error) {
 
+	if !provider.opts.SupportShortSha && provider.isShortSha(commitish) {
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_NO_SHORT_SHA,
+			InternalError: fmt.Errorf("revision '%v' is an abbreviated commit hash, use the full hash or set --short-sha", commitish),
+		}
+	}
+
 	hash, err := provider.repository.ResolveRevision(plumbing.Revision(commitish))
+	if err != nil {
+		// annotated tags pointing at other tags are not resolved by go-git
+		tagRef, tagErr := provider.repository.Reference(plumbing.NewTagReferenceName(commitish), true)
+		if tagErr != nil {
+			return nil, &util.ErrorWithCode{
+				StatusCode:    util.ERROR_NO_REVISION,
+				InternalError: fmt.Errorf("failed to get revision '%v': %v", commitish, err),
+			}
+		}
+		tagHash := tagRef.Hash()
+		hash = &tagHash
+	}
+
+	commitHash, err := provider.peelTags(*hash)
 	if err != nil {
 		return nil, &util.ErrorWithCode{
 			StatusCode:    util.ERROR_NO_REVISION,
 			InternalError: fmt.Errorf("failed to get revision '%v': %v", commitish, err),
 		}
 	}
+	return provider.repository.CommitObject(commitHash)
+}
+
+// isShortSha returns whether the revision can only be an abbreviated commit hash, and not a reference name
+func (provider *repositoryProvider) isShortSha(commitish string) bool {
+	if !shortShaPattern.MatchString(commitish) {
+		return false
+	}
+	for _, rule := range plumbing.RefRevParseRules {
+		_, err := provider.repository.Reference(plumbing.ReferenceName(fmt.Sprintf(rule, commitish)), false)
+		if err == nil {
+			return false
+		}
+	}
+	return true
+}
 
-	return provider.repository.CommitObject(*hash)
+// peelTags dereferences annotated tag objects, possibly nested, to the object they point at
+func (provider *repositoryProvider) peelTags(hash plumbing.Hash) (plumbing.Hash, error) {
+	for {
+		tag, err := provider.repository.TagObject(hash)
+		if err == plumbing.ErrObjectNotFound {
+			return hash, nil
+		}
+		if err != nil {
+			return hash, err
+		}
+		if tag.TargetType != plumbing.TagObject && tag.TargetType != plumbing.CommitObject {
+			return hash, fmt.Errorf("tag '%v' points at a %v, not a commit", tag.Name, tag.TargetType)
+		}
+		hash = tag.Target
+	}
 }
 
-func expandPatternsIfNeeded(patterns []string) []string {
-	for _, pattern := range patterns {
-		if strings.HasPrefix(pattern, "*/") {
-			patterns = append(patterns, strings.Replace(pattern, "*/", "", 1))
+const (
+	NEGATED_PATTERN_PREFIX = "!"
+)
+
+// pathPattern is a compiled include or exclude pattern, either a glob or a regex with --regex
+type pathPattern interface {
+	Match(filePath string) bool
+}
+
+type regexPattern struct {
+	*regexp.Regexp
+}
+
+func (pattern regexPattern) Match(filePath string) bool {
+	return pattern.MatchString(filePath)
+}
+
+// excludePattern is a compiled exclude pattern, a negated one re-includes the paths it matches
+type excludePattern struct {
+	pattern pathPattern
+	negated bool
+	// matches all the files of a directory or none of them
+	directoryLevel bool
+}
+
+func (provider *repositoryProvider) compilePattern(pattern string) (pathPattern, error) {
+	if provider.opts.Regex {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
 		}
-		if strings.HasPrefix(pattern, "**/") {
-			patterns = append(patterns, strings.Replace(pattern, "**/", "", 1))
+		return regexPattern{compiled}, nil
+	}
+	if fastPattern := compileFastGlob(pattern); fastPattern != nil {
+		return fastPattern, nil
+	}
+	return glob.Compile(pattern)
+}
+
+// expandPatternIfNeeded returns the pattern followed by its variants where a leading */ or **/, or a /**/ inside it,
+// match no directories, like in .gitignore. the variants are expanded as well, so every combination is included.
+func expandPatternIfNeeded(pattern string) []string {
+	patterns := []string{pattern}
+	seen := map[string]bool{pattern: true}
+	for i := 0; i < len(patterns); i++ {
+		for _, variant := range patternVariants(patterns[i]) {
+			if !seen[variant] {
+				seen[variant] = true
+				patterns = append(patterns, variant)
+			}
 		}
 	}
 	return patterns
 }
 
-func (provider *repositoryProvider) compileGlobs(patterns []string, title string) ([]glob.Glob, error) {
-	patterns = expandPatternsIfNeeded(patterns)
+// patternVariants returns the patterns with one leading */ or **/, or one /**/, matching no directories
+func patternVariants(pattern string) []string {
+	var variants []string
+	for _, prefix := range []string{"**/", "*/"} {
+		if strings.HasPrefix(pattern, prefix) {
+			variants = append(variants, strings.TrimPrefix(pattern, prefix))
+			break
+		}
+	}
+	for offset := 0; ; {
+		index := strings.Index(pattern[offset:], "/**/")
+		if index < 0 {
+			return variants
+		}
+		index += offset
+		variants = append(variants, pattern[:index]+pattern[index+len("/**"):])
+		offset = index + 1
+	}
+}
+
+// expandPatternsIfNeeded keeps the expansions of each pattern next to it, since the order of exclude patterns matters
+func (provider *repositoryProvider) expandPatternsIfNeeded(patterns []string) []string {
+	if provider.opts.Regex {
+		// regexes already match anywhere in the path
+		return patterns
+	}
+	expanded := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		expanded = append(expanded, expandPatternIfNeeded(pattern)...)
+	}
+	return expanded
+}
+
+func (provider *repositoryProvider) compileGlobs(patterns []string, title string) ([]pathPattern, error) {
+	patterns = provider.expandPatternsIfNeeded(patterns)
 	provider.verboseLog("%v %v patterns:\n%v", len(patterns), title, strings.Join(patterns, ", "))
-	globs := make([]glob.Glob, len(patterns))
+	compiled := make([]pathPattern, len(patterns))
 	for i, pattern := range patterns {
-		compiled, err := glob.Compile(pattern)
+		compiledPattern, err := provider.compilePattern(pattern)
 		if err != nil {
 			return nil, err
 		}
-		globs[i] = compiled
+		compiled[i] = compiledPattern
 	}
-	return globs, nil
+	return compiled, nil
 }
 
-func matches(filePath string, patterns []glob.Glob) bool {
+func (provider *repositoryProvider) compileExcludePatterns(patterns []string) (*excludeMatcher, error) {
+	var compiled []excludePattern
+	var expanded []string
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, NEGATED_PATTERN_PREFIX)
+		for _, expandedPattern := range provider.expandPatternsIfNeeded([]string{strings.TrimPrefix(pattern, NEGATED_PATTERN_PREFIX)}) {
+			compiledPattern, err := provider.compilePattern(expandedPattern)
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, excludePattern{
+				pattern:        compiledPattern,
+				negated:        negated,
+				directoryLevel: !provider.opts.Regex && isDirectoryLevel(expandedPattern),
+			})
+			if negated {
+				expandedPattern = NEGATED_PATTERN_PREFIX + expandedPattern
+			}
+			expanded = append(expanded, expandedPattern)
+		}
+	}
+	provider.verboseLog("%v exclude patterns:\n%v", len(expanded), strings.Join(expanded, ", "))
+	return newExcludeMatcher(compiled), nil
+}
+
+// compileNamePatterns compiles patterns matched against file names, which need no expansion
+func (provider *repositoryProvider) compileNamePatterns(patterns []string, title string) ([]pathPattern, error) {
+	if len(patterns) > 0 {
+		provider.verboseLog("%v %v patterns:\n%v", len(patterns), title, strings.Join(patterns, ", "))
+	}
+	compiled := make([]pathPattern, len(patterns))
+	for i, pattern := range patterns {
+		compiledPattern, err := provider.compilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled[i] = compiledPattern
+	}
+	return compiled, nil
+}
+
+func matches(filePath string, patterns []pathPattern) bool {
 	for _, pattern := range patterns {
 		if pattern.Match(filePath) {
 			return true
@@ -191,22 +671,45 @@ func matches(filePath string, patterns []glob.Glob) bool {
 
 func (provider *repositoryProvider) verboseLog(format string, v ...interface{}) {
 	if provider.opts.VerboseLogging {
-		log.Printf(format, v...)
+		provider.logger.Debugf(format, v...)
 	}
 }
 
-func (provider *repositoryProvider) dumpFile(repository *git.Repository, name string, entry *object.TreeEntry, outputPath string, indexOnly bool) (error, bool) {
-	filePath := name
-	mode := entry.Mode
+// shouldInclude is the filtering predicate deciding whether a tree entry is part of the snapshot,
+// based on its mode and path alone (no blob access)
+func (provider *repositoryProvider) shouldInclude(filePath string, mode filemode.FileMode) bool {
+	if !provider.matchesFilters(filePath, mode) {
+		return false
+	}
+
+	if provider.changedPaths != nil && !provider.changedPaths[filePath] {
+		provider.verboseLog("--- skipping '%v' - not changed since the base revision", filePath)
+		return false
+	}
+
+	if provider.recentChanges != nil && !provider.changedSince(filePath) {
+		provider.verboseLog("--- skipping '%v' - not changed since %v", filePath, provider.opts.Since)
+		return false
+	}
 
-	if !mode.IsFile() || mode.IsMalformed() || provider.isSymlink(filePath, mode) {
+	return true
+}
+
+// matchesFilters applies the mode and path filters of shouldInclude, regardless of the base revision
+func (provider *repositoryProvider) matchesFilters(filePath string, mode filemode.FileMode) bool {
+	if !mode.IsFile() || mode.IsMalformed() || (provider.isSymlink(filePath, mode) && !provider.keepsSymlinks()) {
 		provider.verboseLog("--- skipping '%v' - not regular file - mode: %v", filePath, mode)
-		return nil, false
+		return false
 	}
 
 	if !utf8.ValidString(filePath) {
 		provider.verboseLog("--- skipping '%v' - file path is not a valid UTF-8 string", filePath)
-		return nil, false
+		return false
+	}
+
+	if provider.opts.ExcludeDotfiles && isHiddenPath(filePath) {
+		provider.verboseLog("--- skipping '%v' - hidden path", filePath)
+		return false
 	}
 
 	filePathToCheck := filePath
@@ -216,181 +719,696 @@ func (provider *repositoryProvider) dumpFile(repository *git.Repository, name st
 
 	if !isFileInList(provider, filePathToCheck) {
 		provider.verboseLog("--- skipping '%v' - not matching file list", filePath)
-		return nil, false
+		return false
 	}
 
-	skip := true
-	hasIncludePatterns := len(provider.includePatterns) > 0
-	if hasIncludePatterns && !matches(filePathToCheck, provider.includePatterns) {
+	// the include patterns and the exclude patterns after them are one ordered list, where the last matching pattern
+	// wins. with include patterns, a path is excluded unless one of them matches it.
+	excluded, matched := provider.excludePatterns.lastMatch(filePathToCheck)
+	if excluded {
+		provider.verboseLog("--- skipping '%v' - matching exclude patterns", filePath)
+		return false
+	}
+	if !matched && len(provider.includePatterns) > 0 && !matches(filePathToCheck, provider.includePatterns) {
 		provider.verboseLog("--- skipping '%v' - not matching include patterns", filePath)
-		return nil, false
-	} else if hasIncludePatterns {
-		skip = false
+		return false
 	}
 
-	if len(provider.excludePatterns) > 0 && matches(filePathToCheck, provider.excludePatterns) && skip {
-		provider.verboseLog("--- skipping '%v' - matching exclude patterns", filePath)
-		return nil, false
+	fileName := path.Base(filePathToCheck)
+	if len(provider.nameIncludePatterns) > 0 && !matches(fileName, provider.nameIncludePatterns) {
+		provider.verboseLog("--- skipping '%v' - not matching name include patterns", filePath)
+		return false
+	}
+	if matches(fileName, provider.nameExcludePatterns) {
+		provider.verboseLog("--- skipping '%v' - matching name exclude patterns", filePath)
+		return false
 	}
 
-	if provider.opts.TextFilesOnly && util.NotTextExt(filepath.Ext(filePathToCheck)) {
-		provider.verboseLog("--- skipping '%v' - not a text file", filePath)
-		return nil, false
+	if provider.gitignore != nil && provider.gitignore.Match(strings.Split(provider.repositoryPath(filePath), "/"), false) {
+		provider.verboseLog("--- skipping '%v' - matching %v", filePath, GITIGNORE_FILE_NAME)
+		return false
 	}
 
-	blob, err := object.GetBlob(repository.Storer, entry.Hash)
+	if provider.opts.TextFilesOnly {
+		text, explicit := provider.textAttributes.isText(provider.repositoryPath(filePath))
+		if explicit && !text {
+			provider.verboseLog("--- skipping '%v' - binary by %v", filePath, GITATTRIBUTES_FILE_NAME)
+			return false
+		}
+		if !explicit && provider.opts.TextDetect != options.TEXT_DETECT_CONTENT && util.NotTextExt(filepath.Ext(filePathToCheck)) {
+			provider.verboseLog("--- skipping '%v' - not a text file", filePath)
+			return false
+		}
+	}
+
+	return true
+}
+
+// isHiddenPath checks whether any component of the path starts with a dot
+func isHiddenPath(filePath string) bool {
+	return strings.HasPrefix(filePath, ".") || strings.Contains(filePath, "/.")
+}
+
+// exceedsLimits checks the size and path length restrictions which apply once the blob is resolved
+func (provider *repositoryProvider) exceedsLimits(filePath string, size int64) bool {
+	if provider.opts.MaxFileSizeBytes > 0 && size >= provider.opts.MaxFileSizeBytes {
+		provider.logger.Infof("--- skipping '%v' - file size is too large to snapshot - %v", filePath, size)
+		return true
+	}
+
+	if provider.skipsLongPath(filePath) {
+		provider.logger.Infof("--- skipping '%v' - file name is too long to snapshot", filePath)
+		return true
+	}
+
+	return false
+}
+
+type indexRecord struct {
+	path        string
+	entry       *object.TreeEntry
+	linesOfCode int
+	// language and line counts of the written contents, with --index-loc or --with-stats. no language means they were
+	// not counted.
+	language string
+	lines    lineCounts
+	size     int64
+	// chosen by selectFile, even if it was not written in the end
+	selected bool
+	snapped  bool
+	// hash of the written contents by --hash-algo, empty when they were not read
+	digest string
+	// path of the written file relative to the output path, empty when it was not written
+	targetPath string
+	// the contents were already written for another path, by --output-layout sharded
+	shared bool
+	// hard linked to the file of another path, by --dedup
+	linked bool
+	// blob id of the contents written by the run, zero when they differ from the blob, such as resolved LFS objects
+	writtenHash plumbing.Hash
+}
+
+func (provider *repositoryProvider) dumpRecord(repository *git.Repository, record *indexRecord, outputPath string, indexOnly bool) error {
+	err, didSnap := provider.dumpFile(repository, record, outputPath, indexOnly)
 	if err != nil {
-		return err, false
+		if !errors.Is(err, plumbing.ErrObjectNotFound) {
+			return err
+		}
+		provider.logger.Infof("--- skipping '%v' - blob %v is missing from the object store (partial clone?): %v", record.path, record.entry.Hash, err)
+		return nil
+	}
+	record.snapped = didSnap
+	return nil
+}
+
+// selectFile applies all the rules deciding whether a tree entry is written, including those needing its blob.
+// a nil file means the entry is skipped.
+func (provider *repositoryProvider) selectFile(filePath string, entry *object.TreeEntry) (*object.File, error) {
+	if !provider.shouldInclude(filePath, entry.Mode) {
+		return nil, nil
+	}
+
+	if provider.isSymlink(filePath, entry.Mode) {
+		var err error
+		entry, err = provider.selectSymlink(filePath, entry)
+		if err != nil || entry == nil {
+			return nil, err
+		}
+	}
+
+	blob, err := provider.getBlob(entry.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	file := object.NewFile(filePath, entry.Mode, blob)
+
+	if provider.exceedsLimits(filePath, file.Size) {
+		return nil, nil
 	}
 
-	file := object.NewFile(name, entry.Mode, blob)
+	if provider.needsContentTextCheck(filePath) {
+		binary, err := provider.isBinaryContent(file)
+		if err != nil {
+			return nil, err
+		}
+		if binary {
+			provider.verboseLog("--- skipping '%v' - binary content", filePath)
+			return nil, nil
+		}
+	}
 
-	if provider.opts.MaxFileSizeBytes > 0 && file.Size >= provider.opts.MaxFileSizeBytes {
-		log.Printf("--- skipping '%v' - file size is too large to snapshot - %v", filePath, file.Size)
-		return nil, false
+	if provider.opts.SkipSingleAuthorGenerated {
+		generated, err := provider.isSingleCommitByBot(provider.repositoryPath(filePath), entry.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up history of '%v': %v", filePath, err)
+		}
+		if generated {
+			provider.verboseLog("--- skipping '%v' - single commit by a generated author", filePath)
+			return nil, nil
+		}
+	}
+
+	return file, nil
+}
+
+// addToTotalSize accounts for a file about to be written, failing once the total crosses --max-total-size
+func (provider *repositoryProvider) addToTotalSize(filePath string, size int64) error {
+	if provider.opts.MaxTotalSizeBytes <= 0 {
+		return nil
+	}
+	totalSize := provider.totalSize.Add(size)
+	if totalSize <= provider.opts.MaxTotalSizeBytes {
+		return nil
+	}
+	provider.logger.Infof("'%v' crossed the maximal total size of %v bytes", filePath, provider.opts.MaxTotalSizeBytes)
+	return &util.ErrorWithCode{
+		StatusCode:    util.ERROR_MAX_TOTAL_SIZE_EXCEEDED,
+		InternalError: fmt.Errorf("snapshot exceeds the maximal total size of %v bytes, crossed by '%v'", provider.opts.MaxTotalSizeBytes, filePath),
+	}
+}
+
+func (provider *repositoryProvider) dumpFile(repository *git.Repository, record *indexRecord, outputPath string, indexOnly bool) (error, bool) {
+	filePath := record.path
+	entry := record.entry
+
+	file, err := provider.selectFile(filePath, entry)
+	if err != nil || file == nil {
+		return err, false
+	}
+	record.selected = true
+	record.size = file.Size
+
+	if !indexOnly {
+		err = provider.addToTotalSize(filePath, file.Size)
+		if err != nil {
+			return err, false
+		}
 	}
 
-	fileName := filepath.Base(filePath)
 	targetFilePath := filepath.Join(outputPath, filePath)
-	targetDirectoryPath := filepath.Dir(targetFilePath)
+	// tree paths come from the repository, a crafted one may hold .. components
+	if !indexOnly && !isWithinPath(outputPath, targetFilePath) {
+		return &util.ErrorWithCode{
+			StatusCode:    util.ERROR_PATH_TRAVERSAL,
+			InternalError: fmt.Errorf("refusing to write '%v' - its target path '%v' is outside the output path '%v'", filePath, targetFilePath, outputPath),
+		}, false
+	}
+
+	language, countLines := "", false
+	if provider.opts.IndexLinesOfCode || provider.opts.WithStatsPath != "" {
+		language, countLines = stats.GetLanguageFromExtension(filepath.Ext(filePath))
+	}
+
+	needsDigest := provider.opts.HashAlgorithm == options.HASH_ALGO_SHA256 && provider.opts.ChecksumPath != ""
+	if indexOnly && !countLines && !needsDigest {
+		return nil, true
+	}
+
+	if provider.inflight != nil {
+		weight := provider.inflight.Acquire(file.Size)
+		defer provider.inflight.Release(weight)
+	}
 
-	if len(fileName) > 255 || len(filePath) > 4095 {
-		log.Printf("--- skipping '%v' - file name is too long to snapshot", filePath)
-		return nil, false
+	// a streamed file is copied from the blob as it is written, so its contents are not read here
+	streams := provider.streamsContents(filePath, file, indexOnly, countLines)
+	var contentsBytes []byte
+	if !streams {
+		contentsBytes, err = provider.readContents(file)
+		if err != nil {
+			return err, false
+		}
+	}
+
+	// the written contents differ from the blob
+	transformed := false
+	if provider.opts.ResolveLFS {
+		var resolved []byte
+		resolved, err = provider.resolveLFS(filePath, contentsBytes)
+		if err != nil || resolved == nil {
+			return err, false
+		}
+		transformed = !bytes.Equal(resolved, contentsBytes)
+		if len(resolved) != len(contentsBytes) {
+			resolvedSize := int64(len(resolved))
+			if provider.exceedsLimits(filePath, resolvedSize) {
+				return nil, false
+			}
+			if !indexOnly {
+				err = provider.addToTotalSize(filePath, resolvedSize-file.Size)
+				if err != nil {
+					return err, false
+				}
+			}
+			record.size = resolvedSize
+		}
+		contentsBytes = resolved
+	}
+
+	if provider.transcodesContents(filePath) {
+		transcoded, encodingName, transcodeErr := transcodeToUTF8(contentsBytes)
+		if transcodeErr != nil {
+			return fmt.Errorf("failed to transcode '%v' to UTF-8: %v", filePath, transcodeErr), false
+		}
+		if encodingName != "" {
+			provider.verboseLog("*** transcoded '%v' from %v to UTF-8", filePath, encodingName)
+			contentsBytes = transcoded
+			record.size = int64(len(transcoded))
+			transformed = true
+		}
+	}
+
+	if provider.stripsByteOrderMark(filePath) {
+		stripped := stripByteOrderMark(contentsBytes)
+		if len(stripped) != len(contentsBytes) {
+			provider.verboseLog("*** stripped the byte order mark of '%v'", filePath)
+			contentsBytes = stripped
+			record.size = int64(len(stripped))
+			transformed = true
+		}
+	}
+
+	record.digest = provider.contentDigest(file.Hash, contentsBytes)
+
+	if countLines {
+		record.language = language
+		record.lines = countLinesOfCode(contentsBytes, language)
+		record.linesOfCode = record.lines.code
 	}
 
 	if indexOnly {
 		return nil, true
 	}
 
-	err = os.MkdirAll(targetDirectoryPath, TARGET_PERMISSIONS)
-	if err != nil {
-		return fmt.Errorf("failed to create target directory at '%v': %v", targetDirectoryPath, err), false
+	rootPath := outputPath
+	if provider.archive != nil {
+		rootPath = ""
+		targetFilePath = filePath
 	}
 
-	var contents string
-	err = retry.Do(
-		func() error {
-			var contentsErr error
-			contents, contentsErr = file.Contents()
-			return contentsErr
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to get git file contents for '%v': %v", filePath, err), false
+	if provider.opts.OutputLayout == options.OUTPUT_LAYOUT_SHARDED {
+		targetFilePath, record.shared = provider.claimShardedTargetPath(rootPath, filePath, file.Hash)
+	} else if provider.opts.Flatten {
+		targetFilePath, err = provider.claimFlatTargetPath(rootPath, filePath, file.Hash)
+	} else {
+		targetFilePath, err = provider.shortenTargetPath(filePath, targetFilePath, file.Hash)
+		if err != nil {
+			return err, false
+		}
+		targetFilePath, err = provider.claimTargetPath(filePath, targetFilePath)
+	}
+	if err != nil || targetFilePath == "" {
+		return err, false
+	}
+	record.targetPath, _ = filepath.Rel(filepath.Join(rootPath, "."), targetFilePath)
+	record.targetPath = filepath.ToSlash(record.targetPath)
+	if record.shared {
+		return nil, true
 	}
 
-	contentsBytes := []byte(contents)
+	if provider.archive != nil {
+		err = provider.archiveFile(filePath, targetFilePath, file.Mode, record.digest, contentsBytes)
+		if err == nil && provider.opts.HashMarkersDir != "" {
+			provider.writeHashMarkerToDir(filePath, targetFilePath, record.digest)
+		}
+		return err, true
+	}
+
+	if provider.keepsExistingFile(record, targetFilePath, provider.isSymlink(filePath, file.Mode)) {
+		provider.verboseLog("*** keeping '%v' - '%v' already exists", filePath, targetFilePath)
+		return nil, true
+	}
 
-	err = os.WriteFile(targetFilePath, contentsBytes, TARGET_PERMISSIONS)
+	perm := provider.targetFileMode(file.Mode)
+	write := func() error {
+		return provider.writeTargetFile(outputPath, targetFilePath, contentsBytes, perm)
+	}
+	if streams {
+		write = func() error {
+			return provider.streamTargetFile(outputPath, targetFilePath, file, perm)
+		}
+	}
+
+	if provider.isSymlink(filePath, file.Mode) {
+		err = provider.writeTargetSymlink(outputPath, targetFilePath, string(contentsBytes))
+	} else if provider.opts.Dedup {
+		record.linked, err = provider.writeDeduplicated(outputPath, targetFilePath, file.Hash, perm, write)
+	} else {
+		err = write()
+	}
 	if err != nil {
+		var errorWithCode *util.ErrorWithCode
+		if errors.As(err, &errorWithCode) {
+			return err, false
+		}
 		if strings.Contains(err.Error(), "file name too long") {
-			return &util.ErrorWithCode{
-				StatusCode:    util.ERROR_PATH_TOO_LONG,
-				InternalError: err,
-			}, false
+			// the limits of the file system may be lower than the checked ones, or the output path makes it too long
+			if provider.skipsLongPaths() {
+				provider.logger.Infof("--- skipping '%v' - file name is too long to write: %v", filePath, err)
+				return nil, false
+			}
+			return longPathError(filePath, err), false
 		}
 		return fmt.Errorf("failed to write target file of '%v' to '%v': %v", filePath, targetFilePath, err), false
 	}
 
 	provider.verboseLog("+++ '%v' to '%v'", filePath, targetFilePath)
+	if !transformed {
+		record.writtenHash = file.Hash
+	}
 
 	if provider.opts.CreateHashMarkers {
 		targetHashFilePath := fmt.Sprintf("%v.hash", targetFilePath)
-		err = os.WriteFile(targetHashFilePath, []byte(file.Hash.String()), TARGET_PERMISSIONS)
+		err = os.WriteFile(targetHashFilePath, []byte(record.digest), provider.targetFileMode(filemode.Regular))
+		if err != nil {
+			provider.logger.Infof("failed to write hash file of '%v' to '%v': %v", filePath, targetFilePath, err)
+		}
+	}
+
+	if provider.opts.HashMarkersDir != "" {
+		relativeTargetPath, err := filepath.Rel(outputPath, targetFilePath)
 		if err != nil {
-			log.Printf("failed to write hash file of '%v' to '%v': %v", filePath, targetFilePath, err)
+			return fmt.Errorf("failed to get relative path of '%v': %v", targetFilePath, err), true
 		}
+		provider.writeHashMarkerToDir(filePath, relativeTargetPath, record.digest)
 	}
 
 	return nil, true
 }
 
+// readContents reads the contents of a file from the object store. the store is not safe for concurrent use, so it
+// is locked for every attempt, and released while waiting to retry.
+func (provider *repositoryProvider) readContents(file *object.File) ([]byte, error) {
+	var contents string
+	err := retry.Do(
+		func() error {
+			provider.storeMutex.Lock()
+			defer provider.storeMutex.Unlock()
+			var contentsErr error
+			contents, contentsErr = file.Contents()
+			return contentsErr
+		},
+		provider.readRetryOptions(file.Name)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get git file contents for '%v': %v", file.Name, err)
+	}
+	return []byte(contents), nil
+}
+
+// readRetryOptions applies --read-retries and --read-retry-delay, unset values keep the defaults
+func (provider *repositoryProvider) readRetryOptions(filePath string) []retry.Option {
+	attempts := provider.opts.ReadRetries
+	if attempts <= 0 {
+		attempts = options.DEFAULT_READ_RETRIES
+	}
+	delay := provider.opts.ReadRetryDelay
+	if delay <= 0 {
+		delay = options.DEFAULT_READ_RETRY_DELAY
+	}
+	return []retry.Option{
+		retry.Attempts(uint(attempts)),
+		retry.Delay(delay),
+		retry.OnRetry(func(attempt uint, err error) {
+			provider.verboseLog("*** reading '%v' failed on attempt %v of %v: %v", filePath, attempt+1, attempts, err)
+		}),
+	}
+}
+
 func isFileInList(provider *repositoryProvider, filePathToCheck string) bool {
 	_, inFileList := provider.fileListToSnap[filePathToCheck]
 	return inFileList || len(provider.fileListToSnap) == 0
 }
 
-func addEntryToIndexFile(indexFile *csv.Writer, name string, entry *object.TreeEntry) error {
-	if indexFile != nil && utf8.ValidString(name) {
-		record := []string{name, entry.Hash.String(), strconv.FormatBool(entry.Mode.IsFile())}
-		err := indexFile.Write(record)
-		if err != nil {
-			return err
+func getTree(commit *object.Commit) (*object.Tree, error) {
+	tree, err := commit.Tree()
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, &util.ErrorWithCode{
+			StatusCode: util.ERROR_TREE_NOT_FOUND,
+			InternalError: fmt.Errorf(
+				"tree object %v of commit '%v' is missing from the object store, the clone is probably partial - fetch it with 'git fetch origin %v' and retry: %w",
+				commit.TreeHash, commit.Hash, commit.Hash, err,
+			),
 		}
-		indexFile.Flush()
 	}
-	return nil
+	if err != nil {
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_TREE_NOT_FOUND,
+			InternalError: fmt.Errorf("failed to get tree of commit '%v': %v", commit.Hash, err),
+		}
+	}
+	return tree, nil
 }
 
-func (provider *repositoryProvider) snapshot(repository *git.Repository, commit *object.Commit, outputPath string, optionalIndexFilePath string, indexOnly bool, dryRun bool) (int, error) {
+// getSnapshotTree returns the tree to snapshot, which is the subtree at --subtree if set - paths are relative to it
+func (provider *repositoryProvider) getSnapshotTree(commit *object.Commit) (*object.Tree, error) {
+	tree, err := getTree(commit)
+	if err != nil || provider.opts.Subtree == "" {
+		return tree, err
+	}
 
-	tree, err := commit.Tree()
+	entry, err := tree.FindEntry(provider.opts.Subtree)
+	if err == nil && entry.Mode != filemode.Dir {
+		err = fmt.Errorf("not a directory")
+	}
+	if err == nil {
+		tree, err = tree.Tree(provider.opts.Subtree)
+	}
 	if err != nil {
-		return 0, &util.ErrorWithCode{
+		return nil, &util.ErrorWithCode{
 			StatusCode:    util.ERROR_TREE_NOT_FOUND,
-			InternalError: fmt.Errorf("failed to get tree of commit '%v': %v", commit.Hash, err),
+			InternalError: fmt.Errorf("subtree '%v' of commit '%v' is not found: %v", provider.opts.Subtree, commit.Hash, err),
+		}
+	}
+	return tree, nil
+}
+
+// repositoryPath returns the path of a snapshotted file relative to the repository root
+func (provider *repositoryProvider) repositoryPath(filePath string) string {
+	return path.Join(provider.opts.Subtree, filePath)
+}
+
+func summarizeRecords(records []*indexRecord) SnapshotResult {
+	result := SnapshotResult{}
+	for _, record := range records {
+		if !record.entry.Mode.IsFile() {
+			continue
+		}
+		if record.shared || record.linked {
+			result.DedupedFiles++
+			result.DedupedBytes += record.size
+		}
+		if record.shared {
+			continue
+		}
+		if record.snapped {
+			result.FilesWritten++
+			result.BytesWritten += record.size
+		} else {
+			result.SkippedCount++
 		}
 	}
+	return result
+}
+
+// outputStream is where the archive is written for the - output path
+func (provider *repositoryProvider) outputStream() io.Writer {
+	if provider.opts.OutputWriter != nil {
+		return provider.opts.OutputWriter
+	}
+	return os.Stdout
+}
+
+func (provider *repositoryProvider) snapshot(repository *git.Repository, commit *object.Commit, outputPath string, optionalIndexFilePath string, indexOnly bool, dryRun bool) (int, error) {
+
+	tree, err := provider.getSnapshotTree(commit)
+	if err != nil {
+		return 0, err
+	}
 	count := 0
 
-	treeWalker := object.NewTreeWalker(tree, true, nil)
+	if !dryRun {
+		provider.writtenPaths = newPathSet()
+		provider.dedupEntries = map[dedupKey]*dedupEntry{}
+	}
+	provider.totalSize.Store(0)
+	// the dry run computes the same total as the snapshot, so a snapshot which is too large fails before writing
+	checkTotalSize := dryRun && !indexOnly && provider.opts.MaxTotalSizeBytes > 0
+
+	treeWalker := provider.newSnapshotWalker(commit, tree)
 	defer treeWalker.Close()
 
-	var indexOutputFile *csv.Writer = nil
-	if optionalIndexFilePath != "" && !dryRun {
-		locIndexOutputFile, err := os.Create(optionalIndexFilePath)
+	if !dryRun && !indexOnly && isArchiveFormat(provider.opts.Format) {
+		provider.archive, err = createArchive(outputPath, provider.outputStream(), provider.opts.Format, provider.opts.CompressionLevel, commit.Author.When)
 		if err != nil {
-			return 0, fmt.Errorf("failed to create index file '%v': %v", optionalIndexFilePath, err)
+			return 0, err
 		}
+		defer func() {
+			provider.archive = nil
+		}()
+	}
 
-		csvWriter := csv.NewWriter(locIndexOutputFile)
-		csvWriter.Comma = '\t'
-		err = csvWriter.Write([]string{"Path", "BlobId", "IsFile"})
-		if err != nil {
-			return 0, fmt.Errorf("failed to write file headers '%v': %v", optionalIndexFilePath, err)
+	var indexOutputFile indexWriter = nil
+	var indexBuffer *bytes.Buffer = nil
+	if optionalIndexFilePath != "" && !dryRun {
+		var indexWriter io.Writer
+		if provider.archive != nil {
+			// the index is added to the archive once complete
+			indexBuffer = &bytes.Buffer{}
+			indexWriter = indexBuffer
+		} else {
+			locIndexOutputFile, err := os.Create(optionalIndexFilePath)
+			if err != nil {
+				return 0, fmt.Errorf("failed to create index file '%v': %v", optionalIndexFilePath, err)
+			}
+			defer locIndexOutputFile.Close()
+			indexWriter = locIndexOutputFile
 		}
 
-		defer locIndexOutputFile.Close()
+		indexOutputFile, err = newIndexWriter(indexWriter, provider.opts.IndexFormat, provider.indexHeaders())
+		if err != nil {
+			return 0, fmt.Errorf("failed to write index file '%v': %v", optionalIndexFilePath, err)
+		}
+	}
 
-		indexOutputFile = csvWriter
+	var queue *parallel.JobQueue = nil
+	var records []*indexRecord
+	if !dryRun {
+		workers := provider.opts.Workers
+		if workers <= 0 {
+			workers = runtime.NumCPU()
+		}
+		queue = parallel.NewJobQueue(workers)
+		if provider.opts.MaxInflightBytes > 0 {
+			provider.inflight = parallel.NewWeighted(provider.opts.MaxInflightBytes)
+		}
 	}
 
 	for {
+		provider.storeMutex.Lock()
 		name, entry, walkErr := treeWalker.Next()
+		provider.storeMutex.Unlock()
 		if walkErr == io.EOF {
-			return count, nil
+			break
 		}
 
 		if walkErr != nil {
-			return 0, fmt.Errorf("failed to iterate files of %v: %v", commit.Hash, err)
+			if queue != nil {
+				_ = queue.Wait()
+			}
+			if provider.archive != nil {
+				provider.archive.abort()
+			}
+			return 0, fmt.Errorf("failed to iterate files of %v: %v", commit.Hash, walkErr)
 		}
 
 		count++
+		if checkTotalSize && entry.Mode.IsFile() {
+			var file *object.File
+			file, err = provider.selectFile(name, &entry)
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				// skipped by the snapshot as well
+				err = nil
+			}
+			if err == nil && file != nil {
+				err = provider.addToTotalSize(name, file.Size)
+			}
+			if err != nil {
+				break
+			}
+		}
 		if !dryRun {
-			if entry.Mode.IsFile() {
-				err, didSnap := provider.dumpFile(repository, name, &entry, outputPath, indexOnly)
-				if err != nil {
-					if errors.Is(err, plumbing.ErrObjectNotFound) {
-						log.Printf("Can't get blob %s: %s", name, err)
-					} else {
-						break
-					}
+			// index records are kept in tree order and written once all files were dumped
+			record := &indexRecord{path: name, entry: &entry, linesOfCode: -1, snapped: !entry.Mode.IsFile()}
+			records = append(records, record)
+			if !entry.Mode.IsFile() {
+				provider.progress.advance(0)
+				continue
+			}
+			err = queue.Submit(func() error {
+				// the jobs queued when the context is done are dropped
+				if ctxErr := provider.ctx.Err(); ctxErr != nil {
+					return ctxErr
 				}
-
-				if !didSnap {
-					continue
+				dumpErr := provider.dumpRecord(repository, record, outputPath, indexOnly)
+				if dumpErr == nil && record.snapped && !indexOnly {
+					provider.progress.advance(record.size)
+				} else {
+					provider.progress.advance(0)
 				}
+				return dumpErr
+			})
+			if err != nil {
+				break
 			}
+		}
+	}
+
+	if queue != nil {
+		queueErr := queue.Wait()
+		if err == nil {
+			err = queueErr
+		}
+	}
+
+	if err == nil && !dryRun && provider.opts.DoubleCheck == options.DOUBLE_CHECK_HASH && !provider.opts.SkipDoubleCheck {
+		provider.recordWrittenFiles(records)
+	}
 
-			err = addEntryToIndexFile(indexOutputFile, name, &entry)
+	if err == nil && !dryRun && !indexOnly && provider.archive == nil && provider.opts.KeepEmptyDirs {
+		err = provider.createKeptDirectories(outputPath, records)
+	}
+
+	if err == nil && !dryRun && !indexOnly && provider.archive == nil && provider.opts.Prune {
+		err = provider.pruneOutput(outputPath, records)
+	}
+
+	if err == nil && !dryRun && !indexOnly && provider.archive == nil && provider.opts.VerifyContent {
+		err = provider.verifyContent(outputPath, records)
+	}
+
+	if err == nil && !dryRun && !indexOnly {
+		provider.result = summarizeRecords(records)
+	}
+
+	if err == nil && !dryRun && !indexOnly && provider.opts.WithStatsPath != "" {
+		err = provider.writeRecordStats(provider.opts.WithStatsPath, records)
+	}
+
+	if err == nil && !dryRun && provider.opts.ChecksumPath != "" {
+		err = provider.writeChecksum(provider.opts.ChecksumPath, records)
+	}
+
+	if err == nil {
+		for _, record := range records {
+			if !record.snapped {
+				continue
+			}
+			err = provider.addEntryToIndexFile(indexOutputFile, record)
 			if err != nil {
 				break
 			}
 		}
 	}
 
+	if err == nil && indexOutputFile != nil {
+		err = indexOutputFile.flush()
+		if err != nil {
+			err = fmt.Errorf("failed to write index file '%v': %v", optionalIndexFilePath, err)
+		}
+	}
+
+	if err == nil && provider.archive != nil {
+		err = provider.finishArchive(records, indexBuffer, optionalIndexFilePath)
+	} else if provider.archive != nil {
+		provider.archive.abort()
+	}
+
 	if err != nil {
+		var errorWithCode *util.ErrorWithCode
+		if errors.As(err, &errorWithCode) {
+			return 0, err
+		}
 		if errors.Is(err, dotgit.ErrPackfileNotFound) {
 			return 0, &util.ErrorWithCode{
 				StatusCode:    util.ERROR_BAD_CLONE_GIT,
@@ -407,13 +1425,28 @@ func (provider *repositoryProvider) snapshot(repository *git.Repository, commit
 	}
 	provider.verboseLog("iterated %v files for %v", count, commit.Hash)
 
-	if indexOutputFile != nil {
-		indexOutputFile.Flush()
-	}
-
 	return count, nil
 }
 
+// targetFileMode returns the permissions of a written file - its git mode with --preserve-mode, otherwise --file-mode,
+// with execute permission added wherever read permission is given for executables
+func (provider *repositoryProvider) targetFileMode(mode filemode.FileMode) os.FileMode {
+	if provider.opts.PreserveMode {
+		osMode, err := mode.ToOSFileMode()
+		if err == nil && osMode.Perm() != 0 {
+			return osMode.Perm()
+		}
+	}
+	var perm os.FileMode = TARGET_PERMISSIONS
+	if provider.opts.FileMode != 0 {
+		perm = provider.opts.FileMode
+	}
+	if mode == filemode.Executable {
+		perm |= (perm & 0444) >> 2
+	}
+	return perm
+}
+
 func (provider *repositoryProvider) isSymlink(filePath string, mode filemode.FileMode) bool {
 	osMode, err := mode.ToOSFileMode()
 	if err != nil {
@@ -5,6 +5,7 @@ package git
 import (
 	"fmt"
 	"gitsnap/options"
+	"gitsnap/util"
 	"log"
 	"os"
 	"os/exec"
@@ -12,6 +13,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/gobwas/glob"
 )
 
 func timed(op func()) (elapsedSeconds float64) {
@@ -78,7 +81,7 @@ func gitWorktreeCheckout(clonePath string, commitish string) {
 }
 
 func benchmark(remote string) {
-	clonePath := cloneLocal(remote)
+	clonePath := cloneLocal(remote, "")
 
 	archiveSec := timed(func() {
 		gitArchive(clonePath, "master")
@@ -142,3 +145,85 @@ func TestBenchmark(t *testing.T) {
 		benchmark(remote)
 	}
 }
+
+// BenchmarkWriteLargeFile compares the memory of writing a large blob by reading its contents with streaming it
+func BenchmarkWriteLargeFile(b *testing.B) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"large.txt": strings.Repeat("0123456789abcdef\n", 4*STREAM_MIN_SIZE/16),
+	})
+	defer os.RemoveAll(clonePath)
+	provider, err := newRepositoryProvider(&options.Options{ClonePath: clonePath, Logger: options.NewStdLogger()})
+	if err != nil {
+		b.Fatal(err)
+	}
+	commit, err := provider.getCommit(revision)
+	if err != nil {
+		b.Fatal(err)
+	}
+	file, err := commit.File("large.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	outputPath := b.TempDir()
+	targetFilePath := filepath.Join(outputPath, "large.txt")
+
+	b.Run("read", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			contents, err := provider.readContents(file)
+			if err == nil {
+				err = provider.writeTargetFile(outputPath, targetFilePath, contents, TARGET_PERMISSIONS)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err := provider.streamTargetFile(outputPath, targetFilePath, file, TARGET_PERMISSIONS)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkExcludePatterns compares matching many directory excludes by globs, by their fast paths, and with the
+// decisions cached per directory
+func BenchmarkExcludePatterns(b *testing.B) {
+	excludes := util.NoisyDirectoryExclusionPatterns()
+	for i := 0; i < 100; i++ {
+		excludes = append(excludes, fmt.Sprintf("**/gen%v/**", i))
+	}
+	provider := &repositoryProvider{opts: &options.Options{}, logger: options.NewStdLogger()}
+	matcher, err := provider.compileExcludePatterns(excludes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	globs := uncachedExcludeMatcher(matcher)
+	for i, pattern := range provider.expandPatternsIfNeeded(excludes) {
+		globs.patterns[i].pattern = glob.MustCompile(pattern)
+	}
+	filePaths := syntheticPaths(20000)
+
+	for _, test := range []struct {
+		name    string
+		matcher func() *excludeMatcher
+	}{
+		{"glob", func() *excludeMatcher { return globs }},
+		{"fast", func() *excludeMatcher { return uncachedExcludeMatcher(matcher) }},
+		{"cached", func() *excludeMatcher { return newExcludeMatcher(matcher.patterns) }},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// a new matcher for every snapshot, so the cache is filled as it is by a snapshot
+				matcher := test.matcher()
+				for _, filePath := range filePaths {
+					matcher.isExcluded(filePath)
+				}
+			}
+		})
+	}
+}
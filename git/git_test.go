@@ -2,15 +2,24 @@ package git
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"gitsnap/options"
+	"gitsnap/util"
+	"io"
 	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -50,6 +59,60 @@ func cloneLocal(remote string, filter string) (clonePath string) {
 	return
 }
 
+func runCommandIn(dirPath string, name string, args ...string) string {
+	proc := exec.Command(name, args...)
+	proc.Dir = dirPath
+	output, err := proc.CombinedOutput()
+	if err != nil {
+		panic(fmt.Errorf("%v %v failed: %v\n%v", name, args, err, string(output)))
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func runGit(dirPath string, args ...string) string {
+	return runCommandIn(dirPath, "git", append([]string{"-c", "user.name=tester", "-c", "user.email=tester@example.com"}, args...)...)
+}
+
+func writeFiles(dirPath string, files map[string]string) {
+	for name, content := range files {
+		filePath := filepath.Join(dirPath, name)
+		err := os.MkdirAll(filepath.Dir(filePath), 0777)
+		if err != nil {
+			panic(err)
+		}
+		err = os.WriteFile(filePath, []byte(content), 0644)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func commitFiles(clonePath string, files map[string]string, author string) (revision string) {
+	writeFiles(clonePath, files)
+	runGit(clonePath, "add", "-A")
+	runGit(clonePath, "commit", "-q", "-m", "update", "--author", author)
+	return runGit(clonePath, "rev-parse", "HEAD")
+}
+
+// commitFilesAt commits like commitFiles, authored and committed at the given date
+func commitFilesAt(clonePath string, files map[string]string, date string) {
+	writeFiles(clonePath, files)
+	runGit(clonePath, "add", "-A")
+	runCommandIn(clonePath, "env", "GIT_COMMITTER_DATE="+date, "GIT_AUTHOR_DATE="+date,
+		"git", "-c", "user.name=tester", "-c", "user.email=tester@example.com", "commit", "-q", "-m", "update")
+}
+
+func createLocalRepo(files map[string]string) (clonePath string, revision string) {
+	var err error
+	clonePath, err = os.MkdirTemp("", "")
+	if err != nil {
+		panic(err)
+	}
+	runGit(clonePath, "init", "-q")
+	revision = commitFiles(clonePath, files, "tester <tester@example.com>")
+	return
+}
+
 func (gitSuite *gitTestSuite) SetupTest() {
 	gitSuite.remote = "https://github.com/apiirolab/dc-heacth.git"
 	gitSuite.clonePath = cloneLocal(gitSuite.remote, "")
@@ -252,6 +315,7 @@ func (gitSuite *gitTestSuite) TestSnapshotForShortSha() {
 	err := Snapshot(&options.Options{
 		ClonePath:         gitSuite.clonePath,
 		Revision:          "2ca7420",
+		SupportShortSha:   true,
 		OutputPath:        gitSuite.outputPath,
 		IncludePatterns:   []string{},
 		ExcludePatterns:   []string{},
@@ -564,3 +628,677 @@ func (gitSuite *gitTestSuite) TestSnapshotWithPathsFile() {
 	gitSuite.Nil(err)
 	gitSuite.verifyOutputPath(7, 1, 1696, 1696)
 }
+
+func removeLooseObject(clonePath string, hash string) {
+	err := os.Remove(filepath.Join(clonePath, ".git", "objects", hash[:2], hash[2:]))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestSnapshotWithMissingTree(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"file.txt": "content",
+	})
+	defer os.RemoveAll(clonePath)
+	removeLooseObject(clonePath, runGit(clonePath, "rev-parse", "HEAD^{tree}"))
+
+	outputPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(outputPath)
+
+	err = Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      outputPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+	})
+	var errorWithCode *util.ErrorWithCode
+	require.ErrorAs(t, err, &errorWithCode)
+	require.Equal(t, util.ERROR_TREE_NOT_FOUND, errorWithCode.StatusCode)
+	require.Contains(t, err.Error(), "missing from the object store")
+}
+
+func TestSnapshotForBloblessPartialClone(t *testing.T) {
+	sourcePath, revision := createLocalRepo(map[string]string{
+		"a.txt":        "a",
+		"nested/b.txt": "b",
+	})
+	defer os.RemoveAll(sourcePath)
+
+	clonePath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(clonePath)
+	runGit(sourcePath, "config", "uploadpack.allowFilter", "true")
+	runGit(clonePath, "clone", "-q", "--no-checkout", "--filter=blob:none", "file://"+sourcePath, ".")
+
+	outputPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(outputPath)
+
+	err = Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      outputPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+	})
+	require.Nil(t, err)
+
+	entries, err := os.ReadDir(outputPath)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+}
+
+func TestSnapshotForBloblessPartialCloneWithFetchMissing(t *testing.T) {
+	sourcePath, revision := createLocalRepo(map[string]string{
+		"a.txt":        "a",
+		"nested/b.txt": "b",
+		"nested/c.txt": "c",
+	})
+	defer os.RemoveAll(sourcePath)
+
+	clonePath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(clonePath)
+	runGit(sourcePath, "config", "uploadpack.allowFilter", "true")
+	runGit(sourcePath, "config", "uploadpack.allowAnySHA1InWant", "true")
+	runGit(clonePath, "clone", "-q", "--no-checkout", "--filter=blob:none", "file://"+sourcePath, ".")
+
+	outputPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(outputPath)
+
+	err = Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      outputPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+		FetchMissing:    true,
+		MaxFetches:      2,
+		Workers:         1,
+	})
+	require.Nil(t, err)
+
+	content, err := os.ReadFile(filepath.Join(outputPath, "a.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "a", string(content))
+	content, err = os.ReadFile(filepath.Join(outputPath, "nested", "b.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "b", string(content))
+	require.NoFileExists(t, filepath.Join(outputPath, "nested", "c.txt"))
+}
+
+func TestSnapshotWithWorkers(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("dir%v/file%v.txt", i%5, i)] = fmt.Sprintf("content %v", i)
+	}
+	clonePath, _ := createLocalRepo(files)
+	defer os.RemoveAll(clonePath)
+	// leave some objects packed and some loose
+	runGit(clonePath, "gc", "-q")
+	for i := 50; i < 100; i++ {
+		files[fmt.Sprintf("dir%v/file%v.txt", i%5, i)] = fmt.Sprintf("content %v", i)
+	}
+	revision := commitFiles(clonePath, files, "tester <tester@example.com>")
+
+	indexes := map[int]string{}
+	for _, workers := range []int{1, 8} {
+		outputPath, err := os.MkdirTemp("", "")
+		require.Nil(t, err)
+		defer os.RemoveAll(outputPath)
+		indexPath := filepath.Join(t.TempDir(), "index.tsv")
+
+		err = Snapshot(&options.Options{
+			ClonePath:             clonePath,
+			Revision:              revision,
+			OutputPath:            outputPath,
+			OptionalIndexFilePath: indexPath,
+			IncludePatterns:       []string{},
+			ExcludePatterns:       []string{},
+			Workers:               workers,
+		})
+		require.Nil(t, err)
+
+		for name, content := range files {
+			written, err := os.ReadFile(filepath.Join(outputPath, name))
+			require.Nil(t, err)
+			require.Equal(t, content, string(written))
+		}
+		index, err := os.ReadFile(indexPath)
+		require.Nil(t, err)
+		indexes[workers] = string(index)
+	}
+	require.Equal(t, indexes[1], indexes[8])
+}
+
+func TestReadFilePathsList(t *testing.T) {
+	provider := &repositoryProvider{
+		opts:           &options.Options{},
+		fileListToSnap: map[string]bool{},
+	}
+
+	err := readFilePathsList(strings.NewReader("a.txt\nnested/b.txt\ninvalid-\xff.txt\n"), "stdin", provider)
+	require.Nil(t, err)
+	require.Equal(t, map[string]bool{"a.txt": true, "nested/b.txt": true}, provider.fileListToSnap)
+}
+
+func TestShouldIncludeWithNegatedExcludePatterns(t *testing.T) {
+	provider := &repositoryProvider{
+		opts:           &options.Options{},
+		fileListToSnap: map[string]bool{},
+	}
+	var err error
+	provider.excludePatterns, err = provider.compileExcludePatterns([]string{"**/vendor/**", "!**/vendor/keep.txt", "**/vendor/drop/**"})
+	require.Nil(t, err)
+
+	require.True(t, provider.shouldInclude("main.go", filemode.Regular))
+	require.False(t, provider.shouldInclude("vendor/lib.go", filemode.Regular))
+	require.False(t, provider.shouldInclude("nested/vendor/lib.go", filemode.Regular))
+	require.True(t, provider.shouldInclude("vendor/keep.txt", filemode.Regular))
+	require.True(t, provider.shouldInclude("nested/vendor/keep.txt", filemode.Regular))
+	// a later pattern excludes again
+	require.False(t, provider.shouldInclude("vendor/drop/keep.txt", filemode.Regular))
+}
+
+func TestShouldIncludeWithIncludeAndNegatedExcludePatterns(t *testing.T) {
+	provider := &repositoryProvider{
+		opts:           &options.Options{},
+		fileListToSnap: map[string]bool{},
+	}
+	var err error
+	provider.includePatterns, err = provider.compileGlobs([]string{"**/*.go"}, "include")
+	require.Nil(t, err)
+	provider.excludePatterns, err = provider.compileExcludePatterns([]string{"vendor/**", "!vendor/keep.go", "!docs/keep.md"})
+	require.Nil(t, err)
+
+	require.True(t, provider.shouldInclude("main.go", filemode.Regular))
+	require.False(t, provider.shouldInclude("README.md", filemode.Regular))
+	// an exclude pattern after the include patterns wins
+	require.False(t, provider.shouldInclude("vendor/lib.go", filemode.Regular))
+	require.True(t, provider.shouldInclude("vendor/keep.go", filemode.Regular))
+	// and so does a negated one
+	require.True(t, provider.shouldInclude("docs/keep.md", filemode.Regular))
+}
+
+func TestShouldIncludeWithRegexPatterns(t *testing.T) {
+	provider := &repositoryProvider{
+		opts:           &options.Options{Regex: true},
+		fileListToSnap: map[string]bool{},
+	}
+	var err error
+	provider.includePatterns, err = provider.compileGlobs([]string{`(?i)\.(java|kt)$`}, "include")
+	require.Nil(t, err)
+
+	require.True(t, provider.shouldInclude("src/Main.java", filemode.Regular))
+	require.True(t, provider.shouldInclude("src/Main.KT", filemode.Regular))
+	require.False(t, provider.shouldInclude("src/main.go", filemode.Regular))
+
+	provider.includePatterns = nil
+	provider.excludePatterns, err = provider.compileExcludePatterns(append(util.NoisyDirectoryExclusionRegexPatterns(), `^src/gen/`, `!^src/gen/keep\.go$`))
+	require.Nil(t, err)
+
+	require.True(t, provider.shouldInclude("src/main.go", filemode.Regular))
+	require.False(t, provider.shouldInclude("node_modules/lib/index.js", filemode.Regular))
+	require.False(t, provider.shouldInclude("web/node_modules/lib/index.js", filemode.Regular))
+	require.False(t, provider.shouldInclude("src/gen/api.go", filemode.Regular))
+	require.True(t, provider.shouldInclude("src/gen/keep.go", filemode.Regular))
+
+	_, err = provider.compileGlobs([]string{`(`}, "include")
+	require.NotNil(t, err)
+}
+
+func TestSnapshotWithMaxTotalSize(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":        "0123456789",
+		"nested/b.txt": "0123456789",
+		"nested/c.txt": "0123456789",
+	})
+	defer os.RemoveAll(clonePath)
+
+	for _, testCase := range []struct {
+		maxTotalSize    int64
+		skipDoubleCheck bool
+		fails           bool
+	}{
+		{30, false, false},
+		{25, false, true},
+		{25, true, true},
+	} {
+		outputPath, err := os.MkdirTemp("", "")
+		require.Nil(t, err)
+		defer os.RemoveAll(outputPath)
+
+		err = Snapshot(&options.Options{
+			ClonePath:         clonePath,
+			Revision:          revision,
+			OutputPath:        outputPath,
+			IncludePatterns:   []string{},
+			ExcludePatterns:   []string{},
+			MaxTotalSizeBytes: testCase.maxTotalSize,
+			SkipDoubleCheck:   testCase.skipDoubleCheck,
+		})
+		if !testCase.fails {
+			require.Nil(t, err)
+			continue
+		}
+		var errorWithCode *util.ErrorWithCode
+		require.ErrorAs(t, err, &errorWithCode)
+		require.Equal(t, util.ERROR_MAX_TOTAL_SIZE_EXCEEDED, errorWithCode.StatusCode)
+		if !testCase.skipDoubleCheck {
+			// the dry run fails before anything is written
+			entries, err := os.ReadDir(outputPath)
+			require.Nil(t, err)
+			require.Empty(t, entries)
+		}
+	}
+}
+
+func TestSnapshotWithFileModes(t *testing.T) {
+	clonePath, _ := createLocalRepo(map[string]string{
+		"a.txt":         "a",
+		"nested/run.sh": "#!/bin/sh",
+	})
+	defer os.RemoveAll(clonePath)
+	runCommandIn(clonePath, "chmod", "+x", "nested/run.sh")
+	revision := commitFiles(clonePath, map[string]string{}, "tester <tester@example.com>")
+
+	for _, testCase := range []struct {
+		fileMode           os.FileMode
+		preserveMode       bool
+		expectedMode       os.FileMode
+		expectedScriptMode os.FileMode
+	}{
+		{0, false, 0644, 0755},
+		{0600, false, 0600, 0700},
+		{0600, true, 0644, 0755},
+	} {
+		outputPath, err := os.MkdirTemp("", "")
+		require.Nil(t, err)
+		defer os.RemoveAll(outputPath)
+
+		err = Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			FileMode:        testCase.fileMode,
+			PreserveMode:    testCase.preserveMode,
+		})
+		require.Nil(t, err)
+
+		info, err := os.Stat(filepath.Join(outputPath, "a.txt"))
+		require.Nil(t, err)
+		require.Equal(t, testCase.expectedMode, info.Mode().Perm())
+		info, err = os.Stat(filepath.Join(outputPath, "nested", "run.sh"))
+		require.Nil(t, err)
+		require.Equal(t, testCase.expectedScriptMode, info.Mode().Perm())
+		info, err = os.Stat(filepath.Join(outputPath, "nested"))
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(TARGET_DIRECTORY_PERMISSIONS), info.Mode().Perm())
+	}
+}
+
+func TestSnapshotWithSubtree(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":                   "a",
+		"modules/one/b.txt":       "b",
+		"modules/one/inner/c.txt": "c",
+		"modules/two/d.txt":       "d",
+	})
+	defer os.RemoveAll(clonePath)
+
+	outputPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(outputPath)
+	indexPath := filepath.Join(t.TempDir(), "index.tsv")
+
+	err = Snapshot(&options.Options{
+		ClonePath:             clonePath,
+		Revision:              revision,
+		OutputPath:            outputPath,
+		OptionalIndexFilePath: indexPath,
+		IncludePatterns:       []string{},
+		ExcludePatterns:       []string{},
+		Subtree:               "modules/one",
+	})
+	require.Nil(t, err)
+
+	content, err := os.ReadFile(filepath.Join(outputPath, "inner", "c.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "c", string(content))
+	require.FileExists(t, filepath.Join(outputPath, "b.txt"))
+	require.NoFileExists(t, filepath.Join(outputPath, "a.txt"))
+	require.NoDirExists(t, filepath.Join(outputPath, "modules"))
+
+	index, err := os.ReadFile(indexPath)
+	require.Nil(t, err)
+	require.Contains(t, string(index), "inner/c.txt\t")
+	require.NotContains(t, string(index), "modules/")
+
+	for _, subtree := range []string{"modules/missing", "a.txt"} {
+		err = Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			Subtree:         subtree,
+		})
+		var errorWithCode *util.ErrorWithCode
+		require.ErrorAs(t, err, &errorWithCode)
+		require.Equal(t, util.ERROR_TREE_NOT_FOUND, errorWithCode.StatusCode)
+		require.Contains(t, err.Error(), subtree)
+	}
+}
+
+func TestSnapshotForAnnotatedTags(t *testing.T) {
+	clonePath, tagged := createLocalRepo(map[string]string{
+		"a.txt": "tagged",
+	})
+	defer os.RemoveAll(clonePath)
+	runGit(clonePath, "tag", "-a", "v1.2.3", "-m", "release")
+	runGit(clonePath, "tag", "-a", "v1.2.3-final", "v1.2.3", "-m", "tag of a tag")
+	commitFiles(clonePath, map[string]string{
+		"a.txt": "after the tag",
+	}, "tester <tester@example.com>")
+
+	for _, revision := range []string{"v1.2.3", "v1.2.3-final", runGit(clonePath, "rev-parse", "v1.2.3")} {
+		outputPath := t.TempDir()
+		err := Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+		})
+		require.Nil(t, err, revision)
+
+		content, err := os.ReadFile(filepath.Join(outputPath, "a.txt"))
+		require.Nil(t, err)
+		require.Equal(t, "tagged", string(content), revision)
+	}
+	require.NotEqual(t, tagged, runGit(clonePath, "rev-parse", "v1.2.3"))
+}
+
+func TestSnapshotWithResult(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":           "a",
+		"nested/b.txt":    "bb",
+		"nested/deep/c.x": "ccc",
+		"skipped.md":      "skipped",
+	})
+	defer os.RemoveAll(clonePath)
+
+	outputPath := t.TempDir()
+	result, err := SnapshotWithResult(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      outputPath,
+		IncludePatterns: []string{"**.txt", "**.x"},
+		ExcludePatterns: []string{},
+	})
+	require.Nil(t, err)
+
+	filesOnDisk := 0
+	var bytesOnDisk int64
+	err = filepath.WalkDir(outputPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		filesOnDisk++
+		bytesOnDisk += info.Size()
+		return nil
+	})
+	require.Nil(t, err)
+
+	require.Equal(t, 3, filesOnDisk)
+	require.Equal(t, filesOnDisk, result.FilesWritten)
+	require.Equal(t, bytesOnDisk, result.BytesWritten)
+	require.Equal(t, 1, result.SkippedCount)
+	require.GreaterOrEqual(t, result.DurationMs, int64(0))
+
+	result, err = SnapshotWithResult(&options.Options{
+		ClonePath:             clonePath,
+		Revision:              revision,
+		OptionalIndexFilePath: filepath.Join(t.TempDir(), "index.tsv"),
+		IndexOnly:             true,
+		IncludePatterns:       []string{},
+		ExcludePatterns:       []string{},
+	})
+	require.Nil(t, err)
+	require.Equal(t, 0, result.FilesWritten)
+	require.Equal(t, int64(0), result.BytesWritten)
+}
+
+func TestSnapshotFailOnEmpty(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt": "a",
+	})
+	defer os.RemoveAll(clonePath)
+
+	for _, failOnEmpty := range []bool{false, true} {
+		for _, includePattern := range []string{"**.txt", "**.java"} {
+			err := Snapshot(&options.Options{
+				ClonePath:       clonePath,
+				Revision:        revision,
+				OutputPath:      t.TempDir(),
+				IncludePatterns: []string{includePattern},
+				ExcludePatterns: []string{},
+				FailOnEmpty:     failOnEmpty,
+			})
+			if !failOnEmpty || includePattern == "**.txt" {
+				require.Nil(t, err)
+				continue
+			}
+			var errorWithCode *util.ErrorWithCode
+			require.ErrorAs(t, err, &errorWithCode)
+			require.Equal(t, util.ERROR_NO_FILES_WRITTEN, errorWithCode.StatusCode)
+		}
+	}
+}
+
+// cancelingLogger cancels the snapshot once the first file was written
+type cancelingLogger struct {
+	recordingLogger
+	cancel context.CancelFunc
+}
+
+func (logger *cancelingLogger) Debugf(format string, v ...interface{}) {
+	if strings.HasPrefix(format, "+++") {
+		logger.cancel()
+	}
+	logger.recordingLogger.Debugf(format, v...)
+}
+
+func TestSnapshotContext(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("dir%v/file%v.txt", i%5, i)] = fmt.Sprintf("file %v", i)
+	}
+	clonePath, revision := createLocalRepo(files)
+	defer os.RemoveAll(clonePath)
+	snapshot := func(ctx context.Context, outputPath string, logger options.Logger) error {
+		return SnapshotContext(ctx, &options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			Workers:         1,
+			VerboseLogging:  true,
+			Logger:          logger,
+		})
+	}
+	requireCanceled := func(err error) {
+		var errorWithCode *util.ErrorWithCode
+		require.ErrorAs(t, err, &errorWithCode)
+		require.Equal(t, util.ERROR_CANCELED, errorWithCode.StatusCode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	outputPath := t.TempDir()
+	requireCanceled(snapshot(ctx, outputPath, nil))
+	entries, err := os.ReadDir(outputPath)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	requireCanceled(snapshot(ctx, t.TempDir(), nil))
+
+	// canceled while writing, the files which were not started yet are not written
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	logger := &cancelingLogger{cancel: cancel}
+	outputPath = t.TempDir()
+	requireCanceled(snapshot(ctx, outputPath, logger))
+	written := 0
+	for _, debug := range logger.debugs {
+		if strings.HasPrefix(debug, "+++") {
+			written++
+		}
+	}
+	require.Greater(t, written, 0)
+	require.Less(t, written, len(files))
+}
+
+type recordingLogger struct {
+	mutex  sync.Mutex
+	infos  []string
+	debugs []string
+}
+
+func (logger *recordingLogger) Infof(format string, v ...interface{}) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.infos = append(logger.infos, fmt.Sprintf(format, v...))
+}
+
+func (logger *recordingLogger) Debugf(format string, v ...interface{}) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.debugs = append(logger.debugs, fmt.Sprintf(format, v...))
+}
+
+func TestSnapshotWithLogger(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt": "a",
+	})
+	defer os.RemoveAll(clonePath)
+
+	for _, verbose := range []bool{false, true} {
+		logger := &recordingLogger{}
+		err := Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      t.TempDir(),
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			VerboseLogging:  verbose,
+			Logger:          logger,
+		})
+		require.Nil(t, err)
+
+		require.Contains(t, logger.infos, fmt.Sprintf("snapshotting commit '%v' for revision '%v' at clone '%v'", revision, revision, clonePath))
+		require.Contains(t, strings.Join(logger.infos, "\n"), "written 1 files to target path")
+		if verbose {
+			require.Contains(t, strings.Join(logger.debugs, "\n"), "+++ 'a.txt'")
+		} else {
+			require.Empty(t, logger.debugs)
+		}
+	}
+}
+
+// unreadableObject is a blob whose contents can never be read
+type unreadableObject struct {
+	plumbing.MemoryObject
+}
+
+func (unreadable *unreadableObject) Reader() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("object store is unavailable")
+}
+
+func TestReadContentsRetries(t *testing.T) {
+	unreadable := &unreadableObject{}
+	unreadable.SetType(plumbing.BlobObject)
+	blob, err := object.DecodeBlob(unreadable)
+	require.Nil(t, err)
+
+	logger := &recordingLogger{}
+	provider := &repositoryProvider{
+		opts: &options.Options{
+			VerboseLogging: true,
+			ReadRetries:    3,
+			ReadRetryDelay: time.Millisecond,
+		},
+		logger: logger,
+	}
+	_, err = provider.readContents(object.NewFile("a.txt", filemode.Regular, blob))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "object store is unavailable")
+	require.Len(t, logger.debugs, 3)
+	require.Equal(t, "*** reading 'a.txt' failed on attempt 1 of 3: object store is unavailable", logger.debugs[0])
+}
+
+func TestSnapshotRejectsSha256ObjectFormat(t *testing.T) {
+	clonePath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(clonePath)
+	runGit(clonePath, "init", "-q", "--object-format=sha256")
+	revision := commitFiles(clonePath, map[string]string{"a.txt": "a"}, "tester <tester@example.com>")
+	require.Len(t, revision, 64)
+
+	err = Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      t.TempDir(),
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+	})
+	var errorWithCode *util.ErrorWithCode
+	require.ErrorAs(t, err, &errorWithCode)
+	require.Equal(t, util.ERROR_BAD_CLONE_GIT, errorWithCode.StatusCode)
+	require.Contains(t, err.Error(), "the sha256 object format is not supported")
+}
+
+func TestSnapshotForLocalShortSha(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt": "a",
+	})
+	defer os.RemoveAll(clonePath)
+	// a branch named like a hash is a reference, not an abbreviated hash
+	runGit(clonePath, "branch", "cafe1234")
+
+	for _, supportShortSha := range []bool{false, true} {
+		for _, rev := range []string{revision[:7], "cafe1234", revision} {
+			outputPath := t.TempDir()
+			err := Snapshot(&options.Options{
+				ClonePath:       clonePath,
+				Revision:        rev,
+				SupportShortSha: supportShortSha,
+				OutputPath:      outputPath,
+				IncludePatterns: []string{},
+				ExcludePatterns: []string{},
+			})
+			if !supportShortSha && rev == revision[:7] {
+				var errorWithCode *util.ErrorWithCode
+				require.ErrorAs(t, err, &errorWithCode)
+				require.Equal(t, util.ERROR_NO_SHORT_SHA, errorWithCode.StatusCode)
+				continue
+			}
+			require.Nil(t, err)
+			require.FileExists(t, filepath.Join(outputPath, "a.txt"))
+		}
+	}
+}
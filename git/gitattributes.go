@@ -0,0 +1,92 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	GITATTRIBUTES_FILE_NAME = ".gitattributes"
+
+	TEXT_ATTRIBUTE   = "text"
+	BINARY_ATTRIBUTE = "binary"
+)
+
+// textAttributes resolves the text attribute of paths from the .gitattributes files committed in the tree
+type textAttributes struct {
+	rules  []gitattributes.MatchAttribute
+	macros map[string][]gitattributes.Attribute
+}
+
+func (provider *repositoryProvider) loadTextAttributes(commit *object.Commit) (*textAttributes, error) {
+	files, err := provider.loadCommittedFiles(commit, GITATTRIBUTES_FILE_NAME)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := &textAttributes{macros: map[string][]gitattributes.Attribute{}}
+	for _, file := range files {
+		scanner := bufio.NewScanner(bytes.NewReader(file.contents))
+		for scanner.Scan() {
+			// like git, macros may only be defined at the root
+			rule, err := gitattributes.ParseAttributesLine(scanner.Text(), file.domain, len(file.domain) == 0)
+			if err != nil {
+				provider.logger.Infof("--- ignoring line '%v' of %v: %v", scanner.Text(), GITATTRIBUTES_FILE_NAME, err)
+				continue
+			}
+			if len(rule.Name) == 0 {
+				continue
+			}
+			if rule.Pattern == nil {
+				attributes.macros[rule.Name] = rule.Attributes
+				continue
+			}
+			attributes.rules = append(attributes.rules, rule)
+		}
+	}
+	provider.verboseLog("loaded %v rules from %v files", len(attributes.rules), GITATTRIBUTES_FILE_NAME)
+
+	return attributes, nil
+}
+
+// isText returns whether the path is declared text (text) or binary (-text or binary). explicit is false
+// when no declaration applies, including text=auto which leaves the decision to the content.
+func (attributes *textAttributes) isText(filePath string) (text bool, explicit bool) {
+	if attributes == nil {
+		return false, false
+	}
+	pathComponents := strings.Split(filePath, "/")
+	for _, rule := range attributes.rules {
+		if !rule.Pattern.Match(pathComponents) {
+			continue
+		}
+		for _, attribute := range rule.Attributes {
+			text, explicit = attributes.apply(attribute, text, explicit, true)
+		}
+	}
+	return text, explicit
+}
+
+// apply applies a matching attribute, macros are expanded a single level
+func (attributes *textAttributes) apply(attribute gitattributes.Attribute, text bool, explicit bool, expandMacros bool) (bool, bool) {
+	switch {
+	case attribute.Name() == TEXT_ATTRIBUTE && attribute.IsSet():
+		return true, true
+	case attribute.Name() == TEXT_ATTRIBUTE && attribute.IsUnset():
+		return false, true
+	case attribute.Name() == TEXT_ATTRIBUTE:
+		return false, false
+	case attribute.Name() == BINARY_ATTRIBUTE && attribute.IsSet():
+		// the built-in binary macro is -diff -merge -text
+		return false, true
+	case attribute.IsSet() && expandMacros:
+		for _, macroAttribute := range attributes.macros[attribute.Name()] {
+			text, explicit = attributes.apply(macroAttribute, text, explicit, false)
+		}
+	}
+	return text, explicit
+}
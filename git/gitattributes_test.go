@@ -0,0 +1,51 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithGitattributes(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		".gitattributes":        "*.dat text\nbinary.txt -text\n*.lock binary\n[attr]generated -text\n*.gen.txt generated\nauto.png text=auto\n",
+		"data.dat":              "text data",
+		"binary.txt":            "binary",
+		"yarn.lock":             "lock",
+		"code.gen.txt":          "generated",
+		"auto.png":              "png",
+		"a.txt":                 "a",
+		"image.png":             "png",
+		"nested/.gitattributes": "*.png text\n",
+		"nested/image.png":      "nested png",
+	})
+	defer os.RemoveAll(clonePath)
+
+	for _, textDetect := range []string{options.TEXT_DETECT_EXTENSION, options.TEXT_DETECT_BOTH} {
+		outputPath := t.TempDir()
+		err := Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			TextFilesOnly:   true,
+			TextDetect:      textDetect,
+		})
+		require.Nil(t, err)
+
+		var written []string
+		err = filepath.WalkDir(outputPath, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				relativePath, _ := filepath.Rel(outputPath, path)
+				written = append(written, filepath.ToSlash(relativePath))
+			}
+			return err
+		})
+		require.Nil(t, err)
+		require.ElementsMatch(t, []string{".gitattributes", "nested/.gitattributes", "data.dat", "a.txt", "nested/image.png"}, written, textDetect)
+	}
+}
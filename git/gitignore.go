@@ -0,0 +1,100 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	GITIGNORE_FILE_NAME = ".gitignore"
+)
+
+// committedFile is a file of the snapshotted commit, such as a .gitignore, which applies to the directory holding it
+type committedFile struct {
+	domain   []string
+	contents []byte
+}
+
+// loadCommittedFiles reads the files with the given name from the whole tree of the commit, ordered by depth, parents first
+func (provider *repositoryProvider) loadCommittedFiles(commit *object.Commit, fileName string) ([]*committedFile, error) {
+	tree, err := getTree(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	treeWalker := object.NewTreeWalker(tree, true, nil)
+	defer treeWalker.Close()
+
+	var files []*committedFile
+	for {
+		name, entry, walkErr := treeWalker.Next()
+		if walkErr == io.EOF {
+			break
+		}
+		if walkErr != nil {
+			return nil, fmt.Errorf("failed to iterate files of %v: %v", commit.Hash, walkErr)
+		}
+		if path.Base(name) != fileName || !entry.Mode.IsFile() {
+			continue
+		}
+
+		blob, err := provider.getBlob(entry.Hash)
+		if err != nil {
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				provider.logger.Infof("--- ignoring '%v' - blob %v is missing from the object store (partial clone?): %v", name, entry.Hash, err)
+				continue
+			}
+			return nil, fmt.Errorf("failed to get blob of '%v': %v", name, err)
+		}
+		contents, err := provider.readContents(object.NewFile(name, entry.Mode, blob))
+		if err != nil {
+			return nil, err
+		}
+
+		var domain []string
+		if dir := path.Dir(name); dir != "." {
+			domain = strings.Split(dir, "/")
+		}
+		files = append(files, &committedFile{domain: domain, contents: contents})
+	}
+
+	// matchers let later patterns win, so patterns of nested files must come after those of their parents
+	sort.SliceStable(files, func(i, j int) bool {
+		return len(files[i].domain) < len(files[j].domain)
+	})
+	return files, nil
+}
+
+// loadGitignore builds a matcher from the .gitignore files committed in the tree rather than the working directory,
+// so the snapshot matches what git would track at the commit. patterns of nested files apply relative to their directory.
+func (provider *repositoryProvider) loadGitignore(commit *object.Commit) (gitignore.Matcher, error) {
+	files, err := provider.loadCommittedFiles(commit, GITIGNORE_FILE_NAME)
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []gitignore.Pattern
+	for _, file := range files {
+		scanner := bufio.NewScanner(bytes.NewReader(file.contents))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "#") || len(strings.TrimSpace(line)) == 0 {
+				continue
+			}
+			patterns = append(patterns, gitignore.ParsePattern(line, file.domain))
+		}
+	}
+	provider.verboseLog("loaded %v patterns from %v %v files", len(patterns), len(files), GITIGNORE_FILE_NAME)
+
+	return gitignore.NewMatcher(patterns), nil
+}
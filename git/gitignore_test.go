@@ -0,0 +1,57 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithApplyGitignore(t *testing.T) {
+	clonePath, _ := createLocalRepo(map[string]string{
+		".gitignore":        "# logs\n*.log\n!keep.log\nbuild/\n",
+		"nested/.gitignore": "local.txt\n",
+	})
+	defer os.RemoveAll(clonePath)
+	// ignored files are still committed, forcefully
+	writeFiles(clonePath, map[string]string{
+		"a.log":                   "ignored",
+		"keep.log":                "kept",
+		"build/out.o":             "ignored",
+		"local.txt":               "kept",
+		"nested/local.txt":        "ignored",
+		"nested/deeper/local.txt": "ignored",
+		"nested/other.txt":        "kept",
+		"excluded.txt":            "excluded",
+	})
+	runGit(clonePath, "add", "-f", "-A")
+	runGit(clonePath, "commit", "-q", "-m", "ignored")
+	revision := runGit(clonePath, "rev-parse", "HEAD")
+
+	outputPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(outputPath)
+
+	err = Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      outputPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{"excluded.txt"},
+		ApplyGitignore:  true,
+	})
+	require.Nil(t, err)
+
+	var written []string
+	err = filepath.WalkDir(outputPath, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			relativePath, _ := filepath.Rel(outputPath, path)
+			written = append(written, filepath.ToSlash(relativePath))
+		}
+		return err
+	})
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{".gitignore", "nested/.gitignore", "keep.log", "local.txt", "nested/other.txt"}, written)
+}
@@ -0,0 +1,31 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"gitsnap/options"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+)
+
+// contentDigest returns the hash recorded by hash markers and --checksum, by --hash-algo: the git blob id,
+// or the SHA-256 of the written contents
+func (provider *repositoryProvider) contentDigest(hash plumbing.Hash, contents []byte) string {
+	if provider.opts.HashAlgorithm != options.HASH_ALGO_SHA256 {
+		return hash.String()
+	}
+	digest := sha256.Sum256(contents)
+	return hex.EncodeToString(digest[:])
+}
+
+// writeHashMarkerToDir writes the hash marker of a written file to <hash-markers-dir>/<target path>.hash,
+// mirroring the layout of the snapshot outside of it
+func (provider *repositoryProvider) writeHashMarkerToDir(filePath string, relativeTargetPath string, digest string) {
+	markerPath := filepath.Join(provider.opts.HashMarkersDir, relativeTargetPath) + ".hash"
+	err := writeFileCreatingDirs(markerPath, []byte(digest), provider.targetFileMode(filemode.Regular))
+	if err != nil {
+		provider.logger.Infof("failed to write hash file of '%v' to '%v': %v", filePath, markerPath, err)
+	}
+}
@@ -0,0 +1,92 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithHashMarkersDir(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":        "a",
+		"nested/b.txt": "b",
+	})
+	defer os.RemoveAll(clonePath)
+
+	for _, format := range []string{options.FORMAT_DIR, options.FORMAT_TAR} {
+		outputPath := t.TempDir()
+		if format != options.FORMAT_DIR {
+			outputPath = filepath.Join(outputPath, "snapshot.tar")
+		}
+		hashMarkersDir := filepath.Join(t.TempDir(), "markers")
+		err := Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			Format:          format,
+			HashMarkersDir:  hashMarkersDir,
+		})
+		require.Nil(t, err)
+
+		for _, filePath := range []string{"a.txt", "nested/b.txt"} {
+			marker, err := os.ReadFile(filepath.Join(hashMarkersDir, filepath.FromSlash(filePath)+".hash"))
+			require.Nil(t, err)
+			require.Equal(t, runGit(clonePath, "rev-parse", revision+":"+filePath), string(marker))
+		}
+		if format == options.FORMAT_DIR {
+			require.NoFileExists(t, filepath.Join(outputPath, "a.txt.hash"))
+			require.NoFileExists(t, filepath.Join(outputPath, "nested", "b.txt.hash"))
+		}
+	}
+}
+
+func TestSnapshotWithHashAlgorithm(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"a.txt":        "a",
+		"nested/b.txt": "bb",
+	})
+	defer os.RemoveAll(clonePath)
+
+	checksums := map[string]string{}
+	for _, hashAlgorithm := range []string{options.HASH_ALGO_SHA1, options.HASH_ALGO_SHA256} {
+		outputPath := t.TempDir()
+		checksumPath := filepath.Join(t.TempDir(), "checksum")
+		err := Snapshot(&options.Options{
+			ClonePath:         clonePath,
+			Revision:          revision,
+			OutputPath:        outputPath,
+			IncludePatterns:   []string{},
+			ExcludePatterns:   []string{},
+			CreateHashMarkers: true,
+			HashAlgorithm:     hashAlgorithm,
+			ChecksumPath:      checksumPath,
+		})
+		require.Nil(t, err)
+
+		for _, filePath := range []string{"a.txt", "nested/b.txt"} {
+			targetFilePath := filepath.Join(outputPath, filepath.FromSlash(filePath))
+			marker, err := os.ReadFile(targetFilePath + ".hash")
+			require.Nil(t, err)
+			if hashAlgorithm == options.HASH_ALGO_SHA1 {
+				require.Equal(t, runGit(clonePath, "rev-parse", revision+":"+filePath), string(marker))
+				continue
+			}
+			contents, err := os.ReadFile(targetFilePath)
+			require.Nil(t, err)
+			digest := sha256.Sum256(contents)
+			require.Equal(t, hex.EncodeToString(digest[:]), string(marker))
+		}
+
+		checksum, err := os.ReadFile(checksumPath)
+		require.Nil(t, err)
+		checksums[hashAlgorithm] = string(checksum)
+	}
+	require.NotEqual(t, checksums[options.HASH_ALGO_SHA1], checksums[options.HASH_ALGO_SHA256])
+}
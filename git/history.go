@@ -0,0 +1,68 @@
+package git
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func blobHashAt(commit *object.Commit, filePath string) (plumbing.Hash, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	entry, err := tree.FindEntry(filePath)
+	if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+		return plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return entry.Hash, nil
+}
+
+// isSingleCommitByBot walks the first-parent history of the file, up to the configured number of commits,
+// and reports whether it was only ever touched by its creating commit, authored by a bot.
+// this costs a tree lookup per walked commit for every candidate file, so it is opt-in.
+func (provider *repositoryProvider) isSingleCommitByBot(filePath string, blobHash plumbing.Hash) (bool, error) {
+	provider.storeMutex.Lock()
+	defer provider.storeMutex.Unlock()
+
+	current := provider.commit
+	currentHash := blobHash
+	var touching *object.Commit
+	for depth := 0; depth < provider.opts.GeneratedHistoryLimit; depth++ {
+		var parent *object.Commit
+		parentHash := plumbing.ZeroHash
+		if current.NumParents() > 0 {
+			var err error
+			parent, err = current.Parent(0)
+			if err != nil {
+				return false, err
+			}
+			parentHash, err = blobHashAt(parent, filePath)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		if currentHash != parentHash {
+			if touching != nil {
+				return false, nil
+			}
+			author := current.Author.String()
+			if !provider.generatedAuthorPattern.MatchString(author) {
+				return false, nil
+			}
+			touching = current
+		}
+
+		if parentHash.IsZero() {
+			provider.verboseLog("'%v' was only committed by '%v' at %v", filePath, touching.Author.String(), touching.Hash)
+			return true, nil
+		}
+		current, currentHash = parent, parentHash
+	}
+
+	provider.verboseLog("history of '%v' is longer than %v commits, assuming it is not generated", filePath, provider.opts.GeneratedHistoryLimit)
+	return false, nil
+}
@@ -0,0 +1,45 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotSkippingSingleAuthorGenerated(t *testing.T) {
+	bot := "dependabot[bot] <dependabot[bot]@users.noreply.github.com>"
+	human := "human <human@example.com>"
+	clonePath, _ := createLocalRepo(map[string]string{
+		"human.txt": "human",
+	})
+	defer os.RemoveAll(clonePath)
+	commitFiles(clonePath, map[string]string{"generated.txt": "generated", "edited.txt": "generated"}, bot)
+	commitFiles(clonePath, map[string]string{"edited.txt": "edited"}, human)
+	commitFiles(clonePath, map[string]string{"human.txt": "human again"}, human)
+	revision := commitFiles(clonePath, map[string]string{"regenerated.txt": "v1"}, bot)
+	revision = commitFiles(clonePath, map[string]string{"regenerated.txt": "v2"}, bot)
+
+	outputPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(outputPath)
+
+	err = Snapshot(&options.Options{
+		ClonePath:                 clonePath,
+		Revision:                  revision,
+		OutputPath:                outputPath,
+		IncludePatterns:           []string{},
+		ExcludePatterns:           []string{},
+		SkipSingleAuthorGenerated: true,
+		GeneratedAuthorPattern:    `(?i)\[bot\]|\bbot\b`,
+		GeneratedHistoryLimit:     100,
+	})
+	require.Nil(t, err)
+
+	require.NoFileExists(t, filepath.Join(outputPath, "generated.txt"))
+	require.FileExists(t, filepath.Join(outputPath, "edited.txt"))
+	require.FileExists(t, filepath.Join(outputPath, "human.txt"))
+	require.FileExists(t, filepath.Join(outputPath, "regenerated.txt"))
+}
@@ -0,0 +1,73 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+)
+
+const (
+	INCOMPLETE_MARKER_FILE_NAME = ".incomplete"
+	// tells the marker apart from a file of the snapshot with the same name
+	INCOMPLETE_MARKER_HEADER = "git-snap: incomplete snapshot"
+)
+
+// writesOutputDirectory checks whether the run writes files to the --out directory
+func (provider *repositoryProvider) writesOutputDirectory() bool {
+	opts := provider.opts
+	return opts.OutputPath != "" && !isArchiveFormat(opts.Format) && opts.StatsPath == "" && opts.CompareToDir == "" &&
+		opts.ManifestPath == "" && !opts.IndexOnly && !opts.DryRun && !opts.Estimate
+}
+
+func isEmptyDirectory(directoryPath string) bool {
+	entries, err := os.ReadDir(directoryPath)
+	if os.IsNotExist(err) {
+		return true
+	}
+	return err == nil && len(entries) == 0
+}
+
+// cleanUpPartialOutput handles the output of a canceled snapshot. unless --keep-partial is set, an output path
+// which was empty before is emptied again. otherwise its files may belong to a previous snapshot, so they are kept,
+// along with a marker telling the snapshot is incomplete.
+func (provider *repositoryProvider) cleanUpPartialOutput(outputWasEmpty bool, cause error) {
+	outputPath := provider.opts.OutputPath
+	if !provider.opts.KeepPartial && outputWasEmpty {
+		entries, err := os.ReadDir(outputPath)
+		for _, entry := range entries {
+			if err == nil {
+				err = os.RemoveAll(filepath.Join(outputPath, entry.Name()))
+			}
+		}
+		if err == nil {
+			provider.logger.Infof("removed the partial output of the canceled snapshot from '%v'", outputPath)
+			return
+		}
+		provider.logger.Infof("failed to remove the partial output of the canceled snapshot from '%v': %v", outputPath, err)
+	}
+
+	markerPath := filepath.Join(outputPath, INCOMPLETE_MARKER_FILE_NAME)
+	contents := fmt.Sprintf("%v of revision '%v' at clone '%v': %v\n", INCOMPLETE_MARKER_HEADER, provider.opts.Revision, provider.opts.ClonePath, cause)
+	err := os.WriteFile(markerPath, []byte(contents), provider.targetFileMode(filemode.Regular))
+	if err != nil {
+		provider.logger.Infof("failed to mark the partial output at '%v' as incomplete: %v", outputPath, err)
+		return
+	}
+	provider.logger.Infof("kept the partial output of the canceled snapshot at '%v', marked by '%v'", outputPath, markerPath)
+}
+
+// removeIncompleteMarker removes the marker left by a canceled snapshot once a snapshot to the same output path completed
+func (provider *repositoryProvider) removeIncompleteMarker() {
+	markerPath := filepath.Join(provider.opts.OutputPath, INCOMPLETE_MARKER_FILE_NAME)
+	contents, err := os.ReadFile(markerPath)
+	if err != nil || !bytes.HasPrefix(contents, []byte(INCOMPLETE_MARKER_HEADER)) {
+		return
+	}
+	err = os.Remove(markerPath)
+	if err != nil {
+		provider.logger.Infof("failed to remove '%v': %v", markerPath, err)
+	}
+}
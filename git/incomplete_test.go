@@ -0,0 +1,84 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"gitsnap/options"
+	"gitsnap/util"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotCleansUpPartialOutput(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("dir%v/file%v.txt", i%5, i)] = fmt.Sprintf("file %v", i)
+	}
+	clonePath, revision := createLocalRepo(files)
+	defer os.RemoveAll(clonePath)
+	snapshot := func(outputPath string, keepPartial bool, cancel bool) error {
+		ctx, cancelCtx := context.WithCancel(context.Background())
+		defer cancelCtx()
+		var logger options.Logger
+		if cancel {
+			logger = &cancelingLogger{cancel: cancelCtx}
+		}
+		return SnapshotContext(ctx, &options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			Workers:         1,
+			VerboseLogging:  true,
+			Logger:          logger,
+			KeepPartial:     keepPartial,
+			Resume:          true,
+		})
+	}
+	requireCanceled := func(err error) {
+		var errorWithCode *util.ErrorWithCode
+		require.ErrorAs(t, err, &errorWithCode)
+		require.Equal(t, util.ERROR_CANCELED, errorWithCode.StatusCode)
+	}
+	markerPath := func(outputPath string) string {
+		return filepath.Join(outputPath, INCOMPLETE_MARKER_FILE_NAME)
+	}
+
+	// the output path was empty, so nothing is left of the canceled snapshot
+	outputPath := t.TempDir()
+	requireCanceled(snapshot(outputPath, false, true))
+	entries, err := os.ReadDir(outputPath)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+
+	// kept and marked, until a resumed snapshot completes
+	requireCanceled(snapshot(outputPath, true, true))
+	contents, err := os.ReadFile(markerPath(outputPath))
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(string(contents), INCOMPLETE_MARKER_HEADER))
+	entries, err = os.ReadDir(outputPath)
+	require.Nil(t, err)
+	require.Greater(t, len(entries), 1)
+	require.Nil(t, snapshot(outputPath, true, false))
+	require.NoFileExists(t, markerPath(outputPath))
+	requireFileContents(t, filepath.Join(outputPath, "dir0", "file0.txt"), "file 0")
+
+	// the files of a previous snapshot are not removed
+	outputPath = t.TempDir()
+	writeFiles(outputPath, map[string]string{"previous.txt": "previous"})
+	requireCanceled(snapshot(outputPath, false, true))
+	requireFileContents(t, filepath.Join(outputPath, "previous.txt"), "previous")
+	require.FileExists(t, markerPath(outputPath))
+
+	// a file of the snapshot with the name of the marker is not removed
+	clonePath, revision = createLocalRepo(map[string]string{INCOMPLETE_MARKER_FILE_NAME: "tracked"})
+	defer os.RemoveAll(clonePath)
+	outputPath = t.TempDir()
+	require.Nil(t, snapshot(outputPath, false, false))
+	requireFileContents(t, markerPath(outputPath), "tracked")
+}
@@ -0,0 +1,162 @@
+package git
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gitsnap/options"
+	"io"
+	"strconv"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// indexField is a column of an index entry. its value is a string, bool, int or int64, or nil when unknown.
+type indexField struct {
+	header string
+	value  any
+}
+
+// indexWriter writes the index file in its --index-format. writers are safe for concurrent use, and buffer
+// the entries until flush, so the entries of concurrent writers don't interleave and are not flushed one by one.
+type indexWriter interface {
+	write(fields []indexField) error
+	// flush writes the buffered entries, returning the first error of any write
+	flush() error
+}
+
+func (provider *repositoryProvider) indexHeaders() []string {
+	headers := []string{"Path", "BlobId", "IsFile"}
+	if provider.opts.IndexLinesOfCode {
+		headers = append(headers, "LinesOfCode")
+	}
+	if provider.opts.IndexExtended {
+		headers = append(headers, "Size", "Mode")
+	}
+	if provider.remapsTargetPaths() {
+		headers = append(headers, "TargetPath")
+	}
+	return headers
+}
+
+// indexFields returns the columns of a record, matching indexHeaders
+func (provider *repositoryProvider) indexFields(record *indexRecord) []indexField {
+	fields := []indexField{
+		{header: "Path", value: record.path},
+		{header: "BlobId", value: record.entry.Hash.String()},
+		{header: "IsFile", value: record.entry.Mode.IsFile()},
+	}
+	if provider.opts.IndexLinesOfCode {
+		var linesOfCode any
+		if record.linesOfCode >= 0 {
+			linesOfCode = record.linesOfCode
+		}
+		fields = append(fields, indexField{header: "LinesOfCode", value: linesOfCode})
+	}
+	if provider.opts.IndexExtended {
+		var size any
+		if record.entry.Mode.IsFile() {
+			size = record.size
+		}
+		fields = append(fields, indexField{header: "Size", value: size}, indexField{header: "Mode", value: record.entry.Mode.String()})
+	}
+	if provider.remapsTargetPaths() {
+		fields = append(fields, indexField{header: "TargetPath", value: record.targetPath})
+	}
+	return fields
+}
+
+func (provider *repositoryProvider) addEntryToIndexFile(indexFile indexWriter, record *indexRecord) error {
+	if indexFile != nil && utf8.ValidString(record.path) {
+		return indexFile.write(provider.indexFields(record))
+	}
+	return nil
+}
+
+func newIndexWriter(writer io.Writer, format string, headers []string) (indexWriter, error) {
+	switch format {
+	case options.INDEX_FORMAT_JSON:
+		return &jsonIndexWriter{writer: writer, entries: []map[string]any{}}, nil
+	case options.INDEX_FORMAT_CSV, options.INDEX_FORMAT_TSV, "":
+		csvWriter := csv.NewWriter(writer)
+		if format != options.INDEX_FORMAT_CSV {
+			csvWriter.Comma = '\t'
+		}
+		err := csvWriter.Write(headers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write headers: %v", err)
+		}
+		return &delimitedIndexWriter{writer: csvWriter}, nil
+	default:
+		return nil, fmt.Errorf("unsupported index format '%v'", format)
+	}
+}
+
+// delimitedIndexWriter writes a header line and a line per entry, separated by tabs or commas
+type delimitedIndexWriter struct {
+	mutex  sync.Mutex
+	writer *csv.Writer
+}
+
+func (index *delimitedIndexWriter) write(fields []indexField) error {
+	line := make([]string, len(fields))
+	for i, field := range fields {
+		switch value := field.value.(type) {
+		case string:
+			line[i] = value
+		case bool:
+			line[i] = strconv.FormatBool(value)
+		case int:
+			line[i] = strconv.Itoa(value)
+		case int64:
+			line[i] = strconv.FormatInt(value, 10)
+		case nil:
+			line[i] = ""
+		default:
+			line[i] = fmt.Sprint(value)
+		}
+	}
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	return index.writer.Write(line)
+}
+
+func (index *delimitedIndexWriter) flush() error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.writer.Flush()
+	return index.writer.Error()
+}
+
+// jsonIndexWriter writes an array of an object per entry, keyed by the headers starting in lower case
+type jsonIndexWriter struct {
+	mutex   sync.Mutex
+	writer  io.Writer
+	entries []map[string]any
+}
+
+func (index *jsonIndexWriter) write(fields []indexField) error {
+	entry := make(map[string]any, len(fields))
+	for _, field := range fields {
+		entry[jsonKey(field.header)] = field.value
+	}
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.entries = append(index.entries, entry)
+	return nil
+}
+
+func (index *jsonIndexWriter) flush() error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	encoder := json.NewEncoder(index.writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(index.entries)
+}
+
+// jsonKey turns a header such as BlobId into blobId
+func jsonKey(header string) string {
+	first, size := utf8.DecodeRuneInString(header)
+	return string(unicode.ToLower(first)) + header[size:]
+}
@@ -0,0 +1,180 @@
+package git
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexWriterConcurrently(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	index, err := newIndexWriter(buffer, options.INDEX_FORMAT_TSV, []string{"Path", "BlobId", "IsFile"})
+	require.Nil(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			require.Nil(t, index.write([]indexField{
+				{header: "Path", value: fmt.Sprintf("file%v.txt", i)},
+				{header: "BlobId", value: strings.Repeat("a", 40)},
+				{header: "IsFile", value: true},
+			}))
+		}(i)
+	}
+	wg.Wait()
+	require.Nil(t, index.flush())
+
+	reader := csv.NewReader(buffer)
+	reader.Comma = '\t'
+	lines, err := reader.ReadAll()
+	require.Nil(t, err)
+	require.Len(t, lines, 51)
+	require.Equal(t, []string{"Path", "BlobId", "IsFile"}, lines[0])
+}
+
+func TestSnapshotIndexWithWorkers(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 200; i++ {
+		files[fmt.Sprintf("dir%v/file%v.txt", i%10, i)] = fmt.Sprintf("contents %v", i)
+	}
+	files["skipped.bin"] = "binary"
+	clonePath, revision := createLocalRepo(files)
+	defer os.RemoveAll(clonePath)
+
+	outputPath := t.TempDir()
+	indexPath := filepath.Join(t.TempDir(), "index.csv")
+	err := Snapshot(&options.Options{
+		ClonePath:             clonePath,
+		Revision:              revision,
+		OutputPath:            outputPath,
+		OptionalIndexFilePath: indexPath,
+		IncludePatterns:       []string{},
+		ExcludePatterns:       []string{"**/*.bin"},
+		Workers:               8,
+	})
+	require.Nil(t, err)
+
+	var written []string
+	err = filepath.WalkDir(outputPath, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			relativePath, _ := filepath.Rel(outputPath, path)
+			written = append(written, filepath.ToSlash(relativePath))
+		}
+		return err
+	})
+	require.Nil(t, err)
+	require.Len(t, written, 200)
+
+	file, err := os.Open(indexPath)
+	require.Nil(t, err)
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comma = '\t'
+	lines, err := reader.ReadAll()
+	require.Nil(t, err)
+	var indexed []string
+	for _, line := range lines[1:] {
+		// directories are listed as well
+		if line[2] == "true" {
+			indexed = append(indexed, line[0])
+		}
+	}
+	require.ElementsMatch(t, written, indexed)
+}
+
+func TestSnapshotWithIndexFormat(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"main.go":    "package main\n\nfunc main() {}\n",
+		"data/a.txt": "a, \"quoted\"",
+	})
+	defer os.RemoveAll(clonePath)
+	blobId := runGit(clonePath, "rev-parse", revision+":main.go")
+
+	snapshotIndex := func(format string) []byte {
+		indexPath := filepath.Join(t.TempDir(), "index")
+		err := Snapshot(&options.Options{
+			ClonePath:             clonePath,
+			Revision:              revision,
+			OutputPath:            t.TempDir(),
+			OptionalIndexFilePath: indexPath,
+			IndexLinesOfCode:      true,
+			IndexFormat:           format,
+			IncludePatterns:       []string{},
+			ExcludePatterns:       []string{},
+		})
+		require.Nil(t, err)
+		contents, err := os.ReadFile(indexPath)
+		require.Nil(t, err)
+		return contents
+	}
+
+	for format, comma := range map[string]rune{options.INDEX_FORMAT_TSV: '\t', options.INDEX_FORMAT_CSV: ','} {
+		reader := csv.NewReader(bytes.NewReader(snapshotIndex(format)))
+		reader.Comma = comma
+		lines, err := reader.ReadAll()
+		require.Nil(t, err, format)
+		require.Equal(t, []string{"Path", "BlobId", "IsFile", "LinesOfCode"}, lines[0], format)
+		require.Contains(t, lines, []string{"main.go", blobId, "true", "2"}, format)
+		require.Contains(t, lines, []string{"data", runGit(clonePath, "rev-parse", revision+":data"), "false", ""}, format)
+		require.Contains(t, lines, []string{"data/a.txt", runGit(clonePath, "rev-parse", revision+":data/a.txt"), "true", ""}, format)
+	}
+
+	var entries []map[string]any
+	require.Nil(t, json.Unmarshal(snapshotIndex(options.INDEX_FORMAT_JSON), &entries))
+	require.Len(t, entries, 3)
+	require.Contains(t, entries, map[string]any{"path": "main.go", "blobId": blobId, "isFile": true, "linesOfCode": float64(2)})
+	require.Contains(t, entries, map[string]any{"path": "data", "blobId": runGit(clonePath, "rev-parse", revision+":data"), "isFile": false, "linesOfCode": nil})
+}
+
+func TestSnapshotWithIndexExtended(t *testing.T) {
+	clonePath, _ := createLocalRepo(map[string]string{
+		"main.go":       "package main\n",
+		"bin/run.sh":    "#!/bin/sh\necho run\n",
+		"docs/empty.md": "",
+	})
+	defer os.RemoveAll(clonePath)
+	runGit(clonePath, "update-index", "--chmod=+x", "bin/run.sh")
+	runGit(clonePath, "commit", "-q", "-m", "executable")
+	revision := runGit(clonePath, "rev-parse", "HEAD")
+
+	indexPath := filepath.Join(t.TempDir(), "index.tsv")
+	err := Snapshot(&options.Options{
+		ClonePath:             clonePath,
+		Revision:              revision,
+		OutputPath:            t.TempDir(),
+		OptionalIndexFilePath: indexPath,
+		IndexExtended:         true,
+		IncludePatterns:       []string{},
+		ExcludePatterns:       []string{},
+	})
+	require.Nil(t, err)
+
+	file, err := os.Open(indexPath)
+	require.Nil(t, err)
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comma = '\t'
+	lines, err := reader.ReadAll()
+	require.Nil(t, err)
+	require.Equal(t, []string{"Path", "BlobId", "IsFile", "Size", "Mode"}, lines[0])
+	blobId := func(path string) string {
+		return runGit(clonePath, "rev-parse", revision+":"+path)
+	}
+	require.ElementsMatch(t, [][]string{
+		{"bin", blobId("bin"), "false", "", "0040000"},
+		{"bin/run.sh", blobId("bin/run.sh"), "true", "19", "0100755"},
+		{"docs", blobId("docs"), "false", "", "0040000"},
+		{"docs/empty.md", blobId("docs/empty.md"), "true", "0", "0100644"},
+		{"main.go", blobId("main.go"), "true", "13", "0100644"},
+	}, lines[1:])
+}
@@ -0,0 +1,140 @@
+package git
+
+import (
+	"fmt"
+	"gitsnap/parallel"
+	"path"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// firstParentHistory returns the commits of the first-parent history from the commit, newest first,
+// for as long as they are accepted by keep with their depth
+func (provider *repositoryProvider) firstParentHistory(commit *object.Commit, keep func(commit *object.Commit, depth int) bool) ([]plumbing.Hash, error) {
+	provider.storeMutex.Lock()
+	defer provider.storeMutex.Unlock()
+
+	history := []plumbing.Hash{}
+	current := commit
+	for depth := 0; keep(current, depth); depth++ {
+		history = append(history, current.Hash)
+		if current.NumParents() == 0 {
+			break
+		}
+		var err error
+		current, err = current.Parent(0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk history of '%v': %v", commit.Hash, err)
+		}
+	}
+	return history, nil
+}
+
+// loadLastChanges finds the last commit touching each path changed in the history, which is ordered newest first,
+// by diffing every commit with its first parent. the diffs run in parallel on the given number of workers, each
+// opening the clone on its own as the object storage can't be shared between goroutines.
+func (provider *repositoryProvider) loadLastChanges(history []plumbing.Hash, workers int) (map[string]*object.Commit, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(history) {
+		workers = len(history)
+	}
+	repositories := make(chan *git.Repository, workers)
+	for i := 0; i < workers; i++ {
+		repository, err := openClone(provider.opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open clone at '%v': %v", provider.opts.ClonePath, err)
+		}
+		repositories <- repository
+	}
+
+	lastChanges := map[string]*object.Commit{}
+	// position in the history of the commit of each path, the lowest one is the last change
+	depths := map[string]int{}
+	var lastChangesMutex sync.Mutex
+	queue := parallel.NewJobQueue(workers)
+	for depth, hash := range history {
+		depth, hash := depth, hash
+		err := queue.Submit(func() error {
+			if ctxErr := provider.ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			repository := <-repositories
+			defer func() { repositories <- repository }()
+			changed, changeCommit, err := changedPathsOf(repository, hash)
+			if err != nil {
+				return fmt.Errorf("failed to diff commit '%v' with its parent: %v", hash, err)
+			}
+			lastChangesMutex.Lock()
+			defer lastChangesMutex.Unlock()
+			for _, changedPath := range changed {
+				knownDepth, found := depths[changedPath]
+				if !found || depth < knownDepth {
+					lastChanges[changedPath] = changeCommit
+					depths[changedPath] = depth
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			break
+		}
+	}
+	err := queue.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return lastChanges, nil
+}
+
+// changedPathsOf returns the paths of the files a commit added, modified or deleted since its first parent
+func changedPathsOf(repository *git.Repository, hash plumbing.Hash) ([]string, *object.Commit, error) {
+	commit, err := repository.CommitObject(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	var parentTree *object.Tree
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, nil, err
+		}
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return nil, nil, err
+	}
+	changed := make([]string, 0, len(changes))
+	for _, change := range changes {
+		if change.To.Name != "" {
+			changed = append(changed, change.To.Name)
+		} else {
+			changed = append(changed, change.From.Name)
+		}
+	}
+	return changed, commit, nil
+}
+
+// lastChangeOf looks up the last change of a file of the snapshot by its repository path. files of submodules
+// are attributed to the last change of their gitlink.
+func (provider *repositoryProvider) lastChangeOf(lastChanges map[string]*object.Commit, filePath string) *object.Commit {
+	for repositoryPath := provider.repositoryPath(filePath); repositoryPath != "." && repositoryPath != "/"; repositoryPath = path.Dir(repositoryPath) {
+		if lastCommit, found := lastChanges[repositoryPath]; found {
+			return lastCommit
+		}
+	}
+	return nil
+}
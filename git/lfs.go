@@ -0,0 +1,95 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// pointers are small text files, larger blobs are never checked
+	LFS_POINTER_MAX_SIZE = 1024
+	LFS_OID_PREFIX       = "sha256:"
+)
+
+var (
+	lfsPointerVersions = []string{"version https://git-lfs.github.com/spec/v1", "version https://hawser.github.com/spec/v1"}
+	lfsOidPattern      = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+type lfsPointer struct {
+	oid  string
+	size int64
+}
+
+// parseLFSPointer returns nil if the contents are not a git LFS pointer file
+func parseLFSPointer(contents []byte) *lfsPointer {
+	if len(contents) > LFS_POINTER_MAX_SIZE {
+		return nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	if !scanner.Scan() || !isLFSPointerVersion(scanner.Text()) {
+		return nil
+	}
+
+	pointer := &lfsPointer{size: -1}
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), " ")
+		if !found {
+			return nil
+		}
+		switch key {
+		case "oid":
+			pointer.oid = strings.TrimPrefix(value, LFS_OID_PREFIX)
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil
+			}
+			pointer.size = size
+		}
+	}
+	if !lfsOidPattern.MatchString(pointer.oid) || pointer.size < 0 {
+		return nil
+	}
+	return pointer
+}
+
+func isLFSPointerVersion(line string) bool {
+	for _, version := range lfsPointerVersions {
+		if line == version {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveLFS replaces the contents of an LFS pointer with the object from the local LFS cache of the clone.
+// a nil result means the object is missing and the file should be skipped.
+func (provider *repositoryProvider) resolveLFS(filePath string, contents []byte) ([]byte, error) {
+	pointer := parseLFSPointer(contents)
+	if pointer == nil {
+		return contents, nil
+	}
+
+	objectPath := filepath.Join(provider.commonGitDir(), "lfs", "objects", pointer.oid[0:2], pointer.oid[2:4], pointer.oid)
+	object, err := os.ReadFile(objectPath)
+	if errors.Is(err, os.ErrNotExist) {
+		provider.logger.Infof("--- skipping '%v' - LFS object %v is missing from the local LFS cache (run git lfs fetch?)", filePath, pointer.oid)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS object of '%v' at '%v': %v", filePath, objectPath, err)
+	}
+	if int64(len(object)) != pointer.size {
+		return nil, fmt.Errorf("LFS object of '%v' at '%v' has %v bytes, but its pointer expects %v", filePath, objectPath, len(object), pointer.size)
+	}
+	provider.verboseLog("*** resolved LFS object %v of '%v'", pointer.oid, filePath)
+	return object, nil
+}
@@ -0,0 +1,68 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func lfsPointerOf(contents string) (string, string) {
+	hash := sha256.Sum256([]byte(contents))
+	oid := hex.EncodeToString(hash[:])
+	return oid, fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%v\nsize %v\n", oid, len(contents))
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	oid, pointer := lfsPointerOf("asset")
+	require.Equal(t, &lfsPointer{oid: oid, size: 5}, parseLFSPointer([]byte(pointer)))
+
+	require.Nil(t, parseLFSPointer([]byte("plain text")))
+	require.Nil(t, parseLFSPointer([]byte("version https://git-lfs.github.com/spec/v1\noid sha256:short\nsize 5\n")))
+	require.Nil(t, parseLFSPointer([]byte("version https://git-lfs.github.com/spec/v1\noid sha256:"+oid+"\n")))
+}
+
+func TestSnapshotWithResolveLFS(t *testing.T) {
+	asset := "binary asset contents"
+	assetOid, assetPointer := lfsPointerOf(asset)
+	_, missingPointer := lfsPointerOf("never fetched")
+	clonePath, revision := createLocalRepo(map[string]string{
+		"asset.bin":   assetPointer,
+		"missing.bin": missingPointer,
+		"plain.txt":   "plain",
+	})
+	defer os.RemoveAll(clonePath)
+	objectPath := filepath.Join(clonePath, ".git", "lfs", "objects", assetOid[0:2], assetOid[2:4], assetOid)
+	require.Nil(t, os.MkdirAll(filepath.Dir(objectPath), 0755))
+	require.Nil(t, os.WriteFile(objectPath, []byte(asset), 0644))
+
+	for _, resolveLFS := range []bool{false, true} {
+		outputPath := t.TempDir()
+		result, err := SnapshotWithResult(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			ResolveLFS:      resolveLFS,
+		})
+		require.Nil(t, err)
+
+		contents, err := os.ReadFile(filepath.Join(outputPath, "asset.bin"))
+		require.Nil(t, err)
+		require.FileExists(t, filepath.Join(outputPath, "plain.txt"))
+		if !resolveLFS {
+			require.Equal(t, assetPointer, string(contents))
+			require.FileExists(t, filepath.Join(outputPath, "missing.bin"))
+			continue
+		}
+		require.Equal(t, asset, string(contents))
+		require.NoFileExists(t, filepath.Join(outputPath, "missing.bin"))
+		require.Equal(t, int64(len(asset)+len("plain")), result.BytesWritten)
+	}
+}
@@ -0,0 +1,83 @@
+package git
+
+import (
+	"fmt"
+	"gitsnap/options"
+	"gitsnap/util"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// like the limits of most linux file systems
+const (
+	MAX_FILE_NAME_LENGTH = 255
+	MAX_PATH_LENGTH      = 4095
+)
+
+func isLongPath(filePath string) bool {
+	return len(filepath.Base(filePath)) > MAX_FILE_NAME_LENGTH || len(filePath) > MAX_PATH_LENGTH
+}
+
+// skipsLongPaths checks whether --on-long-path is skip, the default
+func (provider *repositoryProvider) skipsLongPaths() bool {
+	switch provider.opts.OnLongPath {
+	case options.ON_LONG_PATH_FAIL, options.ON_LONG_PATH_TRUNCATE:
+		return false
+	}
+	return true
+}
+
+// skipsLongPath checks whether the file is skipped for its path length. flattened and sharded names are always short.
+func (provider *repositoryProvider) skipsLongPath(filePath string) bool {
+	return provider.skipsLongPaths() && !provider.remapsTargetPaths() && isLongPath(filePath)
+}
+
+func longPathError(filePath string, err error) error {
+	return &util.ErrorWithCode{
+		StatusCode:    util.ERROR_PATH_TOO_LONG,
+		InternalError: fmt.Errorf("failed to write '%v' - file name is too long: %v", filePath, err),
+	}
+}
+
+// shortenTargetPath applies --on-long-path fail or truncate to the target path of a file before it is written
+func (provider *repositoryProvider) shortenTargetPath(filePath string, targetFilePath string, hash plumbing.Hash) (string, error) {
+	if provider.remapsTargetPaths() || !isLongPath(filePath) {
+		return targetFilePath, nil
+	}
+	if provider.opts.OnLongPath != options.ON_LONG_PATH_TRUNCATE {
+		return "", longPathError(filePath, fmt.Errorf("longer than %v bytes, or its name than %v bytes", MAX_PATH_LENGTH, MAX_FILE_NAME_LENGTH))
+	}
+
+	directoryPath, name := filepath.Split(targetFilePath)
+	truncatedPath := directoryPath + truncateFileName(name, hash)
+	if len(filePath)-len(targetFilePath)+len(truncatedPath) > MAX_PATH_LENGTH {
+		return "", longPathError(filePath, fmt.Errorf("longer than %v bytes even with a truncated name", MAX_PATH_LENGTH))
+	}
+	if truncatedPath != targetFilePath {
+		provider.verboseLog("*** truncating '%v' to '%v' - file name is too long", filePath, truncatedPath)
+	}
+	return truncatedPath, nil
+}
+
+// truncateFileName shortens a file name over the file system limit, keeping its extension. the short blob id is
+// added so that names sharing their beginning remain unique, unless they hold the same contents.
+func truncateFileName(name string, hash plumbing.Hash) string {
+	if len(name) <= MAX_FILE_NAME_LENGTH {
+		return name
+	}
+	suffix := "_" + hash.String()[:SHORT_HASH_LENGTH]
+	extension := filepath.Ext(name)
+	// an extension taking most of the name is not worth keeping
+	if len(extension) > MAX_FILE_NAME_LENGTH/2 {
+		extension = ""
+	}
+	head := strings.TrimSuffix(name, extension)[:MAX_FILE_NAME_LENGTH-len(suffix)-len(extension)]
+	// a rune cut at the end is dropped
+	for len(head) > 0 && !utf8.ValidString(head) {
+		head = head[:len(head)-1]
+	}
+	return head + suffix + extension
+}
@@ -0,0 +1,92 @@
+package git
+
+import (
+	"errors"
+	"gitsnap/options"
+	"gitsnap/util"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateFileName(t *testing.T) {
+	hash := plumbing.NewHash("0123456789abcdef0123456789abcdef01234567")
+	require.Equal(t, "short.txt", truncateFileName("short.txt", hash))
+
+	name := truncateFileName(strings.Repeat("n", 300)+".txt", hash)
+	require.Len(t, name, MAX_FILE_NAME_LENGTH)
+	require.True(t, strings.HasSuffix(name, "n_0123456.txt"))
+
+	// multi-byte characters are not cut
+	name = truncateFileName(strings.Repeat("é", 200)+".txt", hash)
+	require.LessOrEqual(t, len(name), MAX_FILE_NAME_LENGTH)
+	require.True(t, utf8.ValidString(name))
+	require.True(t, strings.HasSuffix(name, "é_0123456.txt"))
+
+	// an extension taking most of the name is not kept
+	name = truncateFileName("name."+strings.Repeat("x", 300), hash)
+	require.Len(t, name, MAX_FILE_NAME_LENGTH)
+	require.True(t, strings.HasSuffix(name, "x_0123456"))
+}
+
+// createRepoWithLongPath commits a file whose name is too long for the file system, through the index
+func createRepoWithLongPath(t *testing.T, longName string) (clonePath string, revision string) {
+	clonePath, _ = createLocalRepo(map[string]string{
+		"dir/short.txt": "short",
+	})
+	blobId := runCommandIn(clonePath, "bash", "-c", "printf long | git hash-object -w --stdin")
+	runGit(clonePath, "update-index", "--add", "--cacheinfo", "100644,"+blobId+",dir/"+longName)
+	runGit(clonePath, "commit", "-q", "-m", "long path")
+	return clonePath, runGit(clonePath, "rev-parse", "HEAD")
+}
+
+func TestSnapshotWithOnLongPath(t *testing.T) {
+	longName := strings.Repeat("n", 300) + ".txt"
+	clonePath, revision := createRepoWithLongPath(t, longName)
+	defer os.RemoveAll(clonePath)
+
+	snapshot := func(onLongPath string) (string, error) {
+		outputPath := t.TempDir()
+		return outputPath, Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			OnLongPath:      onLongPath,
+		})
+	}
+
+	outputPath, err := snapshot(options.ON_LONG_PATH_SKIP)
+	require.Nil(t, err)
+	requireFileContents(t, filepath.Join(outputPath, "dir", "short.txt"), "short")
+	entries, err := os.ReadDir(filepath.Join(outputPath, "dir"))
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+
+	_, err = snapshot(options.ON_LONG_PATH_FAIL)
+	var errorWithCode *util.ErrorWithCode
+	require.True(t, errors.As(err, &errorWithCode))
+	require.Equal(t, util.ERROR_PATH_TOO_LONG, errorWithCode.StatusCode)
+
+	outputPath, err = snapshot(options.ON_LONG_PATH_TRUNCATE)
+	require.Nil(t, err)
+	requireFileContents(t, filepath.Join(outputPath, "dir", "short.txt"), "short")
+	entries, err = os.ReadDir(filepath.Join(outputPath, "dir"))
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		if entry.Name() == "short.txt" {
+			continue
+		}
+		require.Len(t, entry.Name(), MAX_FILE_NAME_LENGTH)
+		require.True(t, strings.HasPrefix(entry.Name(), "nnn"))
+		require.Regexp(t, `_[0-9a-f]{7}\.txt$`, entry.Name())
+		requireFileContents(t, filepath.Join(outputPath, "dir", entry.Name()), "long")
+	}
+}
@@ -0,0 +1,188 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gitsnap/stats"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type ManifestEntry struct {
+	Path         string `json:"path"`
+	BlobId       string `json:"blobId"`
+	Sha256       string `json:"sha256"`
+	SizeBytes    int64  `json:"sizeBytes"`
+	Mode         string `json:"mode"`
+	Language     string `json:"language,omitempty"`
+	LinesOfCode  *int   `json:"linesOfCode,omitempty"`
+	CommentLines *int   `json:"commentLines,omitempty"`
+	BlankLines   *int   `json:"blankLines,omitempty"`
+	// the last change of the file, with --manifest-blame
+	LastCommit  string `json:"lastCommit,omitempty"`
+	AuthorEmail string `json:"authorEmail,omitempty"`
+	AuthorDate  string `json:"authorDate,omitempty"`
+	// the first path of the same blob, with --dedup
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+}
+
+// ManifestRename is a file renamed since the base revision, listed under its new path in the files
+type ManifestRename struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Renamed bool   `json:"renamed"`
+}
+
+type Manifest struct {
+	Commit string           `json:"commit"`
+	Files  []*ManifestEntry `json:"files"`
+	// Deleted lists the paths deleted since the base revision, when set
+	Deleted []string `json:"deleted,omitempty"`
+	// Renames lists the files renamed since the base revision, with --detect-renames
+	Renames []*ManifestRename `json:"renames,omitempty"`
+}
+
+func (provider *repositoryProvider) writeManifest(commit *object.Commit, manifestPath string) error {
+	manifest, err := provider.buildManifest(commit)
+	if err != nil {
+		return err
+	}
+
+	manifestFile, err := os.Create(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest file '%v': %v", manifestPath, err)
+	}
+	defer manifestFile.Close()
+
+	encoder := json.NewEncoder(manifestFile)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to write manifest file '%v': %v", manifestPath, err)
+	}
+
+	provider.logger.Infof("cataloged %v files to manifest '%v'", len(manifest.Files), manifestPath)
+	return nil
+}
+
+func (provider *repositoryProvider) buildManifest(commit *object.Commit) (*Manifest, error) {
+	tree, err := provider.getSnapshotTree(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastChanges map[string]*object.Commit
+	if provider.opts.ManifestBlame {
+		lastChanges, err = provider.loadBlame(commit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	manifest := &Manifest{
+		Commit:  commit.Hash.String(),
+		Files:   []*ManifestEntry{},
+		Deleted: provider.deletedPaths(),
+		Renames: provider.renames,
+	}
+
+	treeWalker := provider.newSnapshotWalker(commit, tree)
+	defer treeWalker.Close()
+
+	// the first path of every blob, for --dedup
+	blobPaths := map[plumbing.Hash]string{}
+	dedupedFiles, dedupedBytes := 0, int64(0)
+
+	for {
+		name, entry, walkErr := treeWalker.Next()
+		if walkErr == io.EOF {
+			if dedupedFiles > 0 {
+				provider.logger.Infof("%v files of the manifest duplicate others, of %v bytes", dedupedFiles, dedupedBytes)
+			}
+			return manifest, nil
+		}
+		if walkErr != nil {
+			return nil, fmt.Errorf("failed to iterate files of %v: %v", commit.Hash, walkErr)
+		}
+
+		if !provider.shouldInclude(name, entry.Mode) {
+			continue
+		}
+
+		blob, err := provider.getBlob(entry.Hash)
+		if err != nil {
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				provider.logger.Infof("--- skipping '%v' - blob %v is missing from the object store (partial clone?): %v", name, entry.Hash, err)
+				continue
+			}
+			return nil, fmt.Errorf("failed to get blob of '%v': %v", name, err)
+		}
+		file := object.NewFile(name, entry.Mode, blob)
+		if provider.exceedsLimits(name, file.Size) {
+			continue
+		}
+
+		contents, err := provider.readContents(file)
+		if err != nil {
+			return nil, err
+		}
+		contentsHash := sha256.Sum256(contents)
+
+		manifestEntry := &ManifestEntry{
+			Path:      name,
+			BlobId:    entry.Hash.String(),
+			Sha256:    hex.EncodeToString(contentsHash[:]),
+			SizeBytes: file.Size,
+			Mode:      entry.Mode.String(),
+		}
+		language, found := stats.GetLanguageFromExtension(filepath.Ext(name))
+		if found {
+			counts := countLinesOfCode(contents, language)
+			manifestEntry.Language = language
+			manifestEntry.LinesOfCode = &counts.code
+			manifestEntry.CommentLines = &counts.comment
+			manifestEntry.BlankLines = &counts.blank
+		}
+		if lastCommit := provider.lastChangeOf(lastChanges, name); lastCommit != nil {
+			manifestEntry.LastCommit = lastCommit.Hash.String()
+			manifestEntry.AuthorEmail = lastCommit.Author.Email
+			manifestEntry.AuthorDate = lastCommit.Author.When.Format(time.RFC3339)
+		}
+		if provider.opts.Dedup {
+			if firstPath, found := blobPaths[entry.Hash]; found {
+				manifestEntry.DuplicateOf = firstPath
+				dedupedFiles++
+				dedupedBytes += file.Size
+			} else {
+				blobPaths[entry.Hash] = name
+			}
+		}
+		provider.verboseLog("+++ '%v' to manifest", name)
+		manifest.Files = append(manifest.Files, manifestEntry)
+	}
+}
+
+// loadBlame finds the last commit touching each path in a single traversal of the first-parent history,
+// up to --blame-max-commits
+func (provider *repositoryProvider) loadBlame(commit *object.Commit) (map[string]*object.Commit, error) {
+	history, err := provider.firstParentHistory(commit, func(_ *object.Commit, depth int) bool {
+		return depth < provider.opts.BlameMaxCommits
+	})
+	if err != nil {
+		return nil, err
+	}
+	lastChanges, err := provider.loadLastChanges(history, provider.opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+	provider.verboseLog("%v paths changed by the last %v commits", len(lastChanges), len(history))
+	return lastChanges, nil
+}
@@ -0,0 +1,115 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotManifestOnly(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"src/A.java": "class A {\n// comment\n}\n",
+		"README.md":  "# readme\n",
+	})
+	defer os.RemoveAll(clonePath)
+	manifestDir, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(manifestDir)
+	manifestPath := filepath.Join(manifestDir, "manifest.json")
+
+	err = Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+		ManifestPath:    manifestPath,
+	})
+	require.Nil(t, err)
+
+	entries, err := os.ReadDir(manifestDir)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+
+	contents, err := os.ReadFile(manifestPath)
+	require.Nil(t, err)
+	manifest := &Manifest{}
+	require.Nil(t, json.Unmarshal(contents, manifest))
+	require.Equal(t, revision, manifest.Commit)
+	require.Len(t, manifest.Files, 2)
+
+	filesByPath := map[string]*ManifestEntry{}
+	for _, file := range manifest.Files {
+		filesByPath[file.Path] = file
+	}
+	java := filesByPath["src/A.java"]
+	require.NotNil(t, java)
+	expectedHash := sha256.Sum256([]byte("class A {\n// comment\n}\n"))
+	require.Equal(t, hex.EncodeToString(expectedHash[:]), java.Sha256)
+	require.Equal(t, runGit(clonePath, "rev-parse", "HEAD:src/A.java"), java.BlobId)
+	require.EqualValues(t, 23, java.SizeBytes)
+	require.Equal(t, "0100644", java.Mode)
+	require.Equal(t, "java", java.Language)
+	require.Equal(t, 2, *java.LinesOfCode)
+
+	readme := filesByPath["README.md"]
+	require.NotNil(t, readme)
+	require.Empty(t, readme.Language)
+	require.Nil(t, readme.LinesOfCode)
+}
+
+func TestManifestWithBlame(t *testing.T) {
+	clonePath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(clonePath)
+	runGit(clonePath, "init", "-q")
+	commitFilesAt(clonePath, map[string]string{
+		"old.txt":     "old",
+		"changed.txt": "before",
+	}, "2020-01-01T00:00:00Z")
+	first := runGit(clonePath, "rev-parse", "HEAD")
+	second := commitFiles(clonePath, map[string]string{
+		"changed.txt": "after",
+	}, "author <author@example.com>")
+
+	for blameMaxCommits, expected := range map[int]map[string]string{
+		options.DEFAULT_BLAME_MAX_COMMITS: {"old.txt": first, "changed.txt": second},
+		1:                                 {"old.txt": "", "changed.txt": second},
+	} {
+		manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+		err = Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        second,
+			ManifestPath:    manifestPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			ManifestBlame:   true,
+			BlameMaxCommits: blameMaxCommits,
+		})
+		require.Nil(t, err)
+
+		contents, err := os.ReadFile(manifestPath)
+		require.Nil(t, err)
+		manifest := &Manifest{}
+		require.Nil(t, json.Unmarshal(contents, manifest))
+		require.Len(t, manifest.Files, 2)
+		for _, file := range manifest.Files {
+			require.Equal(t, expected[file.Path], file.LastCommit, "last commit of '%v'", file.Path)
+			switch file.LastCommit {
+			case first:
+				require.Equal(t, "tester@example.com", file.AuthorEmail)
+				require.Equal(t, "2020-01-01T00:00:00Z", file.AuthorDate)
+			case second:
+				require.Equal(t, "author@example.com", file.AuthorEmail)
+			default:
+				require.Empty(t, file.AuthorEmail)
+				require.Empty(t, file.AuthorDate)
+			}
+		}
+	}
+}
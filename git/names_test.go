@@ -0,0 +1,69 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithNamePatterns(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"Dockerfile":                 "FROM scratch",
+		"services/api/Dockerfile":    "FROM golang",
+		"services/api/Dockerfile.go": "package api",
+		"yarn.lock":                  "lock",
+		"web/app/Gemfile.lock":       "lock",
+		"web/app/lock/main.go":       "package lock",
+		"src/main.go":                "package main",
+	})
+	defer os.RemoveAll(clonePath)
+
+	for _, test := range []struct {
+		args     []string
+		written  []string
+		excluded []string
+	}{
+		{
+			args:     []string{"--name-include", "Dockerfile"},
+			written:  []string{"Dockerfile", "services/api/Dockerfile"},
+			excluded: []string{"services/api/Dockerfile.go", "yarn.lock", "src/main.go"},
+		},
+		{
+			args:     []string{"--name-include", "*.lock,Dockerfile"},
+			written:  []string{"Dockerfile", "services/api/Dockerfile", "yarn.lock", "web/app/Gemfile.lock"},
+			excluded: []string{"services/api/Dockerfile.go", "web/app/lock/main.go", "src/main.go"},
+		},
+		{
+			// along with the path patterns
+			args:     []string{"--name-include", "*.lock", "--include", "web/**"},
+			written:  []string{"web/app/Gemfile.lock"},
+			excluded: []string{"yarn.lock", "web/app/lock/main.go"},
+		},
+		{
+			// even for paths matching the include patterns
+			args:     []string{"--name-exclude", "*.lock", "--include", "**/*.lock,**/*.go"},
+			written:  []string{"services/api/Dockerfile.go", "web/app/lock/main.go", "src/main.go"},
+			excluded: []string{"yarn.lock", "web/app/Gemfile.lock", "Dockerfile"},
+		},
+		{
+			args:     []string{"--name-include", "(?i)^dockerfile$", "--regex"},
+			written:  []string{"Dockerfile", "services/api/Dockerfile"},
+			excluded: []string{"services/api/Dockerfile.go", "yarn.lock"},
+		},
+	} {
+		outputPath := t.TempDir()
+		opts, err := options.ParseArgs(append([]string{"--src", clonePath, "--rev", revision, "--out", outputPath}, test.args...))
+		require.Nil(t, err)
+		require.Nil(t, Snapshot(opts))
+
+		for _, filePath := range test.written {
+			require.FileExists(t, filepath.Join(outputPath, filePath), "%v", test.args)
+		}
+		for _, filePath := range test.excluded {
+			require.NoFileExists(t, filepath.Join(outputPath, filePath), "%v", test.args)
+		}
+	}
+}
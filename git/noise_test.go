@@ -0,0 +1,66 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithNoiseDirs(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"src/main.go":              "package main",
+		"bin/run.sh":               "#!/bin/sh",
+		"node_modules/left/pad.js": "pad",
+		"web/coverage/index.html":  "<html/>",
+		"infra/.terraform/state":   "state",
+	})
+	defer os.RemoveAll(clonePath)
+
+	for _, test := range []struct {
+		args     []string
+		written  []string
+		excluded []string
+	}{
+		{
+			args:     []string{},
+			written:  []string{"src/main.go", "web/coverage/index.html", "infra/.terraform/state"},
+			excluded: []string{"bin/run.sh", "node_modules/left/pad.js"},
+		},
+		{
+			args:     []string{"--extra-noise-dirs", "coverage,.terraform"},
+			written:  []string{"src/main.go"},
+			excluded: []string{"bin/run.sh", "node_modules/left/pad.js", "web/coverage/index.html", "infra/.terraform/state"},
+		},
+		{
+			// the built-in bin is dropped
+			args:     []string{"--noise-dirs", "node_modules, coverage"},
+			written:  []string{"src/main.go", "bin/run.sh", "infra/.terraform/state"},
+			excluded: []string{"node_modules/left/pad.js", "web/coverage/index.html"},
+		},
+		{
+			args:     []string{"--noise-dirs", "coverage", "--regex"},
+			written:  []string{"src/main.go", "bin/run.sh", "node_modules/left/pad.js", "infra/.terraform/state"},
+			excluded: []string{"web/coverage/index.html"},
+		},
+		{
+			args:     []string{"--noise-dirs", "coverage", "--include-noise-dirs"},
+			written:  []string{"src/main.go", "bin/run.sh", "node_modules/left/pad.js", "web/coverage/index.html", "infra/.terraform/state"},
+			excluded: []string{},
+		},
+	} {
+		outputPath := t.TempDir()
+		opts, err := options.ParseArgs(append([]string{"--src", clonePath, "--rev", revision, "--out", outputPath}, test.args...))
+		require.Nil(t, err)
+		require.Nil(t, Snapshot(opts))
+
+		for _, filePath := range test.written {
+			require.FileExists(t, filepath.Join(outputPath, filePath), "%v", test.args)
+		}
+		for _, filePath := range test.excluded {
+			require.NoFileExists(t, filepath.Join(outputPath, filePath), "%v", test.args)
+		}
+	}
+}
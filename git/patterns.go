@@ -0,0 +1,136 @@
+package git
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	// characters with a meaning in globs, patterns without them are literal
+	GLOB_SPECIAL_CHARACTERS = "*?[]{}\\"
+	// a glob ending with it matches every path under the directories matched by the rest of it
+	DIRECTORY_GLOB_SUFFIX = "/**"
+)
+
+// literalPattern matches a single path, without evaluating a glob
+type literalPattern string
+
+func (pattern literalPattern) Match(filePath string) bool {
+	return filePath == string(pattern)
+}
+
+// prefixPattern matches the paths under a directory, like dir/**
+type prefixPattern string
+
+func (pattern prefixPattern) Match(filePath string) bool {
+	return strings.HasPrefix(filePath, string(pattern))
+}
+
+// containsPattern matches the paths under a directory name at any depth, like **/dir/**
+type containsPattern string
+
+func (pattern containsPattern) Match(filePath string) bool {
+	return strings.Contains(filePath, string(pattern))
+}
+
+func isLiteralGlob(pattern string) bool {
+	return !strings.ContainsAny(pattern, GLOB_SPECIAL_CHARACTERS)
+}
+
+// compileFastGlob returns a matcher of globs of the common shapes which needs no glob, or nil for other globs.
+// globs are compiled without separators, so ** and * match any sequence, including slashes.
+func compileFastGlob(pattern string) pathPattern {
+	if isLiteralGlob(pattern) {
+		return literalPattern(pattern)
+	}
+	directory, isDirectoryGlob := strings.CutSuffix(pattern, DIRECTORY_GLOB_SUFFIX)
+	if !isDirectoryGlob {
+		return nil
+	}
+	if isLiteralGlob(directory) {
+		return prefixPattern(directory + "/")
+	}
+	name, anyDepth := strings.CutPrefix(directory, "**/")
+	if anyDepth && isLiteralGlob(name) {
+		// no path starts with a slash, so a match is always preceded by a directory
+		return containsPattern("/" + name + "/")
+	}
+	return nil
+}
+
+// isDirectoryLevel checks whether the glob matches the paths under the directories it matches regardless of their
+// file names. it ends with /**, so the / before the file name is the last one it may need, and ** matches the name.
+func isDirectoryLevel(pattern string) bool {
+	return strings.HasSuffix(pattern, DIRECTORY_GLOB_SUFFIX)
+}
+
+// excludeMatcher evaluates exclude patterns in order like .gitignore does - the last matching pattern wins, so
+// a negated pattern re-includes paths excluded by an earlier pattern. the last directory-level pattern matching the
+// files of a directory is cached, so noisy directories such as **/node_modules/** are matched once per directory.
+type excludeMatcher struct {
+	patterns          []excludePattern
+	hasDirectoryLevel bool
+	mutex             sync.Mutex
+	directoryMatches  map[string]int
+}
+
+func newExcludeMatcher(patterns []excludePattern) *excludeMatcher {
+	matcher := &excludeMatcher{
+		patterns:         patterns,
+		directoryMatches: map[string]int{},
+	}
+	for _, pattern := range patterns {
+		matcher.hasDirectoryLevel = matcher.hasDirectoryLevel || pattern.directoryLevel
+	}
+	return matcher
+}
+
+func (matcher *excludeMatcher) isExcluded(filePath string) bool {
+	excluded, _ := matcher.lastMatch(filePath)
+	return excluded
+}
+
+// lastMatch returns whether the last pattern matching the file excludes it, and whether any pattern matches it
+func (matcher *excludeMatcher) lastMatch(filePath string) (excluded bool, matched bool) {
+	if matcher == nil {
+		return false, false
+	}
+	last := -1
+	if matcher.hasDirectoryLevel {
+		last = matcher.lastDirectoryMatch(filePath)
+	}
+	// a file-level pattern decides only if it comes after the last matching directory-level one
+	for i := len(matcher.patterns) - 1; i > last; i-- {
+		pattern := matcher.patterns[i]
+		if !pattern.directoryLevel && pattern.pattern.Match(filePath) {
+			return !pattern.negated, true
+		}
+	}
+	if last < 0 {
+		return false, false
+	}
+	return !matcher.patterns[last].negated, true
+}
+
+// lastDirectoryMatch returns the index of the last directory-level pattern matching the file, -1 when none does
+func (matcher *excludeMatcher) lastDirectoryMatch(filePath string) int {
+	directoryPath := path.Dir(filePath)
+	matcher.mutex.Lock()
+	last, found := matcher.directoryMatches[directoryPath]
+	matcher.mutex.Unlock()
+	if found {
+		return last
+	}
+
+	last = -1
+	for i, pattern := range matcher.patterns {
+		if pattern.directoryLevel && pattern.pattern.Match(filePath) {
+			last = i
+		}
+	}
+	matcher.mutex.Lock()
+	matcher.directoryMatches[directoryPath] = last
+	matcher.mutex.Unlock()
+	return last
+}
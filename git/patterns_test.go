@@ -0,0 +1,124 @@
+package git
+
+import (
+	"fmt"
+	"gitsnap/options"
+	"gitsnap/util"
+	"testing"
+
+	"github.com/gobwas/glob"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandPatternIfNeeded(t *testing.T) {
+	for _, test := range []struct {
+		pattern  string
+		expected []string
+	}{
+		{"*.go", []string{"*.go"}},
+		{"src/*.go", []string{"src/*.go"}},
+		{"*/foo", []string{"*/foo", "foo"}},
+		{"**/foo", []string{"**/foo", "foo"}},
+		{"**/foo/**", []string{"**/foo/**", "foo/**"}},
+		{"**/**/foo", []string{"**/**/foo", "**/foo", "foo"}},
+		{"*/**/foo", []string{"*/**/foo", "**/foo", "*/foo", "foo"}},
+		{"src/**/*.go", []string{"src/**/*.go", "src/*.go"}},
+		{"**/a/**/b/**/c", []string{"**/a/**/b/**/c", "a/**/b/**/c", "**/a/b/**/c", "**/a/**/b/c", "a/b/**/c", "a/**/b/c", "**/a/b/c", "a/b/c"}},
+	} {
+		require.Equal(t, test.expected, expandPatternIfNeeded(test.pattern), test.pattern)
+	}
+}
+
+func TestExpandedPatternsMatch(t *testing.T) {
+	provider := &repositoryProvider{opts: &options.Options{}}
+	for _, test := range []struct {
+		pattern    string
+		matches    []string
+		nonMatches []string
+	}{
+		{"**/foo/**", []string{"foo/a.go", "src/foo/a.go", "src/x/foo/y/a.go"}, []string{"xfoo/a.go", "foo.go", "src/foobar/a.go"}},
+		{"*/foo", []string{"foo", "a/foo", "a/b/foo"}, []string{"afoo", "foo/a"}},
+		{"**/**/foo.go", []string{"foo.go", "a/foo.go", "a/b/foo.go"}, []string{"afoo.go"}},
+		{"src/**/test/**/*.go", []string{"src/test/a.go", "src/x/test/a.go", "src/test/y/a.go", "src/x/test/y/z/a.go"}, []string{"test/a.go", "src/testing/a.go"}},
+	} {
+		compiled, err := provider.compileGlobs([]string{test.pattern}, "include")
+		require.Nil(t, err)
+		for _, filePath := range test.matches {
+			require.True(t, matches(filePath, compiled), "%v should match %v", test.pattern, filePath)
+		}
+		for _, filePath := range test.nonMatches {
+			require.False(t, matches(filePath, compiled), "%v should not match %v", test.pattern, filePath)
+		}
+	}
+}
+
+func TestFastGlobsMatchLikeGlobs(t *testing.T) {
+	filePaths := []string{"foo", "foo/a.go", "src/foo/a.go", "src/x/foo/y/a.go", "xfoo/a.go", "foo.go", "src/foobar/a.go", "src/foo", "/foo/a.go"}
+	for _, pattern := range []string{"foo", "src/foo", "foo/**", "src/foo/**", "**/foo/**", "**/src/foo/**"} {
+		fastPattern := compileFastGlob(pattern)
+		require.NotNil(t, fastPattern, pattern)
+		globPattern := glob.MustCompile(pattern)
+		for _, filePath := range filePaths {
+			require.Equal(t, globPattern.Match(filePath), fastPattern.Match(filePath), "%v on %v", pattern, filePath)
+		}
+	}
+	for _, pattern := range []string{"*.go", "**/foo", "src/*/foo/**", "**/f?o/**", "**/{a,b}/**", "**/foo/**/*.go"} {
+		require.Nil(t, compileFastGlob(pattern), pattern)
+	}
+}
+
+// uncachedExcludeMatcher returns a matcher of the same patterns, which evaluates all of them for every path
+func uncachedExcludeMatcher(matcher *excludeMatcher) *excludeMatcher {
+	patterns := make([]excludePattern, len(matcher.patterns))
+	for i, pattern := range matcher.patterns {
+		pattern.directoryLevel = false
+		patterns[i] = pattern
+	}
+	return newExcludeMatcher(patterns)
+}
+
+// syntheticPaths returns the paths of a tree with the files spread across nested and noisy directories
+func syntheticPaths(count int) []string {
+	noiseDirectories := util.NoiseDirectories()
+	filePaths := make([]string, count)
+	for i := range filePaths {
+		switch i % 4 {
+		case 0:
+			filePaths[i] = fmt.Sprintf("src/module%v/file%v.go", i%50, i)
+		case 1:
+			filePaths[i] = fmt.Sprintf("web/app%v/%v/lib%v/index%v.js", i%20, noiseDirectories[i%len(noiseDirectories)], i%30, i)
+		case 2:
+			filePaths[i] = fmt.Sprintf("gen%v/api/v%v/file%v.go", i%200, i%3, i)
+		default:
+			filePaths[i] = fmt.Sprintf("file%v.txt", i)
+		}
+	}
+	return filePaths
+}
+
+func TestCachedExcludesMatchLikeUncached(t *testing.T) {
+	for _, opts := range []*options.Options{{}, {Regex: true}} {
+		provider := &repositoryProvider{opts: opts}
+		patterns := append(util.NoisyDirectoryExclusionPatterns(), "**/gen1*/**", "!**/gen12/**/*.go", "gen12/api/v1/**", "*.txt", "!file3*.txt")
+		if opts.Regex {
+			patterns = append(util.NoisyDirectoryExclusionRegexPatterns(), `^gen1`, `!^gen12/.*\.go$`, `^gen12/api/v1/`, `\.txt$`, `!^file3.*\.txt$`)
+		}
+		matcher, err := provider.compileExcludePatterns(patterns)
+		require.Nil(t, err)
+		uncached := uncachedExcludeMatcher(matcher)
+
+		excluded := 0
+		for _, filePath := range append(syntheticPaths(4000), syntheticPaths(4000)...) {
+			require.Equal(t, uncached.isExcluded(filePath), matcher.isExcluded(filePath), filePath)
+			if matcher.isExcluded(filePath) {
+				excluded++
+			}
+		}
+		require.Greater(t, excluded, 0)
+		require.Less(t, excluded, 8000)
+	}
+
+	var nilMatcher *excludeMatcher
+	require.False(t, nilMatcher.isExcluded("a.go"))
+}
@@ -0,0 +1,81 @@
+package git
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+type progressEvent struct {
+	Processed int64 `json:"processed"`
+	Total     int   `json:"total"`
+	Bytes     int64 `json:"bytes"`
+	Done      bool  `json:"done,omitempty"`
+}
+
+// progressReporter periodically writes the progress of the writing pass as JSON lines.
+// a nil reporter reports nothing.
+type progressReporter struct {
+	output    io.Writer
+	total     int
+	processed atomic.Int64
+	bytes     atomic.Int64
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// startProgress reports every interval until finish is called. total is the number of tree entries to process.
+func startProgress(output io.Writer, interval time.Duration, total int) *progressReporter {
+	progress := &progressReporter{
+		output:  output,
+		total:   total,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go func() {
+		defer close(progress.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				progress.report(false)
+			case <-progress.stop:
+				return
+			}
+		}
+	}()
+	return progress
+}
+
+// advance marks a tree entry as processed, bytes is the size written for it
+func (progress *progressReporter) advance(bytes int64) {
+	if progress == nil {
+		return
+	}
+	progress.processed.Add(1)
+	progress.bytes.Add(bytes)
+}
+
+func (progress *progressReporter) report(done bool) {
+	event, _ := json.Marshal(&progressEvent{
+		Processed: progress.processed.Load(),
+		Total:     progress.total,
+		Bytes:     progress.bytes.Load(),
+		Done:      done,
+	})
+	_, _ = progress.output.Write(append(event, '\n'))
+}
+
+// finish stops the periodic reports, and writes the final one when the pass succeeded
+func (progress *progressReporter) finish(succeeded bool) {
+	if progress == nil {
+		return
+	}
+	close(progress.stop)
+	<-progress.stopped
+	if succeeded {
+		progress.report(true)
+	}
+}
@@ -0,0 +1,68 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gitsnap/options"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithProgress(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("dir%v/file%v.txt", i%5, i)] = fmt.Sprintf("content %v", i)
+	}
+	clonePath, revision := createLocalRepo(files)
+	defer os.RemoveAll(clonePath)
+
+	for _, skipDoubleCheck := range []bool{false, true} {
+		reader, writer, err := os.Pipe()
+		require.Nil(t, err)
+		stderr := os.Stderr
+		os.Stderr = writer
+
+		streamed := make(chan []byte)
+		go func() {
+			contents, _ := io.ReadAll(reader)
+			streamed <- contents
+		}()
+
+		result, err := SnapshotWithResult(&options.Options{
+			ClonePath:        clonePath,
+			Revision:         revision,
+			OutputPath:       t.TempDir(),
+			IncludePatterns:  []string{},
+			ExcludePatterns:  []string{},
+			SkipDoubleCheck:  skipDoubleCheck,
+			Progress:         options.PROGRESS_JSON,
+			ProgressInterval: time.Millisecond,
+		})
+		os.Stderr = stderr
+		require.Nil(t, err)
+		require.Nil(t, writer.Close())
+
+		var events []progressEvent
+		scanner := bufio.NewScanner(bytes.NewReader(<-streamed))
+		for scanner.Scan() {
+			event := progressEvent{}
+			require.Nil(t, json.Unmarshal(scanner.Bytes(), &event))
+			events = append(events, event)
+		}
+		require.NotEmpty(t, events)
+
+		last := events[len(events)-1]
+		// the files and their 5 directories
+		require.Equal(t, progressEvent{Processed: 55, Total: 55, Bytes: result.BytesWritten, Done: true}, last)
+		for _, event := range events[:len(events)-1] {
+			require.False(t, event.Done)
+			require.LessOrEqual(t, event.Processed, last.Processed)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// pruneOutput removes the files of the output path which the snapshot didn't write, left there by a snapshot
+// of another revision. the files written by the run besides the snapshot, such as the index, are kept.
+func (provider *repositoryProvider) pruneOutput(outputPath string, records []*indexRecord) error {
+	outputPath = absolutePath(outputPath)
+	keep := map[string]bool{}
+	for _, record := range records {
+		if !record.snapped || record.targetPath == "" {
+			continue
+		}
+		targetFilePath := filepath.Join(outputPath, filepath.FromSlash(record.targetPath))
+		keep[targetFilePath] = true
+		if provider.opts.CreateHashMarkers {
+			keep[targetFilePath+".hash"] = true
+		}
+	}
+	for _, filePath := range []string{provider.opts.OptionalIndexFilePath, provider.opts.ChecksumPath, provider.opts.DeletionsFilePath, provider.opts.WithStatsPath} {
+		if filePath != "" {
+			keep[absolutePath(filePath)] = true
+		}
+	}
+	keptDirectory := ""
+	if provider.opts.HashMarkersDir != "" {
+		keptDirectory = absolutePath(provider.opts.HashMarkersDir)
+	}
+
+	prunedDirectories := map[string]bool{}
+	pruned := 0
+	err := filepath.WalkDir(outputPath, func(filePath string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if filePath == keptDirectory {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if keep[filePath] {
+			return nil
+		}
+		err = os.Remove(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to prune '%v': %v", filePath, err)
+		}
+		provider.verboseLog("--- pruned '%v'", filePath)
+		pruned++
+		prunedDirectories[filepath.Dir(filePath)] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// directories left empty are removed as well, deepest first, unless they may be kept by --keep-empty-dirs
+	if !provider.opts.KeepEmptyDirs {
+		directories := []string{}
+		for directory := range prunedDirectories {
+			for directory != outputPath && isWithinPath(outputPath, directory) {
+				directories = append(directories, directory)
+				directory = filepath.Dir(directory)
+			}
+		}
+		sort.Slice(directories, func(i, j int) bool {
+			return len(directories[i]) > len(directories[j])
+		})
+		for _, directory := range directories {
+			// fails for directories which are not empty
+			_ = os.Remove(directory)
+		}
+	}
+
+	provider.logger.Infof("pruned %v files which are not part of the snapshot from '%v'", pruned, outputPath)
+	return nil
+}
+
+func absolutePath(filePath string) string {
+	absolute, err := filepath.Abs(filePath)
+	if err != nil {
+		return filepath.Clean(filePath)
+	}
+	return absolute
+}
@@ -0,0 +1,56 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithPrune(t *testing.T) {
+	clonePath, firstRevision := createLocalRepo(map[string]string{
+		"kept.txt":         "kept",
+		"removed.txt":      "removed",
+		"old/deep/old.txt": "old",
+		"dir/kept.txt":     "kept",
+		"dir/removed.txt":  "removed",
+	})
+	defer os.RemoveAll(clonePath)
+	runGit(clonePath, "rm", "-q", "-r", "removed.txt", "old", "dir/removed.txt")
+	secondRevision := commitFiles(clonePath, map[string]string{"new.txt": "new"}, "tester <tester@example.com>")
+
+	outputPath := t.TempDir()
+	indexPath := filepath.Join(outputPath, "index.tsv")
+	snapshot := func(revision string) {
+		err := Snapshot(&options.Options{
+			ClonePath:             clonePath,
+			Revision:              revision,
+			OutputPath:            outputPath,
+			IncludePatterns:       []string{},
+			ExcludePatterns:       []string{},
+			OptionalIndexFilePath: indexPath,
+			CreateHashMarkers:     true,
+			Prune:                 true,
+		})
+		require.Nil(t, err)
+	}
+
+	snapshot(firstRevision)
+	requireFileContents(t, filepath.Join(outputPath, "old", "deep", "old.txt"), "old")
+	snapshot(secondRevision)
+
+	requireFileContents(t, filepath.Join(outputPath, "kept.txt"), "kept")
+	requireFileContents(t, filepath.Join(outputPath, "dir", "kept.txt"), "kept")
+	requireFileContents(t, filepath.Join(outputPath, "new.txt"), "new")
+	// files of the run itself are kept
+	require.FileExists(t, indexPath)
+	require.FileExists(t, filepath.Join(outputPath, "kept.txt.hash"))
+	for _, filePath := range []string{"removed.txt", "removed.txt.hash", "dir/removed.txt", "old/deep/old.txt"} {
+		require.NoFileExists(t, filepath.Join(outputPath, filePath))
+	}
+	// as well as the directories left empty
+	require.NoDirExists(t, filepath.Join(outputPath, "old"))
+	require.DirExists(t, filepath.Join(outputPath, "dir"))
+}
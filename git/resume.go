@@ -0,0 +1,38 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+)
+
+// keepsExistingFile checks whether the file at the target path is kept instead of being written, by --resume or
+// --overwrite skip
+func (provider *repositoryProvider) keepsExistingFile(record *indexRecord, targetFilePath string, symlink bool) bool {
+	if provider.opts.Resume && provider.isAlreadyWritten(record, targetFilePath, symlink) {
+		return true
+	}
+	if provider.opts.Overwrite == options.OVERWRITE_SKIP {
+		_, err := os.Lstat(targetFilePath)
+		return err == nil
+	}
+	return false
+}
+
+// isAlreadyWritten checks with --resume whether the target file was written by a previous run of the snapshot: its
+// .hash marker holds the digest of the file, or without one, it has the size of the file. the contents are trusted.
+func (provider *repositoryProvider) isAlreadyWritten(record *indexRecord, targetFilePath string, symlink bool) bool {
+	info, err := os.Lstat(targetFilePath)
+	if err != nil {
+		return false
+	}
+	if symlink && info.Mode()&os.ModeSymlink == 0 || !symlink && !info.Mode().IsRegular() {
+		return false
+	}
+	if provider.opts.CreateHashMarkers {
+		marker, err := os.ReadFile(targetFilePath + ".hash")
+		if err == nil {
+			return string(marker) == record.digest
+		}
+	}
+	return info.Size() == record.size
+}
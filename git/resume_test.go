@@ -0,0 +1,88 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithResume(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"written.txt":   "written",
+		"partial.txt":   "partial",
+		"missing.txt":   "missing",
+		"marked.txt":    "marked",
+		"stale.txt":     "stale",
+		"dir/other.txt": "other",
+	})
+	defer os.RemoveAll(clonePath)
+	snapshot := func(outputPath string, createHashMarkers bool) {
+		result, err := SnapshotWithResult(&options.Options{
+			ClonePath:         clonePath,
+			Revision:          revision,
+			OutputPath:        outputPath,
+			IncludePatterns:   []string{},
+			ExcludePatterns:   []string{},
+			Resume:            true,
+			CreateHashMarkers: createHashMarkers,
+		})
+		require.Nil(t, err)
+		// kept files count as written
+		require.Equal(t, 6, result.FilesWritten)
+	}
+
+	// left by an interrupted snapshot
+	outputPath := t.TempDir()
+	writeFiles(outputPath, map[string]string{
+		"written.txt": "WRITTEN",
+		"partial.txt": "par",
+	})
+	snapshot(outputPath, false)
+	// a file of the same size is trusted, and kept as it is
+	requireFileContents(t, filepath.Join(outputPath, "written.txt"), "WRITTEN")
+	requireFileContents(t, filepath.Join(outputPath, "partial.txt"), "partial")
+	requireFileContents(t, filepath.Join(outputPath, "missing.txt"), "missing")
+	requireFileContents(t, filepath.Join(outputPath, "dir", "other.txt"), "other")
+
+	// the hash marker is checked instead of the size
+	outputPath = t.TempDir()
+	writeFiles(outputPath, map[string]string{
+		"marked.txt":      "MARK",
+		"marked.txt.hash": runGit(clonePath, "rev-parse", revision+":marked.txt"),
+		"stale.txt":       "STALE",
+		"stale.txt.hash":  runGit(clonePath, "rev-parse", revision+":written.txt"),
+	})
+	snapshot(outputPath, true)
+	requireFileContents(t, filepath.Join(outputPath, "marked.txt"), "MARK")
+	requireFileContents(t, filepath.Join(outputPath, "stale.txt"), "stale")
+	requireFileContents(t, filepath.Join(outputPath, "written.txt"), "written")
+}
+
+func TestSnapshotWithOverwriteSkip(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"existing.txt": "existing",
+		"new.txt":      "new",
+	})
+	defer os.RemoveAll(clonePath)
+
+	outputPath := t.TempDir()
+	writeFiles(outputPath, map[string]string{
+		"existing.txt": "left by another snapshot",
+		"other.txt":    "other",
+	})
+	err := Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      outputPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+		Overwrite:       options.OVERWRITE_SKIP,
+	})
+	require.Nil(t, err)
+	requireFileContents(t, filepath.Join(outputPath, "existing.txt"), "left by another snapshot")
+	requireFileContents(t, filepath.Join(outputPath, "new.txt"), "new")
+	requireFileContents(t, filepath.Join(outputPath, "other.txt"), "other")
+}
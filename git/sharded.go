@@ -0,0 +1,31 @@
+package git
+
+import (
+	"gitsnap/options"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// remapsTargetPaths returns whether files are written elsewhere than their tree path, which the index then maps them to
+func (provider *repositoryProvider) remapsTargetPaths() bool {
+	return provider.opts.Flatten || provider.opts.OutputLayout == options.OUTPUT_LAYOUT_SHARDED
+}
+
+// shardedFilePath returns the path of a blob written by --output-layout sharded, bucketed by the first two
+// pairs of its id, which keeps the number of entries of every directory low
+func shardedFilePath(hash plumbing.Hash) string {
+	id := hash.String()
+	return filepath.Join(id[:2], id[2:4], id)
+}
+
+// claimShardedTargetPath claims the target path of a file written by --output-layout sharded under the root.
+// a blob is written once, so the result is shared when its path was claimed for another file already.
+func (provider *repositoryProvider) claimShardedTargetPath(rootPath string, filePath string, hash plumbing.Hash) (string, bool) {
+	targetFilePath := filepath.Join(rootPath, shardedFilePath(hash))
+	if provider.writtenPaths.add(targetFilePath) {
+		return targetFilePath, false
+	}
+	provider.verboseLog("*** '%v' shares '%v' - its blob was already written", filePath, targetFilePath)
+	return targetFilePath, true
+}
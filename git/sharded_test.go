@@ -0,0 +1,79 @@
+package git
+
+import (
+	"encoding/csv"
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedFilePath(t *testing.T) {
+	hash := plumbing.NewHash("0123456789abcdef0123456789abcdef01234567")
+	require.Equal(t, filepath.Join("01", "23", "0123456789abcdef0123456789abcdef01234567"), shardedFilePath(hash))
+}
+
+func TestSnapshotWithShardedLayout(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"main.go":        "package main",
+		"a/copy.go":      "package main",
+		"a/b/lib.go":     "package b",
+		"docs/readme.md": "# readme",
+	})
+	defer os.RemoveAll(clonePath)
+	blobId := func(path string) string {
+		return runGit(clonePath, "rev-parse", revision+":"+path)
+	}
+
+	outputPath := t.TempDir()
+	indexPath := filepath.Join(t.TempDir(), "index.tsv")
+	result, err := SnapshotWithResult(&options.Options{
+		ClonePath:             clonePath,
+		Revision:              revision,
+		OutputPath:            outputPath,
+		IncludePatterns:       []string{},
+		ExcludePatterns:       []string{},
+		OptionalIndexFilePath: indexPath,
+		OutputLayout:          options.OUTPUT_LAYOUT_SHARDED,
+	})
+	require.Nil(t, err)
+	// the two paths of the same blob are written once
+	require.Equal(t, 3, result.FilesWritten)
+	require.Equal(t, 1, result.DedupedFiles)
+
+	var written []string
+	err = filepath.WalkDir(outputPath, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			relativePath, _ := filepath.Rel(outputPath, path)
+			written = append(written, filepath.ToSlash(relativePath))
+		}
+		return err
+	})
+	require.Nil(t, err)
+	shardedPath := func(path string) string {
+		id := blobId(path)
+		return id[:2] + "/" + id[2:4] + "/" + id
+	}
+	require.ElementsMatch(t, []string{shardedPath("main.go"), shardedPath("a/b/lib.go"), shardedPath("docs/readme.md")}, written)
+	requireFileContents(t, filepath.Join(outputPath, filepath.FromSlash(shardedPath("a/b/lib.go"))), "package b")
+
+	file, err := os.Open(indexPath)
+	require.Nil(t, err)
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comma = '\t'
+	records, err := reader.ReadAll()
+	require.Nil(t, err)
+	require.Equal(t, []string{"Path", "BlobId", "IsFile", "TargetPath"}, records[0])
+	targetPaths := map[string]string{}
+	for _, record := range records[1:] {
+		targetPaths[record[0]] = record[3]
+	}
+	for _, path := range []string{"main.go", "a/copy.go", "a/b/lib.go", "docs/readme.md"} {
+		require.Equal(t, shardedPath(path), targetPaths[path], path)
+	}
+	require.Equal(t, targetPaths["main.go"], targetPaths["a/copy.go"])
+}
@@ -0,0 +1,155 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"gitsnap/util"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshSignatureMagic     = "SSHSIG"
+	sshSignatureBegin     = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureEnd       = "-----END SSH SIGNATURE-----"
+	sshSignatureNamespace = "git"
+)
+
+type sshSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func verifyCommitSignature(commit *object.Commit, keyringPath string) error {
+	err := verifySignature(commit, keyringPath)
+	if err != nil {
+		return &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_SIGNATURE,
+			InternalError: fmt.Errorf("failed to verify signature of commit '%v': %v", commit.Hash, err),
+		}
+	}
+	return nil
+}
+
+func verifySignature(commit *object.Commit, keyringPath string) error {
+	if commit.PGPSignature == "" {
+		return fmt.Errorf("commit is not signed")
+	}
+
+	keyring, err := os.ReadFile(keyringPath)
+	if err != nil {
+		return fmt.Errorf("failed to read keyring '%v': %v", keyringPath, err)
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(commit.PGPSignature), sshSignatureBegin) {
+		return verifySshSignature(commit, keyring)
+	}
+
+	_, err = commit.Verify(string(keyring))
+	return err
+}
+
+func verifySshSignature(commit *object.Commit, allowedKeys []byte) error {
+	armored := strings.TrimSpace(commit.PGPSignature)
+	armored = strings.TrimSuffix(strings.TrimPrefix(armored, sshSignatureBegin), sshSignatureEnd)
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(armored), ""))
+	if err != nil {
+		return fmt.Errorf("malformed ssh signature: %v", err)
+	}
+	if !bytes.HasPrefix(blob, []byte(sshSignatureMagic)) {
+		return fmt.Errorf("malformed ssh signature: missing %v preamble", sshSignatureMagic)
+	}
+
+	signature := &sshSignature{}
+	err = ssh.Unmarshal(blob[len(sshSignatureMagic):], signature)
+	if err != nil {
+		return fmt.Errorf("malformed ssh signature: %v", err)
+	}
+	if signature.Namespace != sshSignatureNamespace {
+		return fmt.Errorf("unexpected ssh signature namespace '%v'", signature.Namespace)
+	}
+
+	publicKey, err := ssh.ParsePublicKey(signature.PublicKey)
+	if err != nil {
+		return fmt.Errorf("malformed ssh signature public key: %v", err)
+	}
+	if !isAllowedSshKey(publicKey, allowedKeys) {
+		return fmt.Errorf("signing key %v is not in the keyring", ssh.FingerprintSHA256(publicKey))
+	}
+
+	var hasher hash.Hash
+	switch signature.HashAlgorithm {
+	case "sha256":
+		hasher = sha256.New()
+	case "sha512":
+		hasher = sha512.New()
+	default:
+		return fmt.Errorf("unsupported ssh signature hash algorithm '%v'", signature.HashAlgorithm)
+	}
+	payload, err := commitPayload(commit)
+	if err != nil {
+		return err
+	}
+	hasher.Write(payload)
+
+	signedData := append([]byte(sshSignatureMagic), ssh.Marshal(&sshSignedData{
+		Namespace:     signature.Namespace,
+		Reserved:      signature.Reserved,
+		HashAlgorithm: signature.HashAlgorithm,
+		Hash:          hasher.Sum(nil),
+	})...)
+
+	sshSig := &ssh.Signature{}
+	err = ssh.Unmarshal(signature.Signature, sshSig)
+	if err != nil {
+		return fmt.Errorf("malformed ssh signature blob: %v", err)
+	}
+	return publicKey.Verify(signedData, sshSig)
+}
+
+// isAllowedSshKey accepts both authorized_keys and allowed_signers formatted key lists
+func isAllowedSshKey(publicKey ssh.PublicKey, allowedKeys []byte) bool {
+	for _, line := range strings.Split(string(allowedKeys), "\n") {
+		allowedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(allowedKey.Marshal(), publicKey.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func commitPayload(commit *object.Commit) ([]byte, error) {
+	encoded := &plumbing.MemoryObject{}
+	err := commit.EncodeWithoutSignature(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode commit: %v", err)
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,104 @@
+package git
+
+import (
+	"bytes"
+	"gitsnap/options"
+	"gitsnap/util"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func snapshotVerifyingSignature(t *testing.T, clonePath string, revision string, keyringPath string) error {
+	outputPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(outputPath)
+	return Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      outputPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+		VerifySignature: true,
+		KeyringPath:     keyringPath,
+	})
+}
+
+func requireBadSignature(t *testing.T, err error) {
+	var errorWithCode *util.ErrorWithCode
+	require.ErrorAs(t, err, &errorWithCode)
+	require.Equal(t, util.ERROR_BAD_SIGNATURE, errorWithCode.StatusCode)
+}
+
+func TestSnapshotVerifyingSshSignature(t *testing.T) {
+	clonePath, unsignedRevision := createLocalRepo(map[string]string{"a.txt": "a"})
+	defer os.RemoveAll(clonePath)
+	keysPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(keysPath)
+
+	keyPath := filepath.Join(keysPath, "key")
+	otherKeyPath := filepath.Join(keysPath, "other")
+	for _, path := range []string{keyPath, otherKeyPath} {
+		runCommandIn(keysPath, "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", path)
+	}
+
+	writeFiles(clonePath, map[string]string{"b.txt": "b"})
+	runGit(clonePath, "add", "-A")
+	runGit(clonePath, "-c", "gpg.format=ssh", "-c", "user.signingkey="+keyPath, "commit", "-q", "-S", "-m", "signed")
+	signedRevision := runGit(clonePath, "rev-parse", "HEAD")
+
+	require.Nil(t, snapshotVerifyingSignature(t, clonePath, signedRevision, keyPath+".pub"))
+	requireBadSignature(t, snapshotVerifyingSignature(t, clonePath, signedRevision, otherKeyPath+".pub"))
+	requireBadSignature(t, snapshotVerifyingSignature(t, clonePath, unsignedRevision, keyPath+".pub"))
+}
+
+func TestSnapshotVerifyingPgpSignature(t *testing.T) {
+	clonePath, unsignedRevision := createLocalRepo(map[string]string{"a.txt": "a"})
+	defer os.RemoveAll(clonePath)
+
+	entity, err := openpgp.NewEntity("tester", "", "tester@example.com", nil)
+	require.Nil(t, err)
+	otherEntity, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	require.Nil(t, err)
+
+	repository, err := git.PlainOpen(clonePath)
+	require.Nil(t, err)
+	worktree, err := repository.Worktree()
+	require.Nil(t, err)
+	writeFiles(clonePath, map[string]string{"b.txt": "b"})
+	hash, err := worktree.Commit("signed", &git.CommitOptions{
+		All:     true,
+		Author:  &object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()},
+		SignKey: entity,
+	})
+	require.Nil(t, err)
+
+	keyringsPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(keyringsPath)
+	keyringPath := writeArmoredPublicKey(t, keyringsPath, entity)
+	otherKeyringPath := writeArmoredPublicKey(t, keyringsPath, otherEntity)
+
+	require.Nil(t, snapshotVerifyingSignature(t, clonePath, hash.String(), keyringPath))
+	requireBadSignature(t, snapshotVerifyingSignature(t, clonePath, hash.String(), otherKeyringPath))
+	requireBadSignature(t, snapshotVerifyingSignature(t, clonePath, unsignedRevision, keyringPath))
+}
+
+func writeArmoredPublicKey(t *testing.T, dirPath string, entity *openpgp.Entity) string {
+	buffer := &bytes.Buffer{}
+	writer, err := armor.Encode(buffer, openpgp.PublicKeyType, nil)
+	require.Nil(t, err)
+	require.Nil(t, entity.Serialize(writer))
+	require.Nil(t, writer.Close())
+	keyringPath := filepath.Join(dirPath, entity.PrimaryKey.KeyIdString()+".asc")
+	require.Nil(t, os.WriteFile(keyringPath, buffer.Bytes(), 0644))
+	return keyringPath
+}
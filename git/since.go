@@ -0,0 +1,32 @@
+package git
+
+import (
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// loadRecentChanges finds the last commit touching each path changed after --since, cached for the run, by diffing
+// every commit of the first-parent history committed after the date with its first parent
+func (provider *repositoryProvider) loadRecentChanges(commit *object.Commit) error {
+	history, err := provider.firstParentHistory(commit, func(commit *object.Commit, _ int) bool {
+		return commit.Committer.When.After(provider.opts.Since)
+	})
+	if err != nil {
+		return err
+	}
+	provider.recentChanges, err = provider.loadLastChanges(history, provider.opts.SinceWorkers)
+	if err != nil {
+		return err
+	}
+	provider.verboseLog("%v paths changed by %v commits since %v", len(provider.recentChanges), len(history), provider.opts.Since)
+	return nil
+}
+
+// changedSince checks whether a file of the snapshot was last changed after --since
+func (provider *repositoryProvider) changedSince(filePath string) bool {
+	lastCommit := provider.lastChangeOf(provider.recentChanges, filePath)
+	if lastCommit == nil {
+		return false
+	}
+	provider.verboseLog("'%v' was last changed at %v by %v", filePath, lastCommit.Committer.When, lastCommit.Hash)
+	return true
+}
@@ -0,0 +1,64 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithSince(t *testing.T) {
+	clonePath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(clonePath)
+	runGit(clonePath, "init", "-q")
+	commitFilesAt(clonePath, map[string]string{
+		"old.txt":         "old",
+		"changed.txt":     "before",
+		"nested/old.txt":  "old",
+		"nested/also.txt": "old",
+	}, "2020-01-01T00:00:00Z")
+	commitFilesAt(clonePath, map[string]string{
+		"changed.txt": "after",
+		"new.txt":     "new",
+	}, "2024-06-01T00:00:00Z")
+	commitFilesAt(clonePath, map[string]string{
+		"nested/also.txt": "recent",
+	}, "2024-07-01T00:00:00Z")
+	revision := runGit(clonePath, "rev-parse", "HEAD")
+
+	for since, expected := range map[string][]string{
+		"2019-01-01T00:00:00Z": {"old.txt", "changed.txt", "new.txt", "nested/old.txt", "nested/also.txt"},
+		"2024-01-01T00:00:00Z": {"changed.txt", "new.txt", "nested/also.txt"},
+		"2024-06-15T00:00:00Z": {"nested/also.txt"},
+		"2025-01-01T00:00:00Z": {},
+	} {
+		sinceTime, err := time.Parse(time.RFC3339, since)
+		require.Nil(t, err)
+		outputPath := t.TempDir()
+		err = Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			Since:           sinceTime,
+			SinceWorkers:    2,
+		})
+		require.Nil(t, err)
+
+		var written []string
+		err = filepath.WalkDir(outputPath, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				relativePath, _ := filepath.Rel(outputPath, path)
+				written = append(written, filepath.ToSlash(relativePath))
+			}
+			return err
+		})
+		require.Nil(t, err)
+		require.ElementsMatch(t, expected, written, "since %v", since)
+	}
+}
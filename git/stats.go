@@ -0,0 +1,314 @@
+package git
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gitsnap/options"
+	"gitsnap/stats"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	MAX_SHEBANG_LENGTH = 256
+)
+
+func decodeContents(contents []byte) string {
+	encoding, _, _ := charset.DetermineEncoding(contents, "")
+	decoded, err := encoding.NewDecoder().Bytes(contents)
+	if err != nil {
+		return string(contents)
+	}
+	return string(decoded)
+}
+
+// splitLines returns the physical lines of decoded contents, ended by \n, \r\n or a lone \r. a line ending ends a line
+// rather than starting an empty one, so a trailing one adds no line, and a last line without one is still a line.
+func splitLines(decoded string) []string {
+	// the UTF-8 decoder keeps the byte order mark, which strings.TrimSpace doesn't trim
+	decoded = strings.TrimPrefix(decoded, "\ufeff")
+	decoded = strings.ReplaceAll(strings.ReplaceAll(decoded, "\r\n", "\n"), "\r", "\n")
+	if len(decoded) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(decoded, "\n"), "\n")
+}
+
+// lineCounts splits the physical lines of a file into code, comment and blank lines
+type lineCounts struct {
+	code    int
+	comment int
+	blank   int
+}
+
+// lineScanner carries the comment and string state of a file from one line to the next
+type lineScanner struct {
+	syntax commentSyntax
+	// nesting depth of the block comment the scanner is in, 0 outside of block comments
+	depth int
+	// quote of the string the scanner is in, 0 outside of strings
+	quote byte
+}
+
+// scan reads a trimmed line and checks whether any of it is code, rather than comments
+func (scanner *lineScanner) scan(line string) bool {
+	hasCode := false
+	for i := 0; i < len(line); {
+		rest := line[i:]
+		switch {
+		case scanner.depth > 0:
+			if scanner.syntax.nestedBlocks && strings.HasPrefix(rest, scanner.syntax.blockStart) {
+				scanner.depth++
+				i += len(scanner.syntax.blockStart)
+			} else if strings.HasPrefix(rest, scanner.syntax.blockEnd) {
+				scanner.depth--
+				i += len(scanner.syntax.blockEnd)
+			} else {
+				i++
+			}
+		case scanner.quote != 0:
+			if rest[0] == '\\' {
+				i += 2
+				continue
+			}
+			if rest[0] == scanner.quote {
+				scanner.quote = 0
+			}
+			i++
+		case scanner.syntax.blockStart != "" && strings.HasPrefix(rest, scanner.syntax.blockStart) && (i == 0 || !scanner.syntax.blocksAtLineStart):
+			scanner.depth = 1
+			i += len(scanner.syntax.blockStart)
+		case scanner.startsLineComment(rest):
+			return hasCode
+		default:
+			if rest[0] != ' ' && rest[0] != '\t' {
+				hasCode = true
+			}
+			if strings.IndexByte(scanner.syntax.quotes, rest[0]) >= 0 {
+				scanner.quote = rest[0]
+			}
+			i++
+		}
+	}
+	if scanner.quote != '`' {
+		scanner.quote = 0
+	}
+	return hasCode
+}
+
+func (scanner *lineScanner) startsLineComment(rest string) bool {
+	for _, prefix := range scanner.syntax.lineComments {
+		if strings.HasPrefix(rest, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// countLinesOfCode counts lines which are neither blank nor entirely a comment, along with the comment and blank ones.
+// a line with code outside its comments, such as before a comment or between two, is a line of code.
+func countLinesOfCode(contents []byte, language string) lineCounts {
+	scanner := &lineScanner{syntax: commentSyntaxOf(language)}
+
+	counts := lineCounts{}
+	for _, line := range splitLines(decodeContents(contents)) {
+		line = strings.TrimSpace(line)
+		inString := scanner.quote != 0
+		switch {
+		case len(line) == 0 && scanner.depth > 0:
+			counts.comment++
+		case len(line) == 0 && !inString:
+			counts.blank++
+		case scanner.scan(line) || inString:
+			counts.code++
+		default:
+			counts.comment++
+		}
+	}
+	return counts
+}
+
+// collectStats counts the lines of code per language and the size of the files a snapshot of the commit would write.
+// with a details output, a JSON line is written to it for every counted file.
+func (provider *repositoryProvider) collectStats(commit *object.Commit, details io.Writer) (*stats.CodeStats, error) {
+	tree, err := provider.getSnapshotTree(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	var detailsEncoder *json.Encoder = nil
+	if details != nil {
+		detailsEncoder = json.NewEncoder(details)
+	}
+
+	codeStats := stats.NewCodeStats()
+	treeWalker := provider.newSnapshotWalker(commit, tree)
+	defer treeWalker.Close()
+
+	for {
+		name, entry, walkErr := treeWalker.Next()
+		if walkErr == io.EOF {
+			break
+		}
+		if walkErr != nil {
+			return nil, fmt.Errorf("failed to iterate files of %v: %v", commit.Hash, walkErr)
+		}
+
+		file, err := provider.selectFile(name, &entry)
+		if err != nil {
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				provider.logger.Infof("--- skipping '%v' - blob %v is missing from the object store (partial clone?): %v", name, entry.Hash, err)
+				continue
+			}
+			return nil, err
+		}
+		if file == nil {
+			continue
+		}
+
+		err = provider.addFileStats(codeStats, detailsEncoder, name, file.Size,
+			func() (string, error) { return readFirstLine(file) },
+			func() ([]byte, error) { return provider.readContents(file) })
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	codeStats.Finalize()
+	codeStats.KeepTopLanguages(provider.opts.StatsTopLanguages)
+	return codeStats, nil
+}
+
+// addFileStats adds a selected file to the stats by the language of its extension, or of its #! line with
+// --stats-detect-shebang. readLine and readAll read the first line and the contents of the file, from the commit or
+// from the directory.
+func (provider *repositoryProvider) addFileStats(codeStats *stats.CodeStats, detailsEncoder *json.Encoder, name string, size int64,
+	readLine func() (string, error), readAll func() ([]byte, error)) error {
+	language, found := stats.GetLanguageFromExtension(filepath.Ext(name))
+	if !found && provider.opts.StatsDetectShebang {
+		firstLine, err := readLine()
+		if err != nil {
+			return err
+		}
+		language, found = stats.GetLanguageFromShebang(firstLine)
+	}
+	if !found {
+		codeStats.AddFile("", size, 0, 0, 0)
+		return nil
+	}
+	contents, err := readAll()
+	if err != nil {
+		return err
+	}
+	counts := countLinesOfCode(contents, language)
+	codeStats.AddFile(language, size, counts.code, counts.comment, counts.blank)
+	if detailsEncoder != nil {
+		err = detailsEncoder.Encode(&stats.FileStats{Path: name, Language: language, LinesOfCode: counts.code, SizeBytes: size})
+		if err != nil {
+			return fmt.Errorf("failed to write stats details of '%v': %v", name, err)
+		}
+	}
+	provider.verboseLog("+++ '%v' to stats", name)
+	return nil
+}
+
+// readFirstLine reads no more than the first line of the file, up to MAX_SHEBANG_LENGTH bytes
+func readFirstLine(file *object.File) (string, error) {
+	reader, err := file.Reader()
+	if err != nil {
+		return "", fmt.Errorf("failed to read git file '%v': %v", file.Name, err)
+	}
+	defer reader.Close()
+
+	firstLine, err := readLineFrom(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read git file '%v': %v", file.Name, err)
+	}
+	return firstLine, nil
+}
+
+func readLineFrom(reader io.Reader) (string, error) {
+	head := make([]byte, MAX_SHEBANG_LENGTH)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	firstLine, _, _ := strings.Cut(string(head[:n]), "\n")
+	return strings.TrimSpace(firstLine), nil
+}
+
+func (provider *repositoryProvider) writeStats(commit *object.Commit, statsPath string, detailsPath string) error {
+	var details io.Writer = nil
+	var detailsWriter *bufio.Writer = nil
+	if detailsPath != "" {
+		detailsFile, err := os.Create(detailsPath)
+		if err != nil {
+			return fmt.Errorf("failed to create stats details file '%v': %v", detailsPath, err)
+		}
+		defer detailsFile.Close()
+		detailsWriter = bufio.NewWriter(detailsFile)
+		details = detailsWriter
+	}
+
+	var codeStats *stats.CodeStats
+	var err error
+	if provider.opts.StatsDir != "" {
+		codeStats, err = provider.collectDirectoryStats(provider.opts.StatsDir, details)
+	} else {
+		codeStats, err = provider.collectStats(commit, details)
+	}
+	if err == nil && detailsWriter != nil {
+		err = detailsWriter.Flush()
+		if err != nil {
+			err = fmt.Errorf("failed to write stats details file '%v': %v", detailsPath, err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return provider.writeStatsFile(codeStats, statsPath)
+}
+
+// writeRecordStats writes the stats of the files written by the snapshot, from the contents read to write them
+func (provider *repositoryProvider) writeRecordStats(statsPath string, records []*indexRecord) error {
+	codeStats := stats.NewCodeStats()
+	for _, record := range records {
+		if !record.snapped || !record.entry.Mode.IsFile() {
+			continue
+		}
+		codeStats.AddFile(record.language, record.size, record.lines.code, record.lines.comment, record.lines.blank)
+	}
+	codeStats.Finalize()
+	return provider.writeStatsFile(codeStats, statsPath)
+}
+
+func (provider *repositoryProvider) writeStatsFile(codeStats *stats.CodeStats, statsPath string) error {
+	statsFile, err := os.Create(statsPath)
+	if err != nil {
+		return fmt.Errorf("failed to create stats file '%v': %v", statsPath, err)
+	}
+	defer statsFile.Close()
+
+	if provider.opts.StatsFormat == options.STATS_FORMAT_CSV {
+		err = codeStats.WriteCSV(statsFile)
+	} else {
+		encoder := json.NewEncoder(statsFile)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(codeStats)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write stats file '%v': %v", statsPath, err)
+	}
+
+	provider.logger.Infof("written stats of %v languages and %v lines of code to '%v'", len(codeStats.CountersByLanguage), codeStats.TotalLinesOfCode, statsPath)
+	return nil
+}
@@ -0,0 +1,336 @@
+package git
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"gitsnap/options"
+	"gitsnap/stats"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountLinesOfCode(t *testing.T) {
+	java := `package a;
+
+// comment
+/* block
+   comment */
+class A {
+	/** doc */
+	int a = 1;
+}
+`
+	require.Equal(t, lineCounts{code: 4, comment: 4, blank: 1}, countLinesOfCode([]byte(java), "java"))
+
+	python := `import os
+
+# comment
+"""
+docstring
+"""
+def f():
+    """one line docstring"""
+    return os.getcwd()
+`
+	require.Equal(t, 3, countLinesOfCode([]byte(python), "python").code)
+
+	sql := `-- comment
+SELECT a
+/* block
+   comment */
+FROM b; -- trailing comment
+
+`
+	require.Equal(t, 2, countLinesOfCode([]byte(sql), "sql").code)
+
+	shell := `#!/bin/sh
+# comment
+echo "a" # trailing comment
+
+/* not a comment */
+`
+	require.Equal(t, 2, countLinesOfCode([]byte(shell), "shell").code)
+
+	html := `<!-- comment -->
+<html>
+<!--
+  block comment
+-->
+</html>
+`
+	require.Equal(t, 2, countLinesOfCode([]byte(html), "html").code)
+
+	lua := `-- comment
+--[[ block
+comment ]]
+print("a")
+`
+	require.Equal(t, 1, countLinesOfCode([]byte(lua), "lua").code)
+
+	for language, contents := range map[string]string{"java": java, "python": python, "sql": sql, "shell": shell, "html": html, "lua": lua} {
+		counts := countLinesOfCode([]byte(contents), language)
+		require.Greater(t, counts.comment, 0, language)
+		physicalLines := strings.Count(contents, "\n")
+		require.Equal(t, physicalLines, counts.code+counts.comment+counts.blank, language)
+	}
+	require.Equal(t, lineCounts{}, countLinesOfCode([]byte{}, "java"))
+	require.Equal(t, lineCounts{code: 1}, countLinesOfCode([]byte("a"), "java"))
+}
+
+func TestCountLinesOfCodeLineEndings(t *testing.T) {
+	for contents, expected := range map[string]lineCounts{
+		"":                           {},
+		"\n":                         {blank: 1},
+		"\n\n":                       {blank: 2},
+		"a = 1;":                     {code: 1},
+		"a = 1;\n":                   {code: 1},
+		"a = 1;\n\n":                 {code: 1, blank: 1},
+		"a = 1;\nb = 2;":             {code: 2},
+		"// a\nb = 2;":               {code: 1, comment: 1},
+		"a = 1;\n// b":               {code: 1, comment: 1},
+		"a = 1;\r\nb = 2;\r\n":       {code: 2},
+		"a = 1;\r\n\r\n":             {code: 1, blank: 1},
+		"a = 1;\rb = 2;\r":           {code: 2},
+		"\xef\xbb\xbf// a\nb = 2;\n": {code: 1, comment: 1},
+	} {
+		require.Equal(t, expected, countLinesOfCode([]byte(contents), "java"), "%q", contents)
+	}
+}
+
+func TestCountLinesOfCodeInlineComments(t *testing.T) {
+	for _, test := range []struct {
+		language string
+		contents string
+		expected lineCounts
+	}{
+		// code before, after or between comments makes a line of code
+		{"java", "/* a */ int b;\n", lineCounts{code: 1}},
+		{"java", "int a; /* b */\n", lineCounts{code: 1}},
+		{"java", "/* a */ int b; /* c */\n", lineCounts{code: 1}},
+		{"java", "/* a */ /* b */\n", lineCounts{comment: 1}},
+		{"java", "/* a */ // b\n", lineCounts{comment: 1}},
+		// a block comment opened after code or after another one on the same line spans the next lines
+		{"java", "int a; /* b\nc\n*/\n", lineCounts{code: 1, comment: 2}},
+		{"java", "/* a */ /* b\nc */\nint d;\n", lineCounts{code: 1, comment: 2}},
+		{"java", "/* a\nb */ int c;\n", lineCounts{code: 1, comment: 1}},
+		// comment markers in strings are not comments
+		{"csharp", "var url = \"http://example.com\"; /* a\nb */\n", lineCounts{code: 1, comment: 1}},
+		{"node", "const a = \"/*\";\nconst b = 1;\n", lineCounts{code: 2}},
+		{"node", "const a = '\\'/*';\nconst b = 1;\n", lineCounts{code: 2}},
+		{"node", "const a = `\n// not a comment\n\n`;\n", lineCounts{code: 4}},
+		{"go", "s := `/*`\nt := 1\n", lineCounts{code: 2}},
+		// nested block comments end with their outermost delimiter, where the language nests them
+		{"haskell", "{- a {- b -}\nc -}\nd = 1\n", lineCounts{code: 1, comment: 2}},
+		{"rust", "/* a /* b */\nc */\nfn d() {}\n", lineCounts{code: 1, comment: 2}},
+		{"java", "/* a /* b */\nint c;\n", lineCounts{code: 1, comment: 1}},
+		// docstrings start a line, assigned strings are code
+		{"python", "x = \"\"\"a\nb\"\"\"\n", lineCounts{code: 2}},
+		{"python", "    \"\"\"a\n    b\"\"\"\n", lineCounts{comment: 2}},
+	} {
+		require.Equal(t, test.expected, countLinesOfCode([]byte(test.contents), test.language), "%v: %q", test.language, test.contents)
+	}
+}
+
+func TestSnapshotWithIndexLinesOfCode(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"src/A.java": "class A {\n// comment\n}\n",
+		"README.md":  "# readme\n",
+	})
+	defer os.RemoveAll(clonePath)
+	outputPath, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(outputPath)
+	indexFilePath := filepath.Join(outputPath, "__index.tsv")
+
+	err = Snapshot(&options.Options{
+		ClonePath:             clonePath,
+		Revision:              revision,
+		OutputPath:            outputPath,
+		IncludePatterns:       []string{},
+		ExcludePatterns:       []string{},
+		OptionalIndexFilePath: indexFilePath,
+		IndexOnly:             true,
+		IndexLinesOfCode:      true,
+	})
+	require.Nil(t, err)
+
+	file, err := os.Open(indexFilePath)
+	require.Nil(t, err)
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comma = '\t'
+	records, err := reader.ReadAll()
+	require.Nil(t, err)
+
+	linesOfCode := map[string]string{}
+	for _, record := range records {
+		require.Len(t, record, 4)
+		linesOfCode[record[0]] = record[3]
+	}
+	require.Equal(t, "LinesOfCode", linesOfCode["Path"])
+	require.Equal(t, "2", linesOfCode["src/A.java"])
+	require.Equal(t, "", linesOfCode["README.md"])
+	require.Equal(t, "", linesOfCode["src"])
+}
+
+func TestSnapshotWithStats(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"src/A.java":        "class A {\n// comment\n\n}\n",
+		"src/B.java":        "class B {}\n",
+		"run.sh":            "# comment\necho a\n",
+		"README.md":         "# readme\n",
+		"node_modules/x.js": "var x;\n",
+	})
+	defer os.RemoveAll(clonePath)
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+
+	err := Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		StatsPath:       statsPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{"**/node_modules/**"},
+	})
+	require.Nil(t, err)
+
+	contents, err := os.ReadFile(statsPath)
+	require.Nil(t, err)
+	codeStats := &stats.CodeStats{}
+	require.Nil(t, json.Unmarshal(contents, codeStats))
+
+	require.Equal(t, map[string]*stats.LanguageStats{
+		"java":  {NumberOfFiles: 2, LinesOfCode: 3, CommentLines: 1, BlankLines: 1, SizeBytes: 35},
+		"shell": {NumberOfFiles: 1, LinesOfCode: 1, CommentLines: 1, SizeBytes: 17},
+	}, codeStats.CountersByLanguage)
+	require.Equal(t, float64(4), codeStats.TotalLinesOfCode)
+	require.Contains(t, string(contents), `"totalLinesOfCode": 4`)
+	require.Equal(t, float64(0), codeStats.SnapshotSizeInMb)
+
+	detailsPath := filepath.Join(t.TempDir(), "stats.jsonl")
+	err = Snapshot(&options.Options{
+		ClonePath:        clonePath,
+		Revision:         revision,
+		StatsPath:        statsPath,
+		StatsDetailsPath: detailsPath,
+		IncludePatterns:  []string{},
+		ExcludePatterns:  []string{"**/node_modules/**"},
+	})
+	require.Nil(t, err)
+
+	details, err := os.ReadFile(detailsPath)
+	require.Nil(t, err)
+	var records []stats.FileStats
+	for _, line := range strings.Split(strings.TrimSpace(string(details)), "\n") {
+		record := stats.FileStats{}
+		require.Nil(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	require.Equal(t, []stats.FileStats{
+		{Path: "run.sh", Language: "shell", LinesOfCode: 1, SizeBytes: 17},
+		{Path: "src/A.java", Language: "java", LinesOfCode: 2, SizeBytes: 24},
+		{Path: "src/B.java", Language: "java", LinesOfCode: 1, SizeBytes: 11},
+	}, records)
+}
+
+func TestSnapshotWithStatsDetectShebang(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"bin/deploy": "#!/usr/bin/env bash\n# deploy\nset -e\necho deploy\n",
+		"bin/tool":   "#!/usr/bin/python3\nprint('tool')\n",
+		"Makefile":   "all:\n\techo all\n",
+	})
+	defer os.RemoveAll(clonePath)
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+
+	for _, detectShebang := range []bool{false, true} {
+		err := Snapshot(&options.Options{
+			ClonePath:          clonePath,
+			Revision:           revision,
+			StatsPath:          statsPath,
+			StatsDetectShebang: detectShebang,
+			IncludePatterns:    []string{},
+			ExcludePatterns:    []string{},
+		})
+		require.Nil(t, err)
+
+		contents, err := os.ReadFile(statsPath)
+		require.Nil(t, err)
+		codeStats := &stats.CodeStats{}
+		require.Nil(t, json.Unmarshal(contents, codeStats))
+
+		if !detectShebang {
+			require.Empty(t, codeStats.CountersByLanguage)
+			continue
+		}
+		require.Equal(t, map[string]*stats.LanguageStats{
+			"shell":  {NumberOfFiles: 1, LinesOfCode: 2, CommentLines: 2, SizeBytes: 48},
+			"python": {NumberOfFiles: 1, LinesOfCode: 1, CommentLines: 1, SizeBytes: 33},
+		}, codeStats.CountersByLanguage)
+	}
+}
+
+func TestSnapshotWithStatsMaxSize(t *testing.T) {
+	files := map[string]string{
+		"src/Small.java": "class Small {}\n",
+		"src/Large.java": "class Large {\n" + strings.Repeat("int x;\n", 10) + "}\n",
+	}
+	clonePath, revision := createLocalRepo(files)
+	defer os.RemoveAll(clonePath)
+	dirPath := t.TempDir()
+	writeFiles(dirPath, files)
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+
+	// --max-size caps the stats of a revision and of a directory alike
+	for _, opts := range []options.Options{
+		{ClonePath: clonePath, Revision: revision},
+		{StatsDir: dirPath},
+	} {
+		opts := opts
+		opts.StatsPath = statsPath
+		opts.MaxFileSizeBytes = 20
+		opts.IncludePatterns = []string{}
+		opts.ExcludePatterns = []string{}
+		require.Nil(t, Snapshot(&opts))
+
+		contents, err := os.ReadFile(statsPath)
+		require.Nil(t, err)
+		codeStats := &stats.CodeStats{}
+		require.Nil(t, json.Unmarshal(contents, codeStats))
+		require.Equal(t, map[string]*stats.LanguageStats{
+			"java": {NumberOfFiles: 1, LinesOfCode: 1, SizeBytes: 15},
+		}, codeStats.CountersByLanguage)
+	}
+}
+
+func TestSnapshotWithStatsFormatCSV(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"src/A.java": "class A {\n// comment\n\n}\n",
+		"src/B.java": "class B {}\n",
+		"run.sh":     "# comment\necho a\n",
+		"README.md":  "# readme\n",
+	})
+	defer os.RemoveAll(clonePath)
+	statsPath := filepath.Join(t.TempDir(), "stats.csv")
+
+	opts, err := options.ParseStatsArgs([]string{"--src", clonePath, "--rev", revision, "--out", statsPath, "--stats-format", "csv"})
+	require.Nil(t, err)
+	require.Nil(t, Snapshot(opts))
+
+	statsFile, err := os.Open(statsPath)
+	require.Nil(t, err)
+	defer statsFile.Close()
+	rows, err := csv.NewReader(statsFile).ReadAll()
+	require.Nil(t, err)
+	// a header, a row for each of the 2 languages and the totals
+	require.Len(t, rows, 2+2)
+	require.Equal(t, []string{"language", "numberOfFiles", "linesOfCode", "sizeBytes"}, rows[0])
+	require.Equal(t, []string{"java", "2", "3", "35"}, rows[1])
+	require.Equal(t, []string{"shell", "1", "1", "17"}, rows[2])
+	require.Equal(t, []string{stats.TOTAL_ROW, "3", "4", "52"}, rows[3])
+
+	_, err = options.ParseStatsArgs([]string{"--src", clonePath, "--rev", revision, "--out", statsPath, "--stats-format", "xml"})
+	require.NotNil(t, err)
+}
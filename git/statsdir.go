@@ -0,0 +1,119 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"gitsnap/stats"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+)
+
+const (
+	GIT_DIRECTORY_NAME = ".git"
+)
+
+// collectDirectoryStats counts the files under a directory, such as an extracted snapshot, like collectStats counts
+// those of a commit. the path, size and text filters apply as they do to tree entries. .git directories and symbolic
+// links are skipped, since a snapshot never writes them unless asked to.
+func (provider *repositoryProvider) collectDirectoryStats(dirPath string, details io.Writer) (*stats.CodeStats, error) {
+	var detailsEncoder *json.Encoder = nil
+	if details != nil {
+		detailsEncoder = json.NewEncoder(details)
+	}
+
+	codeStats := stats.NewCodeStats()
+	err := filepath.WalkDir(dirPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctxErr := provider.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if entry.IsDir() {
+			if entry.Name() == GIT_DIRECTORY_NAME {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relativePath, err := filepath.Rel(dirPath, filePath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relativePath)
+
+		size, selected, err := provider.selectDirectoryFile(filePath, name, entry)
+		if err != nil || !selected {
+			return err
+		}
+		return provider.addFileStats(codeStats, detailsEncoder, name, size,
+			func() (string, error) { return readFileFirstLine(filePath) },
+			func() ([]byte, error) { return os.ReadFile(filePath) })
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk '%v': %v", dirPath, err)
+	}
+
+	codeStats.Finalize()
+	codeStats.KeepTopLanguages(provider.opts.StatsTopLanguages)
+	return codeStats, nil
+}
+
+// selectDirectoryFile applies the rules of selectFile which don't need git to a file of the directory
+func (provider *repositoryProvider) selectDirectoryFile(filePath string, name string, entry fs.DirEntry) (int64, bool, error) {
+	if !entry.Type().IsRegular() {
+		provider.verboseLog("--- skipping '%v' - not regular file - mode: %v", name, entry.Type())
+		return 0, false, nil
+	}
+	if !provider.matchesFilters(name, filemode.Regular) {
+		return 0, false, nil
+	}
+
+	info, err := entry.Info()
+	if err != nil {
+		return 0, false, err
+	}
+	if provider.exceedsLimits(name, info.Size()) {
+		return 0, false, nil
+	}
+
+	if provider.needsContentTextCheck(name) {
+		binary, err := isBinaryFile(filePath)
+		if err != nil {
+			return 0, false, err
+		}
+		if binary {
+			provider.verboseLog("--- skipping '%v' - binary content", name)
+			return 0, false, nil
+		}
+	}
+	return info.Size(), true, nil
+}
+
+func readFileFirstLine(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return readLineFrom(file)
+}
+
+// isBinaryFile checks the head of the file like isBinaryContent checks that of a blob
+func isBinaryFile(filePath string) (bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	sample := make([]byte, TEXT_DETECT_SAMPLE_SIZE)
+	n, err := io.ReadFull(file, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return isBinarySample(sample[:n], n == TEXT_DETECT_SAMPLE_SIZE), nil
+}
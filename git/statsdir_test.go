@@ -0,0 +1,51 @@
+package git
+
+import (
+	"encoding/json"
+	"gitsnap/options"
+	"gitsnap/stats"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsOfDirectory(t *testing.T) {
+	files := map[string]string{
+		"src/A.java":        "class A {\n// comment\n\n}\n",
+		"src/B.java":        "class B {}\n",
+		"scripts/deploy":    "#!/usr/bin/env bash\n# deploy\nset -e\necho deploy\n",
+		"README.md":         "# readme\n",
+		"node_modules/x.js": "var x;\n",
+		"logo.png":          "\x89PNG\x00\x00",
+	}
+	clonePath, revision := createLocalRepo(files)
+	defer os.RemoveAll(clonePath)
+	dirPath := t.TempDir()
+	writeFiles(dirPath, files)
+	writeFiles(dirPath, map[string]string{".git/config": "[core]\n", ".git/hooks/pre-commit.sh": "echo hook\n"})
+	require.Nil(t, os.Symlink("src/B.java", filepath.Join(dirPath, "link.java")))
+
+	collect := func(args ...string) *stats.CodeStats {
+		statsPath := filepath.Join(t.TempDir(), "stats.json")
+		opts, err := options.ParseStatsArgs(append(args, "--out", statsPath, "--stats-detect-shebang", "--exclude", "**/node_modules/**"))
+		require.Nil(t, err)
+		require.Nil(t, Snapshot(opts))
+		contents, err := os.ReadFile(statsPath)
+		require.Nil(t, err)
+		codeStats := &stats.CodeStats{}
+		require.Nil(t, json.Unmarshal(contents, codeStats))
+		return codeStats
+	}
+
+	// the files of the directory are selected and counted like those of the revision
+	revisionStats := collect("--src", clonePath, "--rev", revision)
+	require.Equal(t, map[string]*stats.LanguageStats{
+		"java":  {NumberOfFiles: 2, LinesOfCode: 3, CommentLines: 1, BlankLines: 1, SizeBytes: 35},
+		"shell": {NumberOfFiles: 1, LinesOfCode: 2, CommentLines: 2, SizeBytes: 48},
+	}, revisionStats.CountersByLanguage)
+	require.Equal(t, revisionStats, collect("--dir", dirPath))
+	// including the checkout of the revision, whose .git directory is skipped
+	require.Equal(t, revisionStats, collect("--dir", clonePath))
+}
@@ -0,0 +1,66 @@
+package git
+
+import (
+	"gitsnap/options"
+	"gitsnap/util"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/helper/mount"
+	"github.com/go-git/go-billy/v5/helper/polyfill"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+)
+
+const OBJECTS_DIR_NAME = "objects"
+
+// openClone opens the repository of a clone. the objects and refs of a linked worktree are in the
+// common git directory of the repository it was added from. --git-dir and --objects-dir replace
+// the git directory of the clone and the object store within it.
+func openClone(opts *options.Options) (*git.Repository, error) {
+	if opts.GitDir == "" && opts.ObjectsDir == "" {
+		return git.PlainOpenWithOptions(opts.ClonePath, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+	}
+
+	gitDir := opts.GitDir
+	if gitDir == "" {
+		var err error
+		gitDir, err = util.ResolveGitDir(opts.ClonePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var storageFs billy.Filesystem = osfs.New(gitDir)
+	if commonDir := util.CommonDirOf(gitDir); commonDir != gitDir {
+		storageFs = dotgit.NewRepositoryFilesystem(storageFs, osfs.New(commonDir))
+	}
+	if opts.ObjectsDir != "" {
+		storageFs = &objectsDirFilesystem{
+			Filesystem: polyfill.New(mount.New(storageFs, OBJECTS_DIR_NAME, osfs.New(opts.ObjectsDir))),
+			root:       filepath.Dir(filepath.Clean(opts.ObjectsDir)),
+		}
+	}
+	return git.Open(filesystem.NewStorage(storageFs, cache.NewObjectLRUDefault()), nil)
+}
+
+// objectsDirFilesystem is a git directory with its objects directory mounted from elsewhere. go-git resolves
+// relative info/alternates paths against the root, which is the parent of the objects directory like in a clone.
+type objectsDirFilesystem struct {
+	billy.Filesystem
+	root string
+}
+
+func (fs *objectsDirFilesystem) Root() string {
+	return fs.root
+}
+
+// commonGitDir returns the git directory holding the LFS cache and the modules of the clone
+func (provider *repositoryProvider) commonGitDir() string {
+	if provider.opts.GitDir != "" {
+		return util.CommonDirOf(provider.opts.GitDir)
+	}
+	return util.CommonGitDir(provider.opts.ClonePath)
+}
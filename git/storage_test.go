@@ -0,0 +1,47 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithGitDirAndObjectsDir(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"main.go": "package main",
+	})
+	defer os.RemoveAll(clonePath)
+
+	snapshot := func(clonePath string, gitDir string, objectsDir string) (string, error) {
+		require.Nil(t, options.ValidateSource(clonePath, gitDir, objectsDir))
+		outputPath := t.TempDir()
+		return outputPath, Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			GitDir:          gitDir,
+			ObjectsDir:      objectsDir,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+		})
+	}
+
+	// the clone needs no .git of its own
+	outputPath, err := snapshot(t.TempDir(), filepath.Join(clonePath, ".git"), "")
+	require.Nil(t, err)
+	requireFileContents(t, filepath.Join(outputPath, "main.go"), "package main")
+
+	// objects moved to a shared cache are found only by the override
+	objectsDir := filepath.Join(t.TempDir(), "objects")
+	require.Nil(t, os.Rename(filepath.Join(clonePath, ".git", "objects"), objectsDir))
+	require.Nil(t, os.Mkdir(filepath.Join(clonePath, ".git", "objects"), 0755))
+	_, err = snapshot(clonePath, "", "")
+	require.NotNil(t, err)
+
+	outputPath, err = snapshot(clonePath, "", objectsDir)
+	require.Nil(t, err)
+	requireFileContents(t, filepath.Join(outputPath, "main.go"), "package main")
+}
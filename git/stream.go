@@ -0,0 +1,90 @@
+package git
+
+import (
+	"fmt"
+	"gitsnap/options"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/avast/retry-go"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// files of at least this size are copied from their blob into the target file, instead of being read into memory
+const STREAM_MIN_SIZE = 1024 * 1024
+
+// streamsContents checks whether a file is written by streamTargetFile. files whose contents are needed for
+// anything but writing them, such as counting lines, resolving LFS, transcoding them or a sha256 digest, are read.
+func (provider *repositoryProvider) streamsContents(filePath string, file *object.File, indexOnly bool, countLines bool) bool {
+	return file.Size >= STREAM_MIN_SIZE &&
+		!indexOnly &&
+		!countLines &&
+		provider.archive == nil &&
+		!provider.opts.ResolveLFS &&
+		!provider.stripsByteOrderMark(filePath) &&
+		!provider.transcodesContents(filePath) &&
+		provider.opts.HashAlgorithm != options.HASH_ALGO_SHA256 &&
+		!provider.isSymlink(filePath, file.Mode)
+}
+
+// streamTargetFile writes a file by copying its blob into it. the object storage is not safe for concurrent use, so
+// it is locked while reading each chunk of the blob, and released while the chunk is written.
+func (provider *repositoryProvider) streamTargetFile(outputPath string, targetFilePath string, file *object.File, perm os.FileMode) error {
+	return provider.writeReplacingConflicts(outputPath, targetFilePath, func() error {
+		targetDirectoryPath := filepath.Dir(targetFilePath)
+		err := os.MkdirAll(targetDirectoryPath, TARGET_DIRECTORY_PERMISSIONS)
+		if err != nil {
+			return fmt.Errorf("failed to create target directory at '%v': %w", targetDirectoryPath, err)
+		}
+
+		return retry.Do(
+			func() error {
+				return copyBlob(file, targetFilePath, perm, &provider.storeMutex)
+			},
+			provider.readRetryOptions(file.Name)...,
+		)
+	})
+}
+
+// lockedReader reads from the object storage, holding its lock only during each read
+type lockedReader struct {
+	reader io.ReadCloser
+	mutex  *sync.Mutex
+}
+
+func (reader *lockedReader) Read(p []byte) (int, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	return reader.reader.Read(p)
+}
+
+func (reader *lockedReader) Close() error {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	return reader.reader.Close()
+}
+
+// copyBlob copies the contents of a file into the target file, replacing what it held, like os.WriteFile
+func copyBlob(file *object.File, targetFilePath string, perm os.FileMode, storeMutex *sync.Mutex) error {
+	storeMutex.Lock()
+	blobReader, err := file.Reader()
+	storeMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to get git file contents for '%v': %v", file.Name, err)
+	}
+	reader := &lockedReader{reader: blobReader, mutex: storeMutex}
+	defer reader.Close()
+
+	target, err := os.OpenFile(targetFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(target, reader)
+	closeErr := target.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
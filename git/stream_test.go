@@ -0,0 +1,70 @@
+package git
+
+import (
+	"fmt"
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotStreamsLargeFiles(t *testing.T) {
+	large := strings.Repeat("0123456789abcdef\n", STREAM_MIN_SIZE/16)
+	clonePath, revision := createLocalRepo(map[string]string{
+		"large.txt":     large,
+		"dir/large.txt": large + "more",
+		"small.txt":     "small",
+	})
+	defer os.RemoveAll(clonePath)
+
+	outputPath := t.TempDir()
+	// a file left by a previous snapshot is replaced
+	require.Nil(t, os.WriteFile(filepath.Join(outputPath, "large.txt"), []byte(strings.Repeat("x", 2*len(large))), 0644))
+	result, err := SnapshotWithResult(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      outputPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+		Workers:         4,
+	})
+	require.Nil(t, err)
+	require.Equal(t, 3, result.FilesWritten)
+	requireFileContents(t, filepath.Join(outputPath, "large.txt"), large)
+	requireFileContents(t, filepath.Join(outputPath, "dir", "large.txt"), large+"more")
+	requireFileContents(t, filepath.Join(outputPath, "small.txt"), "small")
+}
+
+func TestSnapshotWithMaxInflightBytes(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("small%v.txt", i)] = fmt.Sprintf("small %v", i)
+	}
+	for i := 0; i < 4; i++ {
+		files[fmt.Sprintf("large%v.txt", i)] = strings.Repeat(fmt.Sprintf("%v", i), 1000)
+	}
+	// a copy, which waits for the first to be written while holding its own weight
+	files["copy.txt"] = files["large0.txt"]
+	clonePath, revision := createLocalRepo(files)
+	defer os.RemoveAll(clonePath)
+
+	outputPath := t.TempDir()
+	result, err := SnapshotWithResult(&options.Options{
+		ClonePath:        clonePath,
+		Revision:         revision,
+		OutputPath:       outputPath,
+		IncludePatterns:  []string{},
+		ExcludePatterns:  []string{},
+		Workers:          8,
+		MaxInflightBytes: 1500,
+		Dedup:            true,
+	})
+	require.Nil(t, err)
+	require.Equal(t, len(files), result.FilesWritten)
+	for filePath, contents := range files {
+		requireFileContents(t, filepath.Join(outputPath, filePath), contents)
+	}
+}
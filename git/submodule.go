@@ -0,0 +1,215 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"gitsnap/util"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const GITMODULES_FILE_NAME = ".gitmodules"
+
+// snapshotWalker walks the snapshot tree like object.TreeWalker. with --recurse-submodules it descends into the
+// recorded commit of every initialized submodule right after its gitlink entry, naming its entries by the submodule path.
+type snapshotWalker struct {
+	provider *repositoryProvider
+	frames   []*walkerFrame
+}
+
+type walkerFrame struct {
+	walker *object.TreeWalker
+	commit *object.Commit
+	// prefix of the entry names, empty for the snapshot tree
+	prefix string
+	gitDir string
+	// working tree of the repository, empty for submodules
+	workTree string
+	modules  *config.Modules
+}
+
+func (provider *repositoryProvider) newSnapshotWalker(commit *object.Commit, tree *object.Tree) *snapshotWalker {
+	workTree := provider.opts.ClonePath
+	// a bare repository has no working tree
+	if gitDir, err := util.ResolveGitDir(workTree); provider.opts.GitDir == "" && err == nil && gitDir == workTree {
+		workTree = ""
+	}
+	return &snapshotWalker{
+		provider: provider,
+		frames: []*walkerFrame{{
+			walker:   object.NewTreeWalker(tree, true, nil),
+			commit:   commit,
+			gitDir:   provider.commonGitDir(),
+			workTree: workTree,
+		}},
+	}
+}
+
+func (walker *snapshotWalker) Next() (string, object.TreeEntry, error) {
+	// every walk of the tree stops once the context of the provider is done
+	if err := walker.provider.ctx.Err(); err != nil {
+		return "", object.TreeEntry{}, err
+	}
+	for len(walker.frames) > 0 {
+		frame := walker.frames[len(walker.frames)-1]
+		name, entry, err := frame.walker.Next()
+		if err == io.EOF {
+			frame.walker.Close()
+			walker.frames = walker.frames[:len(walker.frames)-1]
+			continue
+		}
+		if err != nil {
+			return "", entry, err
+		}
+
+		fullName := path.Join(frame.prefix, name)
+		if entry.Mode == filemode.Submodule && walker.provider.opts.RecurseSubmodules {
+			err = walker.enterSubmodule(frame, name, fullName, entry.Hash)
+			if err != nil {
+				return "", entry, err
+			}
+		}
+		return fullName, entry, nil
+	}
+	return "", object.TreeEntry{}, io.EOF
+}
+
+func (walker *snapshotWalker) Close() {
+	for _, frame := range walker.frames {
+		frame.walker.Close()
+	}
+	walker.frames = nil
+}
+
+func (walker *snapshotWalker) enterSubmodule(frame *walkerFrame, name string, fullName string, hash plumbing.Hash) error {
+	provider := walker.provider
+	submodulePath := name
+	if frame.prefix == "" {
+		submodulePath = provider.repositoryPath(name)
+	}
+
+	repository, gitDir, err := provider.openSubmodule(frame, submodulePath)
+	if err != nil {
+		return err
+	}
+	if repository == nil {
+		provider.logger.Infof("--- skipping submodule '%v' - it is not initialized in the clone (run git submodule update --init?)", fullName)
+		return nil
+	}
+
+	commit, err := repository.CommitObject(hash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		provider.logger.Infof("--- skipping submodule '%v' - its commit %v is missing from '%v' (run git submodule update?)", fullName, hash, gitDir)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get commit %v of submodule '%v': %v", hash, fullName, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("failed to get tree of commit %v of submodule '%v': %v", hash, fullName, err)
+	}
+
+	provider.verboseLog("*** recursing into submodule '%v' at %v", fullName, hash)
+	walker.frames = append(walker.frames, &walkerFrame{
+		walker: object.NewTreeWalker(tree, true, nil),
+		commit: commit,
+		prefix: fullName,
+		gitDir: gitDir,
+	})
+	return nil
+}
+
+// openSubmodule opens the repository of a submodule from the modules of its parent, or from its own .git directory
+// in the working tree. a nil repository means the submodule is not initialized.
+func (provider *repositoryProvider) openSubmodule(frame *walkerFrame, submodulePath string) (*git.Repository, string, error) {
+	name, err := frame.submoduleName(submodulePath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	candidates := []string{filepath.Join(frame.gitDir, "modules", filepath.FromSlash(name))}
+	if frame.workTree != "" {
+		candidates = append(candidates, filepath.Join(frame.workTree, filepath.FromSlash(submodulePath), git.GitDirName))
+	}
+
+	provider.submodulesMutex.Lock()
+	defer provider.submodulesMutex.Unlock()
+
+	for _, gitDir := range candidates {
+		if repository, found := provider.submodules[gitDir]; found {
+			return repository, gitDir, nil
+		}
+		// a .git file points to the modules of the parent, which were checked already
+		info, err := os.Stat(gitDir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		repository, err := git.PlainOpen(gitDir)
+		if err != nil {
+			provider.verboseLog("--- failed to open submodule '%v' at '%v': %v", submodulePath, gitDir, err)
+			continue
+		}
+		if provider.submodules == nil {
+			provider.submodules = map[string]*git.Repository{}
+		}
+		provider.submodules[gitDir] = repository
+		return repository, gitDir, nil
+	}
+	return nil, "", nil
+}
+
+// submoduleName returns the name of the submodule at the path, by the .gitmodules file of the commit,
+// which names its directory under .git/modules. the path is used when no entry is found.
+func (frame *walkerFrame) submoduleName(submodulePath string) (string, error) {
+	if frame.modules == nil {
+		frame.modules = config.NewModules()
+		file, err := frame.commit.File(GITMODULES_FILE_NAME)
+		if err != nil && !errors.Is(err, object.ErrFileNotFound) {
+			return "", fmt.Errorf("failed to get %v of %v: %v", GITMODULES_FILE_NAME, frame.commit.Hash, err)
+		}
+		if file != nil {
+			contents, err := file.Contents()
+			if err != nil {
+				return "", fmt.Errorf("failed to read %v of %v: %v", GITMODULES_FILE_NAME, frame.commit.Hash, err)
+			}
+			err = frame.modules.Unmarshal([]byte(contents))
+			if err != nil {
+				return "", fmt.Errorf("failed to parse %v of %v: %v", GITMODULES_FILE_NAME, frame.commit.Hash, err)
+			}
+		}
+	}
+	for _, submodule := range frame.modules.Submodules {
+		if submodule.Path == submodulePath {
+			return submodule.Name, nil
+		}
+	}
+	return submodulePath, nil
+}
+
+// storedBlob gets a blob from the clone, or from one of the opened submodules. blob ids are content
+// addresses, so a blob found in any of them has the requested contents.
+func (provider *repositoryProvider) storedBlob(hash plumbing.Hash) (*object.Blob, error) {
+	blob, err := object.GetBlob(provider.repository.Storer, hash)
+	if !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return blob, err
+	}
+
+	provider.submodulesMutex.Lock()
+	defer provider.submodulesMutex.Unlock()
+	for _, repository := range provider.submodules {
+		submoduleBlob, submoduleErr := object.GetBlob(repository.Storer, hash)
+		if submoduleErr == nil {
+			return submoduleBlob, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,53 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithRecurseSubmodules(t *testing.T) {
+	submodulePath, submoduleRevision := createLocalRepo(map[string]string{
+		"lib.go":         "package lib",
+		"docs/readme.md": "# lib",
+	})
+	defer os.RemoveAll(submodulePath)
+	clonePath, _ := createLocalRepo(map[string]string{
+		"main.go": "package main",
+	})
+	defer os.RemoveAll(clonePath)
+	// named differently than its path, so it is found under .git/modules by the name in .gitmodules
+	runGit(clonePath, "-c", "protocol.file.allow=always", "submodule", "add", "-q", "--name", "vendored", submodulePath, "libs/lib")
+	// a gitlink of a submodule which was never initialized
+	runGit(clonePath, "update-index", "--add", "--cacheinfo", "160000,"+submoduleRevision+",libs/missing")
+	runGit(clonePath, "commit", "-q", "-m", "submodules")
+	revision := runGit(clonePath, "rev-parse", "HEAD")
+	require.DirExists(t, filepath.Join(clonePath, ".git", "modules", "vendored"))
+
+	for _, recurseSubmodules := range []bool{false, true} {
+		outputPath := t.TempDir()
+		err := Snapshot(&options.Options{
+			ClonePath:         clonePath,
+			Revision:          revision,
+			OutputPath:        outputPath,
+			IncludePatterns:   []string{},
+			ExcludePatterns:   []string{"**/*.md"},
+			RecurseSubmodules: recurseSubmodules,
+		})
+		require.Nil(t, err)
+
+		require.FileExists(t, filepath.Join(outputPath, "main.go"))
+		require.NoFileExists(t, filepath.Join(outputPath, "libs", "lib", "docs", "readme.md"))
+		require.NoDirExists(t, filepath.Join(outputPath, "libs", "missing"))
+		if !recurseSubmodules {
+			require.NoDirExists(t, filepath.Join(outputPath, "libs"))
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join(outputPath, "libs", "lib", "lib.go"))
+		require.Nil(t, err)
+		require.Equal(t, "package lib", string(contents))
+	}
+}
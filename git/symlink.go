@@ -0,0 +1,132 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"gitsnap/options"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// like the limit of the linux kernel on nested symbolic links
+const MAX_SYMLINK_DEPTH = 40
+
+func (provider *repositoryProvider) keepsSymlinks() bool {
+	return provider.opts.Symlinks == options.SYMLINKS_FOLLOW || provider.opts.Symlinks == options.SYMLINKS_RECREATE
+}
+
+// resolveSymlinkTarget returns the path a symbolic link at linkPath points to, relative to the same root.
+// an empty result means the target is absolute or escapes the root.
+func resolveSymlinkTarget(linkPath string, target string) string {
+	if target == "" || path.IsAbs(target) || filepath.IsAbs(target) {
+		return ""
+	}
+	resolved := path.Join(path.Dir(linkPath), target)
+	if resolved == "." || resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return ""
+	}
+	return resolved
+}
+
+func (provider *repositoryProvider) readSymlinkTarget(entry *object.TreeEntry) (string, error) {
+	blob, err := provider.getBlob(entry.Hash)
+	if err != nil {
+		return "", err
+	}
+	contents, err := provider.readContents(object.NewFile(entry.Name, entry.Mode, blob))
+	if err != nil {
+		return "", err
+	}
+	return string(contents), nil
+}
+
+// selectSymlink returns the tree entry to write for a symbolic link, by --symlinks. a nil entry means the link is skipped.
+func (provider *repositoryProvider) selectSymlink(filePath string, entry *object.TreeEntry) (*object.TreeEntry, error) {
+	if provider.opts.Symlinks == options.SYMLINKS_FOLLOW {
+		return provider.followSymlink(filePath, entry)
+	}
+
+	target, err := provider.readSymlinkTarget(entry)
+	if err != nil {
+		return nil, err
+	}
+	// the output root is the snapshot root, so the link is resolved against its path relative to it
+	if resolveSymlinkTarget(filePath, target) == "" {
+		provider.logger.Infof("--- skipping '%v' - symbolic link target '%v' is outside the output path", filePath, target)
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// followSymlink returns the entry of the regular file a symbolic link eventually points to in the tree of the commit
+func (provider *repositoryProvider) followSymlink(filePath string, entry *object.TreeEntry) (*object.TreeEntry, error) {
+	linkPath := provider.repositoryPath(filePath)
+	for depth := 0; depth < MAX_SYMLINK_DEPTH; depth++ {
+		target, err := provider.readSymlinkTarget(entry)
+		if err != nil {
+			return nil, err
+		}
+		targetPath := resolveSymlinkTarget(linkPath, target)
+		if targetPath == "" {
+			provider.logger.Infof("--- skipping '%v' - symbolic link target '%v' is outside the tree", filePath, target)
+			return nil, nil
+		}
+
+		provider.storeMutex.Lock()
+		targetEntry, err := findTreeEntry(provider.commit, targetPath)
+		provider.storeMutex.Unlock()
+		if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+			provider.logger.Infof("--- skipping '%v' - symbolic link target '%v' is not in the tree", filePath, target)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up symbolic link target '%v' of '%v': %v", target, filePath, err)
+		}
+		if !targetEntry.Mode.IsFile() {
+			provider.verboseLog("--- skipping '%v' - symbolic link target '%v' is not a file - mode: %v", filePath, target, targetEntry.Mode)
+			return nil, nil
+		}
+		if !provider.isSymlink(targetPath, targetEntry.Mode) {
+			provider.verboseLog("*** following '%v' to '%v'", filePath, targetPath)
+			return targetEntry, nil
+		}
+		linkPath, entry = targetPath, targetEntry
+	}
+	provider.logger.Infof("--- skipping '%v' - more than %v levels of symbolic links", filePath, MAX_SYMLINK_DEPTH)
+	return nil, nil
+}
+
+func findTreeEntry(commit *object.Commit, entryPath string) (*object.TreeEntry, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return tree.FindEntry(entryPath)
+}
+
+func (provider *repositoryProvider) writeTargetSymlink(outputPath string, targetFilePath string, target string) error {
+	return provider.writeReplacingConflicts(outputPath, targetFilePath, func() error {
+		return writeSymlinkCreatingDirs(targetFilePath, target)
+	})
+}
+
+func writeSymlinkCreatingDirs(targetFilePath string, target string) error {
+	targetDirectoryPath := filepath.Dir(targetFilePath)
+	err := os.MkdirAll(targetDirectoryPath, TARGET_DIRECTORY_PERMISSIONS)
+	if err != nil {
+		return fmt.Errorf("failed to create target directory at '%v': %w", targetDirectoryPath, err)
+	}
+	// like os.WriteFile, an existing file is replaced
+	info, err := os.Lstat(targetFilePath)
+	if err == nil && !info.IsDir() {
+		err = os.Remove(targetFilePath)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Symlink(filepath.FromSlash(target), targetFilePath)
+}
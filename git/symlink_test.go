@@ -0,0 +1,128 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSymlinkTarget(t *testing.T) {
+	require.Equal(t, "target.txt", resolveSymlinkTarget("link.txt", "target.txt"))
+	require.Equal(t, "target.txt", resolveSymlinkTarget("dir/link.txt", "../target.txt"))
+	require.Equal(t, "dir/other/target.txt", resolveSymlinkTarget("dir/link.txt", "./other/target.txt"))
+	require.Equal(t, "", resolveSymlinkTarget("dir/link.txt", "../../target.txt"))
+	require.Equal(t, "", resolveSymlinkTarget("link.txt", "/etc/passwd"))
+	require.Equal(t, "", resolveSymlinkTarget("dir/link.txt", ".."))
+	require.Equal(t, "", resolveSymlinkTarget("link.txt", ""))
+}
+
+func createRepoWithSymlinks(t *testing.T) (clonePath string, revision string) {
+	clonePath, _ = createLocalRepo(map[string]string{
+		"target.txt":   "target",
+		"dir/keep.txt": "keep",
+	})
+	for link, target := range map[string]string{
+		"link.txt":     "target.txt",
+		"chain.txt":    "link.txt",
+		"dir/up.txt":   "../target.txt",
+		"escape.txt":   "../../etc/passwd",
+		"absolute.txt": "/etc/passwd",
+		"dangling.txt": "missing.txt",
+		"dirlink":      "dir",
+	} {
+		require.Nil(t, os.Symlink(target, filepath.Join(clonePath, link)))
+	}
+	revision = commitFiles(clonePath, map[string]string{}, "tester <tester@example.com>")
+	return clonePath, revision
+}
+
+func snapshotSymlinks(t *testing.T, clonePath string, revision string, symlinks string, subtree string) string {
+	outputPath := t.TempDir()
+	err := Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      outputPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+		Symlinks:        symlinks,
+		Subtree:         subtree,
+	})
+	require.Nil(t, err)
+	return outputPath
+}
+
+func requireFileContents(t *testing.T, filePath string, expected string) {
+	info, err := os.Lstat(filePath)
+	require.Nil(t, err)
+	require.True(t, info.Mode().IsRegular(), "'%v' is not a regular file", filePath)
+	contents, err := os.ReadFile(filePath)
+	require.Nil(t, err)
+	require.Equal(t, expected, string(contents))
+}
+
+func requireSymlink(t *testing.T, filePath string, expectedTarget string) {
+	target, err := os.Readlink(filePath)
+	require.Nil(t, err)
+	require.Equal(t, expectedTarget, target)
+}
+
+func TestSnapshotWithSymlinksSkip(t *testing.T) {
+	clonePath, revision := createRepoWithSymlinks(t)
+	defer os.RemoveAll(clonePath)
+
+	outputPath := snapshotSymlinks(t, clonePath, revision, options.SYMLINKS_SKIP, "")
+	requireFileContents(t, filepath.Join(outputPath, "target.txt"), "target")
+	requireFileContents(t, filepath.Join(outputPath, "dir", "keep.txt"), "keep")
+	for _, link := range []string{"link.txt", "chain.txt", "dir/up.txt", "escape.txt", "absolute.txt", "dangling.txt", "dirlink"} {
+		require.NoFileExists(t, filepath.Join(outputPath, link))
+	}
+}
+
+func TestSnapshotWithSymlinksFollow(t *testing.T) {
+	clonePath, revision := createRepoWithSymlinks(t)
+	defer os.RemoveAll(clonePath)
+
+	outputPath := snapshotSymlinks(t, clonePath, revision, options.SYMLINKS_FOLLOW, "")
+	requireFileContents(t, filepath.Join(outputPath, "target.txt"), "target")
+	requireFileContents(t, filepath.Join(outputPath, "link.txt"), "target")
+	requireFileContents(t, filepath.Join(outputPath, "chain.txt"), "target")
+	requireFileContents(t, filepath.Join(outputPath, "dir", "up.txt"), "target")
+	for _, link := range []string{"escape.txt", "absolute.txt", "dangling.txt", "dirlink"} {
+		_, err := os.Lstat(filepath.Join(outputPath, link))
+		require.True(t, os.IsNotExist(err), "'%v' should not be written", link)
+	}
+
+	// the target is looked up in the whole tree, so it may be outside the subtree
+	outputPath = snapshotSymlinks(t, clonePath, revision, options.SYMLINKS_FOLLOW, "dir")
+	requireFileContents(t, filepath.Join(outputPath, "up.txt"), "target")
+}
+
+func TestSnapshotWithSymlinksRecreate(t *testing.T) {
+	clonePath, revision := createRepoWithSymlinks(t)
+	defer os.RemoveAll(clonePath)
+
+	outputPath := snapshotSymlinks(t, clonePath, revision, options.SYMLINKS_RECREATE, "")
+	requireFileContents(t, filepath.Join(outputPath, "target.txt"), "target")
+	requireSymlink(t, filepath.Join(outputPath, "link.txt"), "target.txt")
+	requireSymlink(t, filepath.Join(outputPath, "chain.txt"), "link.txt")
+	requireSymlink(t, filepath.Join(outputPath, "dir", "up.txt"), "../target.txt")
+	requireSymlink(t, filepath.Join(outputPath, "dangling.txt"), "missing.txt")
+	requireSymlink(t, filepath.Join(outputPath, "dirlink"), "dir")
+	// the recreated chain resolves within the output path
+	contents, err := os.ReadFile(filepath.Join(outputPath, "chain.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "target", string(contents))
+	for _, link := range []string{"escape.txt", "absolute.txt"} {
+		_, err := os.Lstat(filepath.Join(outputPath, link))
+		require.True(t, os.IsNotExist(err), "'%v' should not be written", link)
+	}
+
+	// relative to the subtree, the target escapes the output path
+	outputPath = snapshotSymlinks(t, clonePath, revision, options.SYMLINKS_RECREATE, "dir")
+	requireFileContents(t, filepath.Join(outputPath, "keep.txt"), "keep")
+	_, err = os.Lstat(filepath.Join(outputPath, "up.txt"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,64 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"gitsnap/options"
+	"io"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	// like git, only the head of a file is checked for binary content
+	TEXT_DETECT_SAMPLE_SIZE = 8000
+)
+
+// needsContentTextCheck returns whether --text-only checks the content of the file, which is not needed
+// when .gitattributes declares it text or binary
+func (provider *repositoryProvider) needsContentTextCheck(filePath string) bool {
+	textDetect := provider.opts.TextDetect
+	if !provider.opts.TextFilesOnly || (textDetect != options.TEXT_DETECT_CONTENT && textDetect != options.TEXT_DETECT_BOTH) {
+		return false
+	}
+	_, explicit := provider.textAttributes.isText(provider.repositoryPath(filePath))
+	return !explicit
+}
+
+// isBinaryContent checks the head of the file for NUL bytes or invalid UTF-8
+func (provider *repositoryProvider) isBinaryContent(file *object.File) (bool, error) {
+	provider.storeMutex.Lock()
+	defer provider.storeMutex.Unlock()
+
+	reader, err := file.Reader()
+	if err != nil {
+		return false, fmt.Errorf("failed to read git file '%v': %v", file.Name, err)
+	}
+	defer reader.Close()
+
+	sample := make([]byte, TEXT_DETECT_SAMPLE_SIZE)
+	n, err := io.ReadFull(reader, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("failed to read git file '%v': %v", file.Name, err)
+	}
+	return isBinarySample(sample[:n], n == TEXT_DETECT_SAMPLE_SIZE), nil
+}
+
+// isBinarySample checks a sample of the contents, a truncated sample may end in the middle of a character
+func isBinarySample(sample []byte, truncated bool) bool {
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+	if truncated {
+		for i := len(sample) - 1; i >= 0 && i >= len(sample)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(sample[i]) {
+				if !utf8.FullRune(sample[i:]) {
+					sample = sample[:i]
+				}
+				break
+			}
+		}
+	}
+	return !utf8.Valid(sample)
+}
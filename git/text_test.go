@@ -0,0 +1,61 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsBinarySample(t *testing.T) {
+	require.False(t, isBinarySample([]byte("plain text\n"), false))
+	require.False(t, isBinarySample([]byte("ünïcödé"), false))
+	require.False(t, isBinarySample([]byte{}, false))
+	require.True(t, isBinarySample([]byte("nul\x00byte"), false))
+	require.True(t, isBinarySample([]byte("invalid \xff utf-8"), false))
+
+	// a truncated sample may cut a character in half
+	cut := []byte("abc€")[:5]
+	require.False(t, isBinarySample(cut, true))
+	require.True(t, isBinarySample(cut, false))
+}
+
+func TestSnapshotWithTextDetect(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"binary.txt": "binary\x00content",
+		"script":     "#!/bin/sh\necho text\n",
+		"image.png":  "actually text",
+		"long.txt":   strings.Repeat("€", TEXT_DETECT_SAMPLE_SIZE),
+	})
+	defer os.RemoveAll(clonePath)
+
+	for textDetect, expectedFiles := range map[string][]string{
+		"":                            {"binary.txt", "script", "long.txt"},
+		options.TEXT_DETECT_EXTENSION: {"binary.txt", "script", "long.txt"},
+		options.TEXT_DETECT_CONTENT:   {"script", "image.png", "long.txt"},
+		options.TEXT_DETECT_BOTH:      {"script", "long.txt"},
+	} {
+		outputPath := t.TempDir()
+		err := Snapshot(&options.Options{
+			ClonePath:       clonePath,
+			Revision:        revision,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+			TextFilesOnly:   true,
+			TextDetect:      textDetect,
+		})
+		require.Nil(t, err)
+
+		entries, err := os.ReadDir(outputPath)
+		require.Nil(t, err)
+		var written []string
+		for _, entry := range entries {
+			written = append(written, filepath.Base(entry.Name()))
+		}
+		require.ElementsMatch(t, expectedFiles, written, textDetect)
+	}
+}
@@ -0,0 +1,89 @@
+package git
+
+import (
+	"fmt"
+	"gitsnap/parallel"
+	"gitsnap/util"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// verifyContent reads the files written by the run back from the output path, and fails if the blob id of any of them
+// doesn't match its tree entry. files kept from a previous run, shared or whose contents differ from their blob, such as
+// resolved LFS objects, are not verified.
+func (provider *repositoryProvider) verifyContent(outputPath string, records []*indexRecord) error {
+	workers := provider.opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	verified := 0
+	queue := parallel.NewJobQueue(workers)
+	for _, record := range records {
+		if !record.snapped || record.targetPath == "" || record.writtenHash.IsZero() {
+			continue
+		}
+		record := record
+		verified++
+		err := queue.Submit(func() error {
+			if ctxErr := provider.ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			targetFilePath := filepath.Join(outputPath, filepath.FromSlash(record.targetPath))
+			hash, err := hashWrittenFile(targetFilePath)
+			if err != nil {
+				return fmt.Errorf("failed to verify '%v' at '%v': %v", record.path, targetFilePath, err)
+			}
+			if hash != record.writtenHash {
+				return &util.ErrorWithCode{
+					StatusCode:    util.ERROR_FILES_DISCREPANCY,
+					InternalError: fmt.Errorf("contents of '%v' at '%v' hash to %v, but its blob is %v", record.path, targetFilePath, hash, record.writtenHash),
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			break
+		}
+	}
+	err := queue.Wait()
+	if err != nil {
+		return err
+	}
+	provider.logger.Infof("verified the contents of %v written files", verified)
+	return nil
+}
+
+// hashWrittenFile returns the git blob id of a written file, or of the target of a written symbolic link
+func hashWrittenFile(targetFilePath string) (plumbing.Hash, error) {
+	info, err := os.Lstat(targetFilePath)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(targetFilePath)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return plumbing.ComputeHash(plumbing.BlobObject, []byte(filepath.ToSlash(target))), nil
+	}
+
+	file, err := os.Open(targetFilePath)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer file.Close()
+	// the blob id covers a header with the size, which is taken from the opened file
+	hasher := plumbing.NewHasher(plumbing.BlobObject, info.Size())
+	written, err := io.Copy(hasher, file)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if written != info.Size() {
+		return plumbing.ZeroHash, fmt.Errorf("read %v bytes, but the file has %v", written, info.Size())
+	}
+	return hasher.Sum(), nil
+}
@@ -0,0 +1,74 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"gitsnap/options"
+	"gitsnap/util"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithVerifyContent(t *testing.T) {
+	clonePath, _ := createLocalRepo(map[string]string{
+		"a.txt":     "same",
+		"dir/b.txt": "same",
+		"empty.txt": "",
+		"c.txt":     "other",
+	})
+	defer os.RemoveAll(clonePath)
+	require.Nil(t, os.Symlink("c.txt", filepath.Join(clonePath, "link.txt")))
+	revision := commitFiles(clonePath, map[string]string{}, "tester <tester@example.com>")
+
+	for _, opts := range []options.Options{
+		{Symlinks: options.SYMLINKS_RECREATE},
+		{Dedup: true},
+		{OutputLayout: options.OUTPUT_LAYOUT_SHARDED},
+	} {
+		opts := opts
+		opts.ClonePath = clonePath
+		opts.Revision = revision
+		opts.OutputPath = t.TempDir()
+		opts.IncludePatterns = []string{}
+		opts.ExcludePatterns = []string{}
+		opts.VerifyContent = true
+		require.Nil(t, Snapshot(&opts))
+	}
+}
+
+func TestVerifyContentFailsOnCorruptedFiles(t *testing.T) {
+	outputPath := t.TempDir()
+	writeFiles(outputPath, map[string]string{
+		"good.txt":      "contents",
+		"truncated.txt": "conte",
+	})
+	provider := &repositoryProvider{
+		opts:   &options.Options{},
+		logger: options.NewStdLogger(),
+		ctx:    context.Background(),
+	}
+	record := func(path string) *indexRecord {
+		return &indexRecord{
+			path:        path,
+			snapped:     true,
+			targetPath:  path,
+			writtenHash: plumbing.ComputeHash(plumbing.BlobObject, []byte("contents")),
+		}
+	}
+
+	require.Nil(t, provider.verifyContent(outputPath, []*indexRecord{record("good.txt")}))
+
+	err := provider.verifyContent(outputPath, []*indexRecord{record("good.txt"), record("truncated.txt")})
+	var errorWithCode *util.ErrorWithCode
+	require.True(t, errors.As(err, &errorWithCode))
+	require.Equal(t, util.ERROR_FILES_DISCREPANCY, errorWithCode.StatusCode)
+
+	// files which were not written by the run are not verified
+	unverified := record("truncated.txt")
+	unverified.writtenHash = plumbing.ZeroHash
+	require.Nil(t, provider.verifyContent(outputPath, []*indexRecord{unverified}))
+}
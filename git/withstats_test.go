@@ -0,0 +1,58 @@
+package git
+
+import (
+	"encoding/json"
+	"gitsnap/options"
+	"gitsnap/stats"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWithStatsOfWrittenFiles(t *testing.T) {
+	clonePath, revision := createLocalRepo(map[string]string{
+		"src/A.java":        "class A {\n// comment\n\n}\n",
+		"src/B.java":        "class B {}\n",
+		"src/copy/B.java":   "class B {}\n",
+		"run.sh":            "# comment\necho a\n",
+		"README.md":         "# readme\n",
+		"test/ATest.java":   "class ATest {}\n",
+		"node_modules/x.js": "var x;\n",
+	})
+	defer os.RemoveAll(clonePath)
+	readStats := func(statsPath string) *stats.CodeStats {
+		contents, err := os.ReadFile(statsPath)
+		require.Nil(t, err)
+		codeStats := &stats.CodeStats{}
+		require.Nil(t, json.Unmarshal(contents, codeStats))
+		return codeStats
+	}
+	filters := []string{"--src", clonePath, "--rev", revision, "--exclude", "test/**"}
+
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+	opts, err := options.ParseStatsArgs(append(filters, "--out", statsPath))
+	require.Nil(t, err)
+	require.Nil(t, Snapshot(opts))
+	expected := readStats(statsPath)
+	require.Equal(t, 3, expected.CountersByLanguage["java"].NumberOfFiles)
+
+	// the stats of the written files match those of the stats command with the same filters, whatever the layout
+	for _, args := range [][]string{{}, {"--output-layout", "sharded"}, {"--format", "tar"}} {
+		outputPath := t.TempDir()
+		if len(args) > 0 && args[0] == "--format" {
+			outputPath = filepath.Join(outputPath, "snapshot.tar")
+		}
+		withStatsPath := filepath.Join(t.TempDir(), "stats.json")
+		opts, err = options.ParseArgs(append(append(filters, "--out", outputPath, "--with-stats", withStatsPath), args...))
+		require.Nil(t, err)
+		require.Nil(t, Snapshot(opts))
+		require.Equal(t, expected, readStats(withStatsPath), "%v", args)
+	}
+
+	for _, args := range [][]string{{"--dry-run"}, {"--index-only", "--index", filepath.Join(t.TempDir(), "index.tsv")}} {
+		_, err = options.ParseArgs(append(append(filters, "--out", t.TempDir(), "--with-stats", statsPath), args...))
+		require.NotNil(t, err, "%v", args)
+	}
+}
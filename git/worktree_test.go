@@ -0,0 +1,62 @@
+package git
+
+import (
+	"gitsnap/options"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotFromLinkedWorktree(t *testing.T) {
+	clonePath, _ := createLocalRepo(map[string]string{
+		"main.go": "package main",
+	})
+	defer os.RemoveAll(clonePath)
+	worktreePath := filepath.Join(t.TempDir(), "worktree")
+	runGit(clonePath, "worktree", "add", "-q", "-b", "feature", worktreePath)
+	revision := commitFiles(worktreePath, map[string]string{
+		"feature.go": "package feature",
+	}, "tester <tester@example.com>")
+	info, err := os.Stat(filepath.Join(worktreePath, ".git"))
+	require.Nil(t, err)
+	require.False(t, info.IsDir())
+	require.Nil(t, options.ValidateClonePath(worktreePath))
+
+	// HEAD is the one of the worktree, while the objects are in the repository it was added from
+	for _, rev := range []string{"HEAD", revision} {
+		outputPath := t.TempDir()
+		err = Snapshot(&options.Options{
+			ClonePath:       worktreePath,
+			Revision:        rev,
+			OutputPath:      outputPath,
+			IncludePatterns: []string{},
+			ExcludePatterns: []string{},
+		})
+		require.Nil(t, err)
+		requireFileContents(t, filepath.Join(outputPath, "main.go"), "package main")
+		requireFileContents(t, filepath.Join(outputPath, "feature.go"), "package feature")
+	}
+}
+
+func TestSnapshotFromSeparateGitDir(t *testing.T) {
+	clonePath := t.TempDir()
+	gitDir := filepath.Join(t.TempDir(), "repository.git")
+	runGit(clonePath, "init", "-q", "--separate-git-dir", gitDir)
+	revision := commitFiles(clonePath, map[string]string{
+		"main.go": "package main",
+	}, "tester <tester@example.com>")
+	require.Nil(t, options.ValidateClonePath(clonePath))
+
+	outputPath := t.TempDir()
+	err := Snapshot(&options.Options{
+		ClonePath:       clonePath,
+		Revision:        revision,
+		OutputPath:      outputPath,
+		IncludePatterns: []string{},
+		ExcludePatterns: []string{},
+	})
+	require.Nil(t, err)
+	requireFileContents(t, filepath.Join(outputPath, "main.go"), "package main")
+}
@@ -0,0 +1,132 @@
+package git
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type zipEntry struct {
+	header     *zip.FileHeader
+	compressed []byte
+}
+
+// zipArchive compresses dumped files as they arrive, but only writes them on close, sorted by path,
+// so the archive is byte-identical across runs on the same commit regardless of the dumping order
+type zipArchive struct {
+	mutex            sync.Mutex
+	path             string
+	file             io.Writer
+	compressionLevel int
+	modTime          time.Time
+	entries          []*zipEntry
+}
+
+func createZipArchive(archivePath string, stream io.Writer, compressionLevel int, modTime time.Time) (*zipArchive, error) {
+	file, err := createArchiveFile(archivePath, stream)
+	if err != nil {
+		return nil, err
+	}
+	return &zipArchive{
+		path:             archivePath,
+		file:             file,
+		compressionLevel: compressionLevel,
+		modTime:          modTime,
+	}, nil
+}
+
+// extendedTimestamp builds the extra field holding the exact modification time, as written by zip.Writer.CreateHeader
+func extendedTimestamp(modTime time.Time) []byte {
+	field := make([]byte, 9)
+	binary.LittleEndian.PutUint16(field[0:], 0x5455)
+	binary.LittleEndian.PutUint16(field[2:], 5)
+	field[4] = 1
+	binary.LittleEndian.PutUint32(field[5:], uint32(modTime.Unix()))
+	return field
+}
+
+func (archive *zipArchive) addFile(name string, mode os.FileMode, contents []byte) error {
+	compressed := &bytes.Buffer{}
+	compressor, err := flate.NewWriter(compressed, archive.compressionLevel)
+	if err != nil {
+		return fmt.Errorf("failed to compress '%v': %v", name, err)
+	}
+	_, err = compressor.Write(contents)
+	if err == nil {
+		err = compressor.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to compress '%v': %v", name, err)
+	}
+
+	header := &zip.FileHeader{
+		Name:               name,
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(contents),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: uint64(len(contents)),
+		Extra:              extendedTimestamp(archive.modTime),
+	}
+	header.SetMode(mode)
+	// unlike CreateHeader, CreateRaw does not derive the MS-DOS timestamp from Modified
+	header.SetModTime(archive.modTime)
+
+	archive.mutex.Lock()
+	defer archive.mutex.Unlock()
+	archive.entries = append(archive.entries, &zipEntry{
+		header:     header,
+		compressed: compressed.Bytes(),
+	})
+	return nil
+}
+
+func (archive *zipArchive) entryCount() int {
+	archive.mutex.Lock()
+	defer archive.mutex.Unlock()
+	return len(archive.entries)
+}
+
+func (archive *zipArchive) close() error {
+	sort.Slice(archive.entries, func(i, j int) bool {
+		return archive.entries[i].header.Name < archive.entries[j].header.Name
+	})
+
+	writer := zip.NewWriter(archive.file)
+	var err error
+	for _, entry := range archive.entries {
+		var entryWriter io.Writer
+		entryWriter, err = writer.CreateRaw(entry.header)
+		if err == nil {
+			_, err = entryWriter.Write(entry.compressed)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to write '%v' to archive: %v", entry.header.Name, err)
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	closeErr := closeArchiveFile(archive.path, archive.file)
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to finish archive at '%v': %v", archive.path, err)
+	}
+	return nil
+}
+
+// abort removes the archive, so a failed run never leaves a partial archive behind
+func (archive *zipArchive) abort() {
+	_ = closeArchiveFile(archive.path, archive.file)
+	removeArchiveFile(archive.path)
+}
@@ -1,16 +1,28 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"gitsnap/git"
 	"gitsnap/options"
+	"gitsnap/server"
 	"gitsnap/util"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
 
 	"github.com/urfave/cli/v2"
 )
 
-const VERSION = "1.26"
+const (
+	VERSION = "1.26"
+	// runs when no command is given, like git-snap did before it had commands
+	DEFAULT_COMMAND = "snapshot"
+)
 
 func main() {
 	cli.AppHelpTemplate =
@@ -18,11 +30,15 @@ func main() {
    {{.Name}} - {{.Version}} - {{.Usage}}
 
 USAGE:
-   {{.Name}}{{range .Flags}}{{if and (not (eq .Name "help")) (not (eq .Name "version")) }} {{if .Required}}--{{.Name}} value{{end}}{{end}}{{end}} [optional flags]
+   {{.Name}} --src value [global flags] [command] [command flags]
+   the command defaults to ` + DEFAULT_COMMAND + `, so its flags may directly follow the global flags
 
-OPTIONS:
+GLOBAL OPTIONS:
    {{range .Flags}}{{.}}
    {{end}}
+COMMANDS:
+   {{range .VisibleCommands}}{{join .Names ", "}}{{"\t"}}{{.Usage}}
+   {{end}}
 EXIT CODES:
 	0   Success
 	101 Some file name is too long
@@ -31,34 +47,137 @@ EXIT CODES:
 	203	Output path is invalid
 	204	Short sha is not supported
 	205	Provided revision could not be found
-	206 Double check for files discrepancy failed
+	206 Double check or --verify-content for files discrepancy failed
 	207 HEAD ref not found
 	208 tree not found
+	209 Commit signature verification failed
+	210 Invalid include or exclude pattern
+	211 Maximal total size exceeded
+	212 A tree path escapes the output path
+	213 No files were written (with --fail-on-empty)
+	214 Canceled by --timeout or an interrupt
 	1	Any other error
 `
+	cli.CommandHelpTemplate =
+		`NAME:
+   {{.HelpName}} - {{.Usage}}
+
+USAGE:
+   git-snap --src value [global flags] {{.Name}} [flags]
+
+OPTIONS:
+   {{range .VisibleFlags}}{{.}}
+   {{end}}
+`
+	// commands without subcommands are printed with either template
+	cli.SubcommandHelpTemplate = cli.CommandHelpTemplate
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 	log.SetOutput(os.Stdout)
+	snapshot := func(ctx *cli.Context, opts *options.Options) error {
+		if ctx.Bool("quiet") {
+			if opts.VerboseLogging {
+				log.SetOutput(os.Stderr)
+				log.Printf("warning: --quiet overrides --verbose")
+				opts.VerboseLogging = false
+			}
+			log.SetOutput(io.Discard)
+		}
+		// an interrupt cancels the snapshot, which cleans up its partial output, and a second one kills the process
+		snapshotCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			snapshotCtx, cancel = context.WithTimeout(snapshotCtx, opts.Timeout)
+			defer cancel()
+		}
+		go func() {
+			<-snapshotCtx.Done()
+			stop()
+		}()
+		result, err := git.SnapshotWithResultContext(snapshotCtx, opts)
+		if err == nil && !opts.DryRun && !opts.Estimate {
+			opts.Logger.Infof("%v", completionMessage(opts, result))
+		}
+		return err
+	}
 	app := &cli.App{
 		Name:    "git-snap",
-		Usage:   "Create a git revision snapshot for an existing repository clone. Symbolic link files will be omitted.",
-		Flags:   options.Flags,
+		Usage:   "Create a git revision snapshot for an existing repository clone. Symbolic link files will be omitted, unless --symlinks is set.",
+		Flags:   options.GlobalFlags,
 		Version: VERSION,
-		Action: func(ctx *cli.Context) error {
-			opts, err := options.ParseOptions(ctx)
-			if err != nil {
-				return err
-			}
-			err = git.Snapshot(opts)
-			if err == nil {
-				log.Printf("Completed successfully at %v", opts.OutputPath)
-			}
-			return err
+		// the flags of the root command, such as --src, come before the command name
+		HideHelpCommand: true,
+		Commands: []*cli.Command{
+			{
+				Name:            DEFAULT_COMMAND,
+				HideHelpCommand: true,
+				Usage:           "write the files of the revision to --out, or compare, list or catalog them instead",
+				Flags:           options.SnapshotFlags,
+				Action: func(ctx *cli.Context) error {
+					if ctx.String("out") == options.OUTPUT_STDOUT || ctx.Bool("dry-run") || ctx.Bool("estimate") || ctx.String("compare-to-dir") != "" {
+						// keep stdout clean for the streamed archive, the dry run list, the estimate or the compare report,
+						// even when parsing the other flags fails
+						log.SetOutput(os.Stderr)
+					}
+					opts, err := options.ParseOptions(ctx)
+					if err != nil {
+						return err
+					}
+					return snapshot(ctx, opts)
+				},
+			},
+			{
+				Name:            "stats",
+				HideHelpCommand: true,
+				Usage:           "write JSON or CSV statistics of the files of the revision, or of a --dir directory, to --out, instead of writing them",
+				Flags:           options.StatsFlags,
+				Action: func(ctx *cli.Context) error {
+					opts, err := options.ParseStatsOptions(ctx)
+					if err != nil {
+						return err
+					}
+					return snapshot(ctx, opts)
+				},
+			},
+			{
+				Name:            "serve",
+				HideHelpCommand: true,
+				Usage:           "serve tar snapshots of the --src clone over HTTP at GET /snapshot?rev=<commit-ish>&include=<patterns>&exclude=<patterns>, instead of snapshotting once",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "addr",
+						Value:    server.DEFAULT_ADDRESS,
+						Usage:    "address to listen on",
+						Required: false,
+					},
+					&cli.IntFlag{
+						Name:        "max-concurrency",
+						Value:       runtime.NumCPU(),
+						DefaultText: "number of CPUs",
+						Usage:       "maximal number of snapshots served at once, further requests wait for a free slot",
+						Required:    false,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					clonePath, gitDir, objectsDir := ctx.String("src"), ctx.String("git-dir"), ctx.String("objects-dir")
+					err := options.ValidateSource(clonePath, gitDir, objectsDir)
+					if err != nil {
+						return err
+					}
+					if ctx.Int("max-concurrency") < 1 {
+						return fmt.Errorf("invalid --max-concurrency %v, expected at least 1", ctx.Int("max-concurrency"))
+					}
+					return server.NewServer(clonePath, gitDir, objectsDir, ctx.Int("max-concurrency")).ListenAndServe(ctx.String("addr"))
+				},
+			},
 		},
 	}
 
-	err := app.Run(os.Args)
+	err := app.Run(withDefaultCommand(app, os.Args))
 	if err != nil {
+		// failures of parsing the flags as well, before --quiet or the output decided where the logs go
+		log.SetOutput(os.Stderr)
 		log.Printf("failed: %v", err)
 		if errorWithCode, isWithCode := err.(*util.ErrorWithCode); isWithCode {
 			os.Exit(errorWithCode.StatusCode)
@@ -66,3 +185,87 @@ EXIT CODES:
 		os.Exit(1)
 	}
 }
+
+// completionMessage names what the run wrote. only the runs writing the snapshot report the counts of written files.
+func completionMessage(opts *options.Options, result *git.SnapshotResult) string {
+	switch {
+	case opts.StatsPath != "":
+		return fmt.Sprintf("Completed successfully, stats written to %v (took %vms)", opts.StatsPath, result.DurationMs)
+	case opts.CompareToDir != "":
+		return fmt.Sprintf("Completed successfully, compare report of %v written to stdout (took %vms)", opts.CompareToDir, result.DurationMs)
+	case opts.ManifestPath != "":
+		return fmt.Sprintf("Completed successfully, manifest written to %v (took %vms)", opts.ManifestPath, result.DurationMs)
+	case opts.IndexOnly:
+		return fmt.Sprintf("Completed successfully, index written to %v (took %vms)", opts.OptionalIndexFilePath, result.DurationMs)
+	}
+	return fmt.Sprintf("Completed successfully at %v (%v files, %v bytes written, %v skipped, took %vms)", opts.OutputPath, result.FilesWritten, result.BytesWritten, result.SkippedCount, result.DurationMs)
+}
+
+// withDefaultCommand moves the global flags before the command, inserting the default command when none is given,
+// so invocations from before git-snap had commands, which mixed all flags in any order, keep working
+func withDefaultCommand(app *cli.App, args []string) []string {
+	var globalArgs []string
+	var commandArgs []string
+	command := app.Command(DEFAULT_COMMAND)
+	explicitCommand := false
+	showsAppHelp := false
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			commandArgs = append(commandArgs, args[i:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			if named := app.Command(arg); named != nil && !explicitCommand {
+				command, explicitCommand = named, true
+				continue
+			}
+			commandArgs = append(commandArgs, arg)
+			continue
+		}
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		target := &commandArgs
+		flag := findFlag(app.Flags, name)
+		if !explicitCommand && (hasName(cli.HelpFlag, name) || hasName(cli.VersionFlag, name)) {
+			target = &globalArgs
+			showsAppHelp = true
+		} else if flag != nil {
+			target = &globalArgs
+		} else {
+			flag = findFlag(command.Flags, name)
+		}
+		*target = append(*target, arg)
+		if valueFlag, isDoc := flag.(cli.DocGenerationFlag); isDoc && valueFlag.TakesValue() && !hasValue && i+1 < len(args) {
+			// the value is the next argument
+			i++
+			*target = append(*target, args[i])
+		}
+	}
+
+	normalized := append([]string{args[0]}, globalArgs...)
+	if showsAppHelp {
+		// with a command, its own help would be shown instead
+		return normalized
+	}
+	normalized = append(normalized, command.Name)
+	return append(normalized, commandArgs...)
+}
+
+func findFlag(flags []cli.Flag, name string) cli.Flag {
+	for _, flag := range flags {
+		if hasName(flag, name) {
+			return flag
+		}
+	}
+	return nil
+}
+
+func hasName(flag cli.Flag, name string) bool {
+	for _, flagName := range flag.Names() {
+		if flagName == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"gitsnap/options"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestWithDefaultCommand(t *testing.T) {
+	app := &cli.App{
+		Flags: options.GlobalFlags,
+		Commands: []*cli.Command{
+			{Name: DEFAULT_COMMAND, Flags: options.SnapshotFlags},
+			{Name: "stats", Flags: options.StatsFlags},
+		},
+	}
+	for _, test := range []struct {
+		args     string
+		expected string
+	}{
+		{"--src r --rev HEAD --out o", "--src r --rev HEAD snapshot --out o"},
+		{"--out o -q --src r -r HEAD", "-q --src r -r HEAD snapshot --out o"},
+		{"--src=r --include stats --rev stats", "--src=r --rev stats snapshot --include stats"},
+		{"--src r --dry-run --vv", "--src r --vv snapshot --dry-run"},
+		{"--src r", "--src r snapshot"},
+		{"--src r snapshot --out o", "--src r snapshot --out o"},
+		{"--src r stats --out s.json --rev HEAD", "--src r --rev HEAD stats --out s.json"},
+		{"--src r stats --help", "--src r stats --help"},
+		{"--out o --help", "--help"},
+		{"-v", "-v"},
+	} {
+		args := append([]string{"git-snap"}, strings.Fields(test.args)...)
+		expected := append([]string{"git-snap"}, strings.Fields(test.expected)...)
+		require.Equal(t, expected, withDefaultCommand(app, args), test.args)
+	}
+}
@@ -0,0 +1,72 @@
+package options
+
+import (
+	"flag"
+	"io"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// ParseArgs parses command line style arguments of the global and snapshot flags, with their defaults and the validations of ParseOptions
+func ParseArgs(args []string) (*Options, error) {
+	return parseArgs(SnapshotFlags, args, ParseOptions)
+}
+
+// ParseStatsArgs parses command line style arguments of the global and stats flags, like ParseArgs
+func ParseStatsArgs(args []string) (*Options, error) {
+	return parseArgs(StatsFlags, args, ParseStatsOptions)
+}
+
+func parseArgs(commandFlags []cli.Flag, args []string, parse func(c *cli.Context) (*Options, error)) (*Options, error) {
+	flags := append(append([]cli.Flag{}, GlobalFlags...), commandFlags...)
+	set := flag.NewFlagSet("git-snap", flag.ContinueOnError)
+	set.SetOutput(io.Discard)
+	for _, f := range flags {
+		err := f.Apply(set)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := set.Parse(canonicalArgs(flags, args))
+	if err != nil {
+		return nil, err
+	}
+	return parse(cli.NewContext(nil, set, nil))
+}
+
+// canonicalArgs renames aliases of flags to their names. every alias is a flag of its own in the flag set, and
+// without the cli app nothing copies its value to the name the options are read by.
+func canonicalArgs(flags []cli.Flag, args []string) []string {
+	byName := map[string]cli.Flag{}
+	for _, f := range flags {
+		for _, name := range f.Names() {
+			byName[name] = f
+		}
+	}
+
+	canonical := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		// like the flag set, parsing stops at the first argument which is not a flag
+		if arg == "-" || arg == "--" || !strings.HasPrefix(arg, "-") {
+			return append(canonical, args[i:]...)
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		f, found := byName[name]
+		if !found {
+			canonical = append(canonical, arg)
+			continue
+		}
+		if hasValue {
+			canonical = append(canonical, "--"+f.Names()[0]+"="+value)
+			continue
+		}
+		canonical = append(canonical, "--"+f.Names()[0])
+		if _, isBool := f.(*cli.BoolFlag); !isBool && i+1 < len(args) {
+			i++
+			canonical = append(canonical, args[i])
+		}
+	}
+	return canonical
+}
@@ -0,0 +1,27 @@
+package options
+
+import (
+	"fmt"
+	"log"
+)
+
+// Logger receives the logs of a snapshot. Debugf is used only for verbose logging.
+type Logger interface {
+	Infof(format string, v ...interface{})
+	Debugf(format string, v ...interface{})
+}
+
+type stdLogger struct{}
+
+// NewStdLogger returns a Logger writing to the standard logger of the log package
+func NewStdLogger() Logger {
+	return stdLogger{}
+}
+
+func (stdLogger) Infof(format string, v ...interface{}) {
+	_ = log.Output(2, fmt.Sprintf(format, v...))
+}
+
+func (stdLogger) Debugf(format string, v ...interface{}) {
+	_ = log.Output(2, fmt.Sprintf(format, v...))
+}
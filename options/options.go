@@ -1,78 +1,197 @@
 package options
 
 import (
+	"compress/gzip"
 	"fmt"
+	"gitsnap/stats"
 	"gitsnap/util"
+	"io"
 	"os"
-	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
 )
 
-var Flags = []cli.Flag{
+const (
+	ON_CONFLICT_ERROR  = "error"
+	ON_CONFLICT_SKIP   = "skip"
+	ON_CONFLICT_RENAME = "rename"
+
+	OVERWRITE_FAIL    = "fail"
+	OVERWRITE_REPLACE = "replace"
+	OVERWRITE_SKIP    = "skip"
+
+	ON_LONG_PATH_SKIP     = "skip"
+	ON_LONG_PATH_FAIL     = "fail"
+	ON_LONG_PATH_TRUNCATE = "truncate"
+
+	FORMAT_DIR    = "dir"
+	FORMAT_TAR    = "tar"
+	FORMAT_TAR_GZ = "tar.gz"
+	FORMAT_ZIP    = "zip"
+
+	OUTPUT_STDOUT    = "-"
+	PATHS_FILE_STDIN = "-"
+
+	DEFAULT_PATTERN_DELIMITER = ","
+	NOISE_DIRS_DELIMITER      = ","
+
+	DEFAULT_FILE_MODE = "0644"
+
+	DEFAULT_RENAME_THRESHOLD = 50
+
+	DEFAULT_BLAME_MAX_COMMITS = 1000
+
+	TEXT_DETECT_EXTENSION = "extension"
+	TEXT_DETECT_CONTENT   = "content"
+	TEXT_DETECT_BOTH      = "both"
+
+	OUTPUT_LAYOUT_MIRROR  = "mirror"
+	OUTPUT_LAYOUT_SHARDED = "sharded"
+
+	INDEX_FORMAT_TSV  = "tsv"
+	INDEX_FORMAT_CSV  = "csv"
+	INDEX_FORMAT_JSON = "json"
+
+	STATS_FORMAT_JSON = "json"
+	STATS_FORMAT_CSV  = "csv"
+
+	SYMLINKS_SKIP     = "skip"
+	SYMLINKS_FOLLOW   = "follow"
+	SYMLINKS_RECREATE = "recreate"
+
+	HASH_ALGO_SHA1   = "sha1"
+	HASH_ALGO_SHA256 = "sha256"
+
+	ENCODING_UTF8 = "utf8"
+
+	DOUBLE_CHECK_COUNT = "count"
+	DOUBLE_CHECK_HASH  = "hash"
+
+	PROGRESS_JSON             = "json"
+	DEFAULT_PROGRESS_INTERVAL = time.Second
+
+	// the defaults of retry-go
+	DEFAULT_READ_RETRIES     = 10
+	DEFAULT_READ_RETRY_DELAY = 100 * time.Millisecond
+)
+
+// GlobalFlags are the flags of git-snap itself, shared by all of its commands
+var GlobalFlags = []cli.Flag{
 	&cli.StringFlag{
 		Name:     "src",
 		Aliases:  []string{"s"},
-		Usage:    "path to existing git clone as source directory, may contain no more than .git directory, current git state doesn't affect the command",
-		Required: true,
+		Usage:    "path to existing git clone as source directory, may contain no more than .git directory (or a .git file of a linked worktree) or be a bare repository, current git state doesn't affect the command",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "git-dir",
+		Usage:    "path to the git directory to read instead of the one of --src, which then needs no .git. --src is still required, as the working tree where submodules may have their own .git directory",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "objects-dir",
+		Usage:    "path to the object store to read instead of the objects directory of the git directory, such as a shared object cache. its info/alternates are followed",
+		Required: false,
 	},
 	&cli.StringFlag{
 		Name:     "rev",
 		Aliases:  []string{"r"},
-		Usage:    "commit-ish Revision",
-		Required: true,
+		Usage:    "commit-ish Revision, either it or --rev-file is required",
+		Required: false,
 	},
 	&cli.StringFlag{
-		Name:     "index",
-		Aliases:  []string{"x"},
-		Usage:    "Create index file listing file paths and their blob IDs",
+		Name:     "rev-file",
+		Usage:    "path to a file whose first line is the commit-ish revision, instead of --rev",
 		Required: false,
 	},
 	&cli.BoolFlag{
-		Name:     "index-only",
-		Aliases:  []string{"xo"},
+		Name:     "short-sha",
 		Value:    false,
-		Usage:    "Create index only - Don't checkout any files",
+		Usage:    "allow abbreviated commit hashes (4 to 39 hex characters) as revisions, which are otherwise rejected with exit code 204",
 		Required: false,
 	},
-	&cli.StringFlag{
-		Name:     "out",
-		Aliases:  []string{"o"},
-		Usage:    "output directory. will be created if does not exist",
-		Required: true,
+	&cli.BoolFlag{
+		Name:     "verbose",
+		Aliases:  []string{"vv"},
+		Value:    false,
+		Usage:    "verbose logging",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "quiet",
+		Aliases:  []string{"q"},
+		Value:    false,
+		Usage:    "don't log anything but errors, which go to stderr. overrides --verbose",
+		Required: false,
 	},
+}
+
+// filterFlags select the files of the revision, for both the snapshot and stats commands
+var filterFlags = []cli.Flag{
 	&cli.StringFlag{
 		Name:     "include",
 		Aliases:  []string{"i"},
 		Value:    "",
-		Usage:    "patterns of file paths to include, comma delimited, may contain any glob pattern",
+		Usage:    "patterns of file paths to include, comma delimited unless --pattern-delimiter is set, may contain any glob pattern. evaluated before the exclude patterns, so a path matching both is excluded",
 		Required: false,
 	},
 	&cli.StringFlag{
 		Name:     "exclude",
 		Aliases:  []string{"e"},
 		Value:    "",
-		Usage:    "patterns of file paths to exclude, comma delimited, may contain any glob pattern",
+		Usage:    "patterns of file paths to exclude, comma delimited unless --pattern-delimiter is set, may contain any glob pattern. evaluated in order - the last matching pattern wins, and a leading ! re-includes paths excluded by earlier patterns",
 		Required: false,
 	},
-	&cli.BoolFlag{
-		Name:     "verbose",
-		Aliases:  []string{"vv"},
-		Value:    false,
-		Usage:    "verbose logging",
+	&cli.StringFlag{
+		Name:     "name-include",
+		Value:    "",
+		Usage:    "patterns of file names to include, matched against the last path component only, such as Dockerfile or *.lock. delimited like --include, and applied along with the path patterns",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "name-exclude",
+		Value:    "",
+		Usage:    "patterns of file names to exclude, matched against the last path component only. delimited like --exclude, and applied even to paths matching the include patterns",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "pattern-delimiter",
+		Value:    DEFAULT_PATTERN_DELIMITER,
+		Usage:    "delimiter of the --include and --exclude patterns, for patterns containing a comma such as **/*.{js,ts}",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "include-from",
+		Usage:    "path to a file of patterns of file paths to include, one per line. blank lines and lines starting with # are ignored. they come before the --include patterns",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "exclude-from",
+		Usage:    "path to a file of patterns of file paths to exclude, one per line. blank lines and lines starting with # are ignored. they come before the --exclude patterns, which override them",
 		Required: false,
 	},
 	&cli.BoolFlag{
 		Name:     "text-only",
 		Value:    false,
-		Usage:    "include only text files",
+		Usage:    "include only text files. text and binary declarations of the .gitattributes files committed in the tree take precedence over --text-detect",
 		Required: false,
 	},
-	&cli.BoolFlag{
-		Name:     "hash-markers",
-		Value:    false,
-		Usage:    "create also hint files mirroring the hash of original files at <path>.hash",
+	&cli.StringFlag{
+		Name:     "text-detect",
+		Value:    TEXT_DETECT_EXTENSION,
+		Usage:    "how --text-only tells text files: extension (a list of binary extensions), content (no NUL bytes or invalid UTF-8 in the first 8000 bytes) or both",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "symlinks",
+		Value:    SYMLINKS_SKIP,
+		Usage:    "what to do with symbolic links: skip them, follow (write the content of their target file, if it is in the tree) or recreate (write a symbolic link, --format dir only). links whose target is outside the tree or the output path are skipped",
 		Required: false,
 	},
 	&cli.BoolFlag{
@@ -84,125 +203,1278 @@ var Flags = []cli.Flag{
 	&cli.IntFlag{
 		Name:     "max-size",
 		Value:    6,
-		Usage:    "maximal file size, in MB",
+		Usage:    "maximal file size, in MB. larger files are neither written nor counted by stats",
 		Required: false,
 	},
 	&cli.BoolFlag{
-		Name:     "no-double-check",
+		Name:     "include-noise-dirs",
 		Value:    false,
-		Usage:    "disable files discrepancy double check",
+		Usage:    "don't filter out noisy directory names in paths (bin, node_modules etc)",
 		Required: false,
 	},
 	&cli.BoolFlag{
-		Name:     "include-noise-dirs",
+		Name:     "exclude-dotfiles",
 		Value:    false,
-		Usage:    "don't filter out noisy directory names in paths (bin, node_modules etc)",
+		Usage:    "filter out files with any path component starting with a dot, such as .env or .github/workflows/ci.yml, even if they match the include patterns",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "noise-dirs",
+		Value:    strings.Join(util.NoiseDirectories(), NOISE_DIRS_DELIMITER),
+		Usage:    "names of the noisy directories, comma delimited, replacing the built-in ones",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "extra-noise-dirs",
+		Usage:    "names of noisy directories to filter out in addition to --noise-dirs, comma delimited, such as coverage,.terraform",
 		Required: false,
 	},
 	&cli.StringFlag{
 		Name:     "paths-file-location",
-		Aliases:  []string{"pl"},
-		Usage:    "a location of a text file with all the paths to snap (one path per line)",
+		Aliases:  []string{"pl", "paths-file"},
+		Usage:    "a location of a text file with all the paths to snap (one path per line), or - to read it from stdin",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "fetch-missing",
+		Value:    false,
+		Usage:    "fetch blobs missing from a partial clone from the origin remote (requires network access)",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "fetch-missing-limit",
+		Value:    100,
+		Usage:    "maximal number of missing blobs to fetch when --fetch-missing is set",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "skip-single-author-generated",
+		Value:    false,
+		Usage:    "skip files whose whole history is a single commit by a generated (bot) author. costly - walks the history of each file",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "generated-author-pattern",
+		Value:    `(?i)\[bot\]|\bbot\b`,
+		Usage:    "regular expression matched against 'name <email>' of commit authors considered generated",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "generated-history-limit",
+		Value:    100,
+		Usage:    "maximal number of commits to walk per file when looking for single author generated files",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "since",
+		Usage:    "snapshot only files last changed after this RFC3339 date, such as 2024-01-31T00:00:00Z, by the commit dates of the first-parent history. costly - diffs every commit after the date with its parent",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:        "since-workers",
+		Value:       runtime.NumCPU(),
+		DefaultText: "number of CPUs",
+		Usage:       "number of commits to diff in parallel for --since, each worker opens the clone on its own",
+		Required:    false,
+	},
+	&cli.BoolFlag{
+		Name:     "verify-signature",
+		Value:    false,
+		Usage:    "verify the GPG or SSH signature of the commit against --keyring before snapshotting it",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "keyring",
+		Usage:    "path to an armored GPG public keyring, or to SSH public keys (authorized_keys or allowed_signers format)",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "apply-gitignore",
+		Value:    false,
+		Usage:    "also exclude paths ignored by the .gitignore files committed in the snapshotted tree",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "regex",
+		Value:    false,
+		Usage:    "treat --include and --exclude patterns as regular expressions matched against the full path, instead of globs",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "subtree",
+		Usage:    "snapshot only the directory at this path of the tree, writing paths relative to it. patterns and the paths file apply to the relative paths",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "base-rev",
+		Usage:    "commit-ish base revision, snapshot only files added or modified since it. --manifest-only also lists the deleted paths",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "resolve-lfs",
+		Value:    false,
+		Usage:    "write the content of git LFS files from the local LFS cache of the clone (.git/lfs/objects) instead of their pointer files. files whose object is missing are skipped",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "recurse-submodules",
+		Value:    false,
+		Usage:    "also snapshot the recorded commit of every submodule into its path, applying the same filters to the paths prefixed by it. submodules which are not initialized in the clone (.git/modules or their own .git directory) are skipped",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "lang-map",
+		Usage:    "path to a JSON object of language names to lists of extensions, such as {\"gs\": [\".gs\"]}, recognized in addition to the built-in ones by stats, --index-loc and --manifest-only. its extensions override the built-in ones",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "read-retries",
+		Value:    DEFAULT_READ_RETRIES,
+		Usage:    "number of attempts to read the contents of a blob before failing",
+		Required: false,
+	},
+	&cli.DurationFlag{
+		Name:     "read-retry-delay",
+		Value:    DEFAULT_READ_RETRY_DELAY,
+		Usage:    "base delay between attempts to read a blob, doubled on every retry with a random jitter",
+		Required: false,
+	},
+	&cli.DurationFlag{
+		Name:     "timeout",
+		Usage:    "cancel the command once it runs longer than the given duration, such as 10m, failing with exit code 214. files being written finish first. unset means no timeout",
 		Required: false,
 	},
 }
 
-type Options struct {
-	ClonePath             string
-	Revision              string
-	OutputPath            string
-	OptionalIndexFilePath string
-	IndexOnly             bool
-	IncludePatterns       []string
-	ExcludePatterns       []string
-	VerboseLogging        bool
-	TextFilesOnly         bool
-	CreateHashMarkers     bool
-	IgnoreCasePatterns    bool
-	MaxFileSizeBytes      int64
-	SkipDoubleCheck       bool
-	IncludeNoiseDirs      bool
-	PathsFileLocation     string
-}
-
-func splitListFlag(flag string) []string {
-	if len(flag) == 0 {
-		return []string{}
-	}
-	return strings.Split(flag, ",")
-}
-
-func validateDirectory(dirPath string, createIfNotExist bool) error {
-	info, err := os.Stat(dirPath)
-	if os.IsNotExist(err) {
-		if !createIfNotExist {
-			return fmt.Errorf("directory does not exist at %v", dirPath)
-		}
-		err = os.MkdirAll(dirPath, 0777)
-		if err != nil {
-			return fmt.Errorf("failed to create directory at %v: %w", dirPath, err)
-		}
-		return nil
-	}
-	if err != nil {
-		return fmt.Errorf("directory error at %v: %w", dirPath, err)
-	}
-	if !info.IsDir() {
-		return fmt.Errorf("directory is actually a file at %v", dirPath)
-	}
-	return nil
-}
-
-func ParseOptions(c *cli.Context) (*Options, error) {
-	opts := &Options{
-		ClonePath:             c.String("src"),
-		Revision:              c.String("rev"),
-		OutputPath:            c.String("out"),
-		IncludePatterns:       splitListFlag(c.String("include")),
-		ExcludePatterns:       splitListFlag(c.String("exclude")),
-		VerboseLogging:        c.Bool("verbose"),
-		TextFilesOnly:         c.Bool("text-only"),
-		CreateHashMarkers:     c.Bool("hash-markers"),
-		IgnoreCasePatterns:    c.Bool("ignore-case"),
-		MaxFileSizeBytes:      int64(c.Int("max-size")) * 1024 * 1024,
-		SkipDoubleCheck:       c.Bool("no-double-check"),
-		IncludeNoiseDirs:      c.Bool("include-noise-dirs"),
-		OptionalIndexFilePath: c.String("index"),
-		IndexOnly:             c.Bool("index-only"),
-		PathsFileLocation:     c.String("paths-file-location"),
-	}
-
-	err := validateDirectory(opts.ClonePath, false)
-	if err != nil {
-		return nil, &util.ErrorWithCode{
-			StatusCode:    util.ERROR_BAD_CLONE_PATH,
-			InternalError: fmt.Errorf("clone at '%v' is missing or invalid: %v", opts.ClonePath, err),
-		}
-	}
-
-	err = validateDirectory(path.Join(opts.ClonePath, ".git"), false)
-	if err != nil {
-		return nil, &util.ErrorWithCode{
-			StatusCode:    util.ERROR_BAD_CLONE_PATH,
-			InternalError: fmt.Errorf(".git at '%v' is missing or invalid: %v", opts.ClonePath, err),
-		}
-	}
-
-	if !opts.IndexOnly {
-		err = validateDirectory(opts.OutputPath, true)
-		if err != nil {
-			return nil, &util.ErrorWithCode{
-				StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
-				InternalError: err,
-			}
-		}
-	}
-
-	if !opts.IncludeNoiseDirs {
-		opts.ExcludePatterns = union(util.NoisyDirectoryExclusionPatterns(), opts.ExcludePatterns)
-	}
-
-	return opts, nil
+var SnapshotFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:     "index",
+		Aliases:  []string{"x", "index-file"},
+		Usage:    "Create index file listing file paths and their blob IDs",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "index-only",
+		Aliases:  []string{"xo"},
+		Value:    false,
+		Usage:    "Create index only - Don't checkout any files, the index file is set with --index",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "out",
+		Aliases:  []string{"o"},
+		Usage:    "output directory, or archive file with --format tar, tar.gz or zip. will be created if does not exist. use - to stream a tar to stdout. not required with --compare-to-dir, --manifest-only, --index-only or --dry-run",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "hash-markers",
+		Value:    false,
+		Usage:    "create also hint files mirroring the hash of original files at <path>.hash",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "hash-markers-dir",
+		Usage:    "like --hash-markers, but create the hint files at <path>.hash under this directory instead of next to the files, keeping the snapshot clean. will be created if does not exist",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "hash-algo",
+		Value:    HASH_ALGO_SHA1,
+		Usage:    "hash recorded by hash markers and --checksum: sha1 (the git blob id) or sha256 (of the written contents)",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "no-double-check",
+		Value:    false,
+		Usage:    "disable files discrepancy double check",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "double-check",
+		Value:    DOUBLE_CHECK_COUNT,
+		Usage:    "files discrepancy double check: count (walks the tree before and after the snapshot as well, comparing the number of entries) or hash (compares a digest of the paths and blob ids of the written files to one of the tree entries passing the filters, read once more without reading blobs - cheaper for large trees, and also catches files which were dropped or skipped while writing, such as missing LFS objects, and entries replaced by others, but it doesn't recheck the tree after the snapshot). ignored with --no-double-check",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "compare-to-dir",
+		Usage:    "don't write anything, instead compare the filtered revision files against an existing directory and report missing, extra and differing files as JSON",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "overwrite",
+		Value:    OVERWRITE_REPLACE,
+		Usage:    "what to do with an existing --out: fail unless it is empty (or, for an archive, missing), replace the files it has at the written paths, or skip writing the files it already has. other files in it are left in place",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "prune",
+		Value:    false,
+		Usage:    "once the snapshot is written, remove the files of --out which are not part of it, such as those of a previously snapshotted revision, and the directories they leave empty. the index, checksum, stats, deletions and hash marker files of the run are kept. --format dir only",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "verify-content",
+		Value:    false,
+		Usage:    "once the snapshot is written, read every written file back and fail with a files discrepancy if its git blob id doesn't match the one in the tree, catching truncated or corrupted writes. doubles the read I/O. files whose contents differ from their blob, such as resolved LFS objects or with --strip-bom or --encoding, are not verified. --format dir only",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "on-conflict",
+		Value:    ON_CONFLICT_ERROR,
+		Usage:    "what to do when a target path was already written by the current run: error, skip or rename (adds a numeric suffix)",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "on-long-path",
+		Value:    ON_LONG_PATH_SKIP,
+		Usage:    "what to do with a file whose name is over 255 bytes or path is over 4095 bytes, or whose write fails as too long: skip, fail (exit code 101) or truncate (shortens the name keeping its extension and adding the short blob id, a path still too long fails)",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "index-loc",
+		Value:    false,
+		Usage:    "add a lines of code column to the index file, for files of a recognized language (requires decoding their contents)",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "index-extended",
+		Value:    false,
+		Usage:    "add size (in bytes, as written) and mode (in octal, such as 0100644) columns to the index file, empty for directories",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "index-format",
+		Value:    INDEX_FORMAT_TSV,
+		Usage:    "format of the index file: tsv, csv (both with a header row) or json (an array of objects with camelCase keys)",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "replace-conflicting-paths",
+		Value:    false,
+		Usage:    "remove existing output paths of the wrong type (a file where a directory is needed or vice versa) instead of failing",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "manifest-only",
+		Usage:    "don't write any files, instead write a JSON manifest with the path, blob id, content sha256, size, mode, language, and code, comment and blank line counts of every file to the given path",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "manifest-blame",
+		Value:    false,
+		Usage:    "add the last commit, author email and author date of every file to the --manifest-only manifest. costly - diffs every commit of the first-parent history, up to --blame-max-commits, with its parent on --workers",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "blame-max-commits",
+		Value:    DEFAULT_BLAME_MAX_COMMITS,
+		Usage:    "maximal number of commits to walk for --manifest-blame, files last changed before them have no commit in the manifest",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:        "workers",
+		Value:       runtime.NumCPU(),
+		DefaultText: "number of CPUs",
+		Usage:       "number of files to dump in parallel. blobs are read from the object store one at a time, while writing files and the rest of dumping them run in parallel",
+		Required:    false,
+	},
+	&cli.Int64Flag{
+		Name:     "max-inflight-bytes",
+		Value:    0,
+		Usage:    "maximal total size of the files being dumped at once by --workers, in bytes. a larger file waits to be dumped alone. 0 means no limit",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "format",
+		Value:    FORMAT_DIR,
+		Usage:    "output format: dir (write files under --out), tar, tar.gz or zip (write a single archive to --out)",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "compression-level",
+		Value:    gzip.DefaultCompression,
+		Usage:    "compression level for --format tar.gz or zip, 0 (none) to 9 (best)",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "dry-run",
+		Value:    false,
+		Usage:    "don't write any files, instead print a tab separated list of the files which would be written, with their size and blob id, to stdout",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "estimate",
+		Value:    false,
+		Usage:    "don't write any files, instead print a JSON object with the number and total size of the files which would be written to stdout, applying all the filters. sizes are read from the blob headers, before --dedup or --resolve-lfs, and whether --max-total-size would be exceeded is included when it is set",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "max-total-size",
+		Value:    0,
+		Usage:    "maximal total size of written files in MB, the snapshot fails once it is exceeded. 0 means no limit",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "file-mode",
+		Value:    DEFAULT_FILE_MODE,
+		Usage:    "permissions of written files, in octal. executable files also get execute permission wherever read permission is given",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "resume",
+		Value:    false,
+		Usage:    "keep the files an interrupted snapshot already wrote to --out instead of writing them again: those with a matching .hash marker with --hash-markers, or else of the same size. existing files are trusted, their contents are not compared. --format dir only",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "keep-partial",
+		Value:    false,
+		Usage:    "when the snapshot is interrupted or times out, keep the files already written to --out with an .incomplete marker, such as to --resume it. otherwise they are removed if --out was empty before, and marked otherwise. partial archives are always removed. --format dir only",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "preserve-mode",
+		Value:    false,
+		Usage:    "write files with their permissions in git (0644 or 0755) instead of --file-mode",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "strip-bom",
+		Value:    false,
+		Usage:    "remove a leading UTF-8, UTF-16LE or UTF-16BE byte order mark from the written text files, those declared text by .gitattributes or else with no binary extension",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "encoding",
+		Usage:    "transcode the written text files to the given encoding, which may only be utf8. the encoding of a file which is not UTF-8 is detected by its byte order mark or HTML meta tag, defaulting to windows-1252 (a superset of Latin-1). binary contents are kept. unset writes files as they are",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "keep-empty-dirs",
+		Value:    false,
+		Usage:    "create the directories holding a .gitkeep or .keep file even when no file in them is written, such as when the placeholder is filtered out. --format dir only",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "flatten",
+		Value:    false,
+		Usage:    "write all files into the output root, named by their path with / replaced by __. on a name collision the short blob id is appended, and too long names are shortened. with --index the written name is added as a TargetPath column",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "dedup",
+		Value:    false,
+		Usage:    "write the contents of a blob once, hard linking its other paths to the written file (copying them where hard links are not supported). with --manifest-only the later paths of a blob point to the first as duplicateOf. --output-layout sharded always writes a blob once",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "output-layout",
+		Value:    OUTPUT_LAYOUT_MIRROR,
+		Usage:    "how files are laid out in the output: mirror (by their tree path) or sharded (by their blob id, as ab/cd/<blob id>, writing the contents of a blob once for all of its paths). with --index the written path is added as a TargetPath column",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "deletions-file",
+		Usage:    "with --base-rev, write the paths deleted since the base revision to this file, in the format of the index file. renames are listed as deletions, unless --detect-renames is set",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "detect-renames",
+		Value:    false,
+		Usage:    "with --base-rev, detect files renamed since the base revision instead of seeing them as a deletion and an addition. --manifest-only lists them as renames, and they are left out of --deletions-file",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "rename-threshold",
+		Value:    DEFAULT_RENAME_THRESHOLD,
+		Usage:    "minimal similarity in percents between a deleted and an added file to detect them as a rename, with --detect-renames",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "progress",
+		Usage:    "periodically write the progress of the snapshot to stderr in this format, only json (lines of processed and total tree entries and written bytes) is supported",
+		Required: false,
+	},
+	&cli.DurationFlag{
+		Name:     "progress-interval",
+		Value:    DEFAULT_PROGRESS_INTERVAL,
+		Usage:    "interval between --progress events",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "fail-on-empty",
+		Value:    false,
+		Usage:    "fail with exit code 213 when no files were written, such as when the include patterns match nothing",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "checksum",
+		Usage:    "write a SHA-256 digest of the number of written files and their sorted paths and blob ids to this file. snapshots of the same commit with the same filters have the same checksum",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "with-stats",
+		Usage:    "also write the JSON statistics of the stats command for the written files to this file, counting the lines of the contents as they are read to be written, rather than in a second walk",
+		Required: false,
+	},
+}, filterFlags...)
+
+var StatsFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:     "out",
+		Aliases:  []string{"o"},
+		Usage:    "path to write the statistics to, by --stats-format: the number of files, lines of code, comment and blank lines per language, and the total lines of code and size of the snapshot",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "dir",
+		Usage:    "collect the statistics of the files in this directory, such as an extracted snapshot, instead of those of a revision. --src and --rev are not needed, and the flags which need git, such as --base-rev, can't be set",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "stats-format",
+		Value:    STATS_FORMAT_JSON,
+		Usage:    "format of the statistics: json, or csv with a header row, a language,numberOfFiles,linesOfCode,sizeBytes row for every language and a row of their totals",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "stats-detailed",
+		Usage:    "also write a JSON line with the path, language, lines of code and size of every counted file to this file",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "stats-top",
+		Value:    0,
+		Usage:    "keep only the counters of the N languages with the most lines of code, summing the rest as \"other\". 0 means no limit",
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "stats-detect-shebang",
+		Value:    false,
+		Usage:    "detect the language of files with an unknown extension by the interpreter of their #! line. reads the first line of each such file",
+		Required: false,
+	},
+}, filterFlags...)
+
+// revisionOnlyFlags read the clone or the history, so they don't apply to the files of a directory
+var revisionOnlyFlags = []string{
+	"src", "git-dir", "objects-dir", "rev", "rev-file", "short-sha", "symlinks", "fetch-missing", "skip-single-author-generated",
+	"since", "verify-signature", "apply-gitignore", "subtree", "base-rev", "resolve-lfs", "recurse-submodules",
+}
+
+type Options struct {
+	ClonePath                 string
+	GitDir                    string
+	ObjectsDir                string
+	Revision                  string
+	SupportShortSha           bool
+	OutputPath                string
+	OptionalIndexFilePath     string
+	IndexOnly                 bool
+	IncludePatterns           []string
+	ExcludePatterns           []string
+	NameIncludePatterns       []string
+	NameExcludePatterns       []string
+	VerboseLogging            bool
+	TextFilesOnly             bool
+	TextDetect                string
+	Symlinks                  string
+	CreateHashMarkers         bool
+	HashMarkersDir            string
+	HashAlgorithm             string
+	IgnoreCasePatterns        bool
+	MaxFileSizeBytes          int64
+	SkipDoubleCheck           bool
+	DoubleCheck               string
+	IncludeNoiseDirs          bool
+	ExcludeDotfiles           bool
+	PathsFileLocation         string
+	CompareToDir              string
+	FetchMissing              bool
+	MaxFetches                int
+	OnConflict                string
+	OnLongPath                string
+	IndexLinesOfCode          bool
+	IndexExtended             bool
+	IndexFormat               string
+	ReplaceConflictingPaths   bool
+	SkipSingleAuthorGenerated bool
+	GeneratedAuthorPattern    string
+	GeneratedHistoryLimit     int
+	Since                     time.Time
+	SinceWorkers              int
+	ManifestPath              string
+	ManifestBlame             bool
+	BlameMaxCommits           int
+	VerifySignature           bool
+	KeyringPath               string
+	Workers                   int
+	MaxInflightBytes          int64
+	Format                    string
+	CompressionLevel          int
+	DryRun                    bool
+	Estimate                  bool
+	ApplyGitignore            bool
+	Regex                     bool
+	MaxTotalSizeBytes         int64
+	FileMode                  os.FileMode
+	PreserveMode              bool
+	StripBOM                  bool
+	Encoding                  string
+	Resume                    bool
+	KeepPartial               bool
+	Overwrite                 string
+	Prune                     bool
+	VerifyContent             bool
+	Subtree                   string
+	BaseRevision              string
+	DeletionsFilePath         string
+	DetectRenames             bool
+	RenameThreshold           int
+	ResolveLFS                bool
+	RecurseSubmodules         bool
+	StatsPath                 string
+	StatsDetailsPath          string
+	StatsTopLanguages         int
+	StatsDetectShebang        bool
+	StatsDir                  string
+	StatsFormat               string
+	LanguageMapPath           string
+	Progress                  string
+	ProgressInterval          time.Duration
+	ReadRetries               int
+	ReadRetryDelay            time.Duration
+	Timeout                   time.Duration
+	FailOnEmpty               bool
+	ChecksumPath              string
+	WithStatsPath             string
+	KeepEmptyDirs             bool
+	Flatten                   bool
+	OutputLayout              string
+	Dedup                     bool
+	// OutputWriter receives the archive when the output path is -, the dry run list, the estimate and the compare report,
+	// instead of stdout
+	OutputWriter io.Writer
+	// Logger receives the logs, the standard logger is used when not set
+	Logger Logger
+}
+
+func splitListFlag(flag string, delimiter string) []string {
+	if len(flag) == 0 {
+		return []string{}
+	}
+	return strings.Split(flag, delimiter)
+}
+
+// splitNoiseDirs returns the directory names of --noise-dirs or --extra-noise-dirs, without empty ones
+func splitNoiseDirs(flag string) []string {
+	dirnames := []string{}
+	for _, dirname := range splitListFlag(flag, NOISE_DIRS_DELIMITER) {
+		dirname = strings.Trim(strings.TrimSpace(dirname), "/")
+		if dirname != "" {
+			dirnames = append(dirnames, dirname)
+		}
+	}
+	return dirnames
+}
+
+func validateDirectory(dirPath string, createIfNotExist bool) error {
+	info, err := os.Stat(dirPath)
+	if os.IsNotExist(err) {
+		if !createIfNotExist {
+			return fmt.Errorf("directory does not exist at %v", dirPath)
+		}
+		err = os.MkdirAll(dirPath, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create directory at %v: %w", dirPath, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("directory error at %v: %w", dirPath, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("directory is actually a file at %v", dirPath)
+	}
+	return nil
+}
+
+// normalizeSubtree turns the subtree path to the slash separated form of tree paths, without leading or trailing slashes
+func normalizeSubtree(subtree string) string {
+	subtree = strings.Trim(filepath.ToSlash(filepath.Clean(subtree)), "/")
+	if subtree == "." {
+		return ""
+	}
+	return subtree
+}
+
+// withPatternsFile returns the patterns of the file given with --include-from or --exclude-from, followed by the inline patterns
+func withPatternsFile(patterns []string, patternsFilePath string) ([]string, error) {
+	if patternsFilePath == "" {
+		return patterns, nil
+	}
+	filePatterns, err := loadPatternsFile(patternsFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return append(filePatterns, patterns...), nil
+}
+
+// loadPatternsFile returns the patterns of the file, one per line, like rsync and .gitignore files.
+// blank lines and lines starting with # are ignored.
+func loadPatternsFile(patternsFilePath string) ([]string, error) {
+	contents, err := os.ReadFile(patternsFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patterns file at '%v': %v", patternsFilePath, err)
+	}
+	patterns := []string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		pattern := strings.TrimSpace(line)
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// loadRevision returns the revision given with --rev, or the first line of the file given with --rev-file
+func loadRevision(revision string, revisionFilePath string) (string, error) {
+	if revision != "" && revisionFilePath != "" {
+		return "", fmt.Errorf("--rev and --rev-file can't be used together")
+	}
+	if revisionFilePath == "" {
+		if revision == "" {
+			return "", fmt.Errorf("revision is required, set it with --rev or --rev-file")
+		}
+		return revision, nil
+	}
+
+	contents, err := os.ReadFile(revisionFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read revision file at '%v': %v", revisionFilePath, err)
+	}
+	firstLine, _, _ := strings.Cut(string(contents), "\n")
+	revision = strings.TrimSpace(firstLine)
+	if revision == "" {
+		return "", fmt.Errorf("revision file at '%v' is empty", revisionFilePath)
+	}
+	return revision, nil
+}
+
+// writesOutputPath checks whether the snapshot writes to --out, which the modes writing no files ignore
+func (opts *Options) writesOutputPath() bool {
+	return opts.OutputPath != "" && opts.OutputPath != OUTPUT_STDOUT && opts.CompareToDir == "" && opts.ManifestPath == "" && !opts.IndexOnly && !opts.DryRun && !opts.Estimate
+}
+
+// validateUnusedOutputPath checks for --overwrite fail that the output path is an empty or missing directory,
+// or a missing archive file
+func validateUnusedOutputPath(outputPath string) error {
+	entries, err := os.ReadDir(outputPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		if _, statErr := os.Stat(outputPath); statErr == nil {
+			return fmt.Errorf("output path '%v' already exists, and --overwrite is %v", outputPath, OVERWRITE_FAIL)
+		}
+		return fmt.Errorf("failed to read output path '%v': %v", outputPath, err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("output path '%v' is not empty, and --overwrite is %v", outputPath, OVERWRITE_FAIL)
+	}
+	return nil
+}
+
+func validateArchivePath(archivePath string) error {
+	info, err := os.Stat(archivePath)
+	if err == nil && info.IsDir() {
+		return fmt.Errorf("archive path is actually a directory at %v", archivePath)
+	}
+	return validateDirectory(filepath.Dir(archivePath), true)
+}
+
+// parseFilterOptions parses the global flags and the flags selecting the files of the revision. without requiresRevision,
+// the clone and the revision are neither required nor validated.
+func parseFilterOptions(c *cli.Context, requiresRevision bool) (*Options, error) {
+	opts := &Options{
+		ClonePath:                 c.String("src"),
+		GitDir:                    c.String("git-dir"),
+		ObjectsDir:                c.String("objects-dir"),
+		Revision:                  c.String("rev"),
+		SupportShortSha:           c.Bool("short-sha"),
+		VerboseLogging:            c.Bool("verbose"),
+		TextFilesOnly:             c.Bool("text-only"),
+		TextDetect:                c.String("text-detect"),
+		Symlinks:                  c.String("symlinks"),
+		IgnoreCasePatterns:        c.Bool("ignore-case"),
+		MaxFileSizeBytes:          int64(c.Int("max-size")) * 1024 * 1024,
+		IncludeNoiseDirs:          c.Bool("include-noise-dirs"),
+		ExcludeDotfiles:           c.Bool("exclude-dotfiles"),
+		PathsFileLocation:         c.String("paths-file-location"),
+		FetchMissing:              c.Bool("fetch-missing"),
+		MaxFetches:                c.Int("fetch-missing-limit"),
+		SkipSingleAuthorGenerated: c.Bool("skip-single-author-generated"),
+		GeneratedAuthorPattern:    c.String("generated-author-pattern"),
+		GeneratedHistoryLimit:     c.Int("generated-history-limit"),
+		SinceWorkers:              c.Int("since-workers"),
+		VerifySignature:           c.Bool("verify-signature"),
+		KeyringPath:               c.String("keyring"),
+		ApplyGitignore:            c.Bool("apply-gitignore"),
+		Regex:                     c.Bool("regex"),
+		Subtree:                   normalizeSubtree(c.String("subtree")),
+		BaseRevision:              c.String("base-rev"),
+		ResolveLFS:                c.Bool("resolve-lfs"),
+		RecurseSubmodules:         c.Bool("recurse-submodules"),
+		LanguageMapPath:           c.String("lang-map"),
+		ReadRetries:               c.Int("read-retries"),
+		ReadRetryDelay:            c.Duration("read-retry-delay"),
+		Timeout:                   c.Duration("timeout"),
+		Logger:                    NewStdLogger(),
+	}
+
+	var err error
+	if requiresRevision {
+		err = ValidateSource(opts.ClonePath, opts.GitDir, opts.ObjectsDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	delimiter := c.String("pattern-delimiter")
+	if delimiter == "" {
+		return nil, fmt.Errorf("invalid --pattern-delimiter, expected a non empty delimiter")
+	}
+	opts.IncludePatterns = splitListFlag(c.String("include"), delimiter)
+	opts.ExcludePatterns = splitListFlag(c.String("exclude"), delimiter)
+	opts.NameIncludePatterns = splitListFlag(c.String("name-include"), delimiter)
+	opts.NameExcludePatterns = splitListFlag(c.String("name-exclude"), delimiter)
+
+	if requiresRevision {
+		opts.Revision, err = loadRevision(opts.Revision, c.String("rev-file"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opts.IncludePatterns, err = withPatternsFile(opts.IncludePatterns, c.String("include-from"))
+	if err != nil {
+		return nil, err
+	}
+	opts.ExcludePatterns, err = withPatternsFile(opts.ExcludePatterns, c.String("exclude-from"))
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.TextDetect {
+	case TEXT_DETECT_EXTENSION, TEXT_DETECT_CONTENT, TEXT_DETECT_BOTH:
+	default:
+		return nil, fmt.Errorf("invalid --text-detect value '%v', expected one of: %v, %v, %v", opts.TextDetect, TEXT_DETECT_EXTENSION, TEXT_DETECT_CONTENT, TEXT_DETECT_BOTH)
+	}
+
+	switch opts.Symlinks {
+	case SYMLINKS_SKIP, SYMLINKS_FOLLOW, SYMLINKS_RECREATE:
+	default:
+		return nil, fmt.Errorf("invalid --symlinks value '%v', expected one of: %v, %v, %v", opts.Symlinks, SYMLINKS_SKIP, SYMLINKS_FOLLOW, SYMLINKS_RECREATE)
+	}
+
+	if opts.ReadRetries < 1 {
+		return nil, fmt.Errorf("invalid --read-retries %v, expected at least 1", opts.ReadRetries)
+	}
+
+	if opts.ReadRetryDelay <= 0 {
+		return nil, fmt.Errorf("invalid --read-retry-delay %v, expected a positive duration", opts.ReadRetryDelay)
+	}
+
+	if opts.Timeout < 0 {
+		return nil, fmt.Errorf("invalid --timeout %v, expected a positive duration", opts.Timeout)
+	}
+
+	if opts.SkipSingleAuthorGenerated {
+		_, err = regexp.Compile(opts.GeneratedAuthorPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --generated-author-pattern '%v': %v", opts.GeneratedAuthorPattern, err)
+		}
+	}
+
+	if c.String("since") != "" {
+		opts.Since, err = time.Parse(time.RFC3339, c.String("since"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --since '%v', expected an RFC3339 date such as 2024-01-31T00:00:00Z: %v", c.String("since"), err)
+		}
+	}
+
+	if opts.SinceWorkers < 1 {
+		return nil, fmt.Errorf("invalid --since-workers %v, expected at least 1", opts.SinceWorkers)
+	}
+
+	if opts.VerifySignature {
+		if opts.KeyringPath == "" {
+			return nil, fmt.Errorf("--verify-signature requires a keyring, set it with --keyring")
+		}
+		_, err = os.Stat(opts.KeyringPath)
+		if err != nil {
+			return nil, fmt.Errorf("keyring at '%v' is missing or invalid: %v", opts.KeyringPath, err)
+		}
+	}
+
+	if opts.LanguageMapPath != "" {
+		err = stats.LoadLanguageMap(opts.LanguageMapPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Regex {
+		for _, pattern := range union(union(opts.IncludePatterns, opts.ExcludePatterns), union(opts.NameIncludePatterns, opts.NameExcludePatterns)) {
+			_, err = regexp.Compile(strings.TrimPrefix(pattern, "!"))
+			if err != nil {
+				return nil, &util.ErrorWithCode{
+					StatusCode:    util.ERROR_BAD_PATTERN,
+					InternalError: fmt.Errorf("invalid regex pattern '%v': %v", pattern, err),
+				}
+			}
+		}
+	}
+
+	noiseDirectories := append(splitNoiseDirs(c.String("noise-dirs")), splitNoiseDirs(c.String("extra-noise-dirs"))...)
+	if !opts.IncludeNoiseDirs && opts.Regex {
+		opts.ExcludePatterns = union(util.DirectoryExclusionRegexPatterns(noiseDirectories), opts.ExcludePatterns)
+	} else if !opts.IncludeNoiseDirs {
+		opts.ExcludePatterns = union(util.DirectoryExclusionPatterns(noiseDirectories), opts.ExcludePatterns)
+	}
+
+	return opts, nil
+}
+
+// ParseOptions parses the flags of the snapshot command
+func ParseOptions(c *cli.Context) (*Options, error) {
+	opts, err := parseFilterOptions(c, true)
+	if err != nil {
+		return nil, err
+	}
+	opts.OutputPath = c.String("out")
+	opts.OptionalIndexFilePath = c.String("index")
+	opts.IndexOnly = c.Bool("index-only")
+	opts.CreateHashMarkers = c.Bool("hash-markers")
+	opts.HashMarkersDir = c.String("hash-markers-dir")
+	opts.HashAlgorithm = c.String("hash-algo")
+	opts.SkipDoubleCheck = c.Bool("no-double-check")
+	opts.DoubleCheck = c.String("double-check")
+	opts.CompareToDir = c.String("compare-to-dir")
+	opts.OnConflict = c.String("on-conflict")
+	opts.OnLongPath = c.String("on-long-path")
+	opts.IndexLinesOfCode = c.Bool("index-loc")
+	opts.IndexExtended = c.Bool("index-extended")
+	opts.IndexFormat = c.String("index-format")
+	opts.ReplaceConflictingPaths = c.Bool("replace-conflicting-paths")
+	opts.ManifestPath = c.String("manifest-only")
+	opts.ManifestBlame = c.Bool("manifest-blame")
+	opts.BlameMaxCommits = c.Int("blame-max-commits")
+	opts.Workers = c.Int("workers")
+	opts.MaxInflightBytes = c.Int64("max-inflight-bytes")
+	opts.Format = c.String("format")
+	opts.CompressionLevel = c.Int("compression-level")
+	opts.DryRun = c.Bool("dry-run")
+	opts.Estimate = c.Bool("estimate")
+	opts.MaxTotalSizeBytes = int64(c.Int("max-total-size")) * 1024 * 1024
+	opts.PreserveMode = c.Bool("preserve-mode")
+	opts.StripBOM = c.Bool("strip-bom")
+	opts.Encoding = c.String("encoding")
+	opts.Resume = c.Bool("resume")
+	opts.KeepPartial = c.Bool("keep-partial")
+	opts.Overwrite = c.String("overwrite")
+	opts.Prune = c.Bool("prune")
+	opts.VerifyContent = c.Bool("verify-content")
+	opts.DeletionsFilePath = c.String("deletions-file")
+	opts.DetectRenames = c.Bool("detect-renames")
+	opts.RenameThreshold = c.Int("rename-threshold")
+	opts.Progress = c.String("progress")
+	opts.ProgressInterval = c.Duration("progress-interval")
+	opts.FailOnEmpty = c.Bool("fail-on-empty")
+	opts.ChecksumPath = c.String("checksum")
+	opts.WithStatsPath = c.String("with-stats")
+	opts.KeepEmptyDirs = c.Bool("keep-empty-dirs")
+	opts.Flatten = c.Bool("flatten")
+	opts.OutputLayout = c.String("output-layout")
+	opts.Dedup = c.Bool("dedup")
+
+	fileMode, err := strconv.ParseUint(c.String("file-mode"), 8, 32)
+	if err != nil || fileMode > 0777 {
+		return nil, fmt.Errorf("invalid --file-mode '%v', expected octal permissions such as %v", c.String("file-mode"), DEFAULT_FILE_MODE)
+	}
+	opts.FileMode = os.FileMode(fileMode)
+
+	switch opts.OnConflict {
+	case ON_CONFLICT_ERROR, ON_CONFLICT_SKIP, ON_CONFLICT_RENAME:
+	default:
+		return nil, fmt.Errorf("invalid --on-conflict value '%v', expected one of: %v, %v, %v", opts.OnConflict, ON_CONFLICT_ERROR, ON_CONFLICT_SKIP, ON_CONFLICT_RENAME)
+	}
+
+	switch opts.OnLongPath {
+	case ON_LONG_PATH_SKIP, ON_LONG_PATH_FAIL, ON_LONG_PATH_TRUNCATE:
+	default:
+		return nil, fmt.Errorf("invalid --on-long-path value '%v', expected one of: %v, %v, %v", opts.OnLongPath, ON_LONG_PATH_SKIP, ON_LONG_PATH_FAIL, ON_LONG_PATH_TRUNCATE)
+	}
+
+	switch opts.HashAlgorithm {
+	case HASH_ALGO_SHA1, HASH_ALGO_SHA256:
+	default:
+		return nil, fmt.Errorf("invalid --hash-algo value '%v', expected one of: %v, %v", opts.HashAlgorithm, HASH_ALGO_SHA1, HASH_ALGO_SHA256)
+	}
+
+	switch opts.Format {
+	case FORMAT_DIR, FORMAT_TAR, FORMAT_TAR_GZ, FORMAT_ZIP:
+	default:
+		return nil, fmt.Errorf("invalid --format value '%v', expected one of: %v, %v, %v, %v", opts.Format, FORMAT_DIR, FORMAT_TAR, FORMAT_TAR_GZ, FORMAT_ZIP)
+	}
+
+	if opts.Progress != "" && opts.Progress != PROGRESS_JSON {
+		return nil, fmt.Errorf("invalid --progress value '%v', expected %v", opts.Progress, PROGRESS_JSON)
+	}
+
+	if opts.ProgressInterval <= 0 {
+		return nil, fmt.Errorf("invalid --progress-interval %v, expected a positive duration", opts.ProgressInterval)
+	}
+
+	if opts.CompressionLevel != gzip.DefaultCompression && (opts.CompressionLevel < gzip.NoCompression || opts.CompressionLevel > gzip.BestCompression) {
+		return nil, fmt.Errorf("invalid --compression-level %v, expected a value between %v and %v", opts.CompressionLevel, gzip.NoCompression, gzip.BestCompression)
+	}
+
+	if opts.DryRun && opts.Format != FORMAT_DIR {
+		return nil, fmt.Errorf("--dry-run can't be used with --format %v", opts.Format)
+	}
+
+	if opts.Estimate && opts.DryRun {
+		return nil, fmt.Errorf("--estimate can't be used with --dry-run")
+	}
+
+	if opts.WithStatsPath != "" && (opts.IndexOnly || opts.DryRun || opts.Estimate || opts.ManifestPath != "" || opts.CompareToDir != "") {
+		return nil, fmt.Errorf("--with-stats can't be used with --index-only, --dry-run, --estimate, --manifest-only or --compare-to-dir, which write no files")
+	}
+
+	if opts.FailOnEmpty && opts.IndexOnly {
+		return nil, fmt.Errorf("--fail-on-empty can't be used with --index-only, which writes no files")
+	}
+
+	if opts.KeepEmptyDirs && opts.Format != FORMAT_DIR {
+		return nil, fmt.Errorf("--keep-empty-dirs can't be used with --format %v", opts.Format)
+	}
+
+	if opts.Flatten && opts.KeepEmptyDirs {
+		return nil, fmt.Errorf("--flatten and --keep-empty-dirs can't be used together")
+	}
+
+	if opts.Flatten && opts.Symlinks == SYMLINKS_RECREATE {
+		return nil, fmt.Errorf("--flatten can't be used with --symlinks %v, the link targets would not resolve", SYMLINKS_RECREATE)
+	}
+
+	switch opts.OutputLayout {
+	case OUTPUT_LAYOUT_MIRROR, "":
+	case OUTPUT_LAYOUT_SHARDED:
+		if opts.Flatten || opts.KeepEmptyDirs {
+			return nil, fmt.Errorf("--output-layout %v can't be used with --flatten or --keep-empty-dirs", OUTPUT_LAYOUT_SHARDED)
+		}
+		if opts.Symlinks == SYMLINKS_RECREATE {
+			return nil, fmt.Errorf("--output-layout %v can't be used with --symlinks %v, the link targets would not resolve", OUTPUT_LAYOUT_SHARDED, SYMLINKS_RECREATE)
+		}
+	default:
+		return nil, fmt.Errorf("invalid --output-layout value '%v', expected one of: %v, %v", opts.OutputLayout, OUTPUT_LAYOUT_MIRROR, OUTPUT_LAYOUT_SHARDED)
+	}
+
+	if opts.Prune && (opts.Format != FORMAT_DIR || opts.IndexOnly) {
+		return nil, fmt.Errorf("--prune requires --format %v and can't be used with --index-only", FORMAT_DIR)
+	}
+
+	if opts.VerifyContent && opts.Format != FORMAT_DIR {
+		return nil, fmt.Errorf("--verify-content can't be used with --format %v", opts.Format)
+	}
+
+	if opts.Resume && opts.Format != FORMAT_DIR {
+		return nil, fmt.Errorf("--resume can't be used with --format %v", opts.Format)
+	}
+
+	if opts.KeepPartial && opts.Format != FORMAT_DIR {
+		return nil, fmt.Errorf("--keep-partial can't be used with --format %v", opts.Format)
+	}
+
+	if opts.Dedup && opts.Format != FORMAT_DIR {
+		return nil, fmt.Errorf("--dedup can't be used with --format %v", opts.Format)
+	}
+
+	if opts.Symlinks == SYMLINKS_RECREATE && opts.Format != FORMAT_DIR {
+		return nil, fmt.Errorf("--symlinks %v can't be used with --format %v", SYMLINKS_RECREATE, opts.Format)
+	}
+
+	if opts.HashMarkersDir != "" {
+		if opts.CreateHashMarkers {
+			return nil, fmt.Errorf("--hash-markers and --hash-markers-dir can't be used together")
+		}
+		err = validateDirectory(opts.HashMarkersDir, true)
+		if err != nil {
+			return nil, &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+				InternalError: fmt.Errorf("hash markers directory at '%v' is invalid: %v", opts.HashMarkersDir, err),
+			}
+		}
+	}
+
+	if opts.DeletionsFilePath != "" && opts.BaseRevision == "" {
+		return nil, fmt.Errorf("--deletions-file requires a base revision, set it with --base-rev")
+	}
+
+	if opts.DetectRenames && opts.BaseRevision == "" {
+		return nil, fmt.Errorf("--detect-renames requires a base revision, set it with --base-rev")
+	}
+
+	if opts.RenameThreshold < 0 || opts.RenameThreshold > 100 {
+		return nil, fmt.Errorf("invalid --rename-threshold %v, expected a percentage between 0 and 100", opts.RenameThreshold)
+	}
+
+	if opts.IndexLinesOfCode && opts.OptionalIndexFilePath == "" {
+		return nil, fmt.Errorf("--index-loc requires an index file, set it with --index")
+	}
+
+	if opts.IndexExtended && opts.OptionalIndexFilePath == "" {
+		return nil, fmt.Errorf("--index-extended requires an index file, set it with --index")
+	}
+
+	switch opts.IndexFormat {
+	case INDEX_FORMAT_TSV, INDEX_FORMAT_CSV, INDEX_FORMAT_JSON:
+	default:
+		return nil, fmt.Errorf("invalid --index-format value '%v', expected one of: %v, %v, %v", opts.IndexFormat, INDEX_FORMAT_TSV, INDEX_FORMAT_CSV, INDEX_FORMAT_JSON)
+	}
+
+	if opts.IndexOnly && opts.OptionalIndexFilePath == "" {
+		return nil, fmt.Errorf("--index-only requires an index file, set it with --index")
+	}
+
+	if opts.OutputPath == "" && opts.CompareToDir == "" && opts.ManifestPath == "" && !opts.IndexOnly && !opts.DryRun && !opts.Estimate {
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+			InternalError: fmt.Errorf("output path is required, set it with --out"),
+		}
+	}
+
+	if opts.Encoding != "" && opts.Encoding != ENCODING_UTF8 {
+		return nil, fmt.Errorf("invalid --encoding value '%v', expected %v", opts.Encoding, ENCODING_UTF8)
+	}
+
+	switch opts.DoubleCheck {
+	case DOUBLE_CHECK_COUNT, DOUBLE_CHECK_HASH:
+	default:
+		return nil, fmt.Errorf("invalid --double-check value '%v', expected one of: %v, %v", opts.DoubleCheck, DOUBLE_CHECK_COUNT, DOUBLE_CHECK_HASH)
+	}
+
+	switch opts.Overwrite {
+	case OVERWRITE_FAIL, OVERWRITE_REPLACE, OVERWRITE_SKIP:
+	default:
+		return nil, fmt.Errorf("invalid --overwrite value '%v', expected one of: %v, %v, %v", opts.Overwrite, OVERWRITE_FAIL, OVERWRITE_REPLACE, OVERWRITE_SKIP)
+	}
+
+	if opts.Resume && opts.Overwrite == OVERWRITE_FAIL {
+		return nil, fmt.Errorf("--resume can't be used with --overwrite %v", OVERWRITE_FAIL)
+	}
+
+	if opts.Overwrite == OVERWRITE_FAIL && opts.writesOutputPath() {
+		err = validateUnusedOutputPath(opts.OutputPath)
+		if err != nil {
+			return nil, &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+				InternalError: err,
+			}
+		}
+	}
+
+	if opts.ManifestBlame && opts.ManifestPath == "" {
+		return nil, fmt.Errorf("--manifest-blame requires a manifest, set it with --manifest-only")
+	}
+
+	if opts.MaxInflightBytes < 0 {
+		return nil, fmt.Errorf("invalid --max-inflight-bytes %v, expected 0 or more", opts.MaxInflightBytes)
+	}
+
+	if opts.BlameMaxCommits < 1 {
+		return nil, fmt.Errorf("invalid --blame-max-commits %v, expected at least 1", opts.BlameMaxCommits)
+	}
+
+	if opts.ManifestPath != "" {
+		err = validateDirectory(filepath.Dir(opts.ManifestPath), false)
+		if err != nil {
+			return nil, &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+				InternalError: fmt.Errorf("manifest directory of '%v' is missing or invalid: %v", opts.ManifestPath, err),
+			}
+		}
+	} else if opts.CompareToDir != "" {
+		err = validateDirectory(opts.CompareToDir, false)
+		if err != nil {
+			return nil, &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+				InternalError: fmt.Errorf("compare directory at '%v' is missing or invalid: %v", opts.CompareToDir, err),
+			}
+		}
+	} else if opts.DryRun || opts.Estimate {
+		// nothing is written, so the output path is not created
+	} else if opts.OutputPath == OUTPUT_STDOUT {
+		if opts.Format == FORMAT_DIR {
+			opts.Format = FORMAT_TAR
+		}
+	} else if !opts.IndexOnly && opts.Format != FORMAT_DIR {
+		err = validateArchivePath(opts.OutputPath)
+		if err != nil {
+			return nil, &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+				InternalError: err,
+			}
+		}
+	} else if !opts.IndexOnly {
+		err = validateDirectory(opts.OutputPath, true)
+		if err != nil {
+			return nil, &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+				InternalError: err,
+			}
+		}
+	}
+
+	// checked once the output path was created, as it may hold the stats file
+	if opts.WithStatsPath != "" {
+		err = validateDirectory(filepath.Dir(opts.WithStatsPath), false)
+		if err != nil {
+			return nil, &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+				InternalError: fmt.Errorf("stats directory of '%v' is missing or invalid: %v", opts.WithStatsPath, err),
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+// ParseStatsOptions parses the flags of the stats command
+func ParseStatsOptions(c *cli.Context) (*Options, error) {
+	statsDir := c.String("dir")
+	opts, err := parseFilterOptions(c, statsDir == "")
+	if err != nil {
+		return nil, err
+	}
+	opts.StatsDir = statsDir
+	opts.StatsPath = c.String("out")
+	opts.StatsFormat = c.String("stats-format")
+	opts.StatsDetailsPath = c.String("stats-detailed")
+	opts.StatsTopLanguages = c.Int("stats-top")
+	opts.StatsDetectShebang = c.Bool("stats-detect-shebang")
+
+	switch opts.StatsFormat {
+	case STATS_FORMAT_JSON, STATS_FORMAT_CSV:
+	default:
+		return nil, fmt.Errorf("invalid --stats-format value '%v', expected one of: %v, %v", opts.StatsFormat, STATS_FORMAT_JSON, STATS_FORMAT_CSV)
+	}
+
+	if opts.StatsTopLanguages < 0 {
+		return nil, fmt.Errorf("invalid --stats-top %v, expected 0 or a positive number of languages", opts.StatsTopLanguages)
+	}
+
+	if opts.StatsPath == "" {
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+			InternalError: fmt.Errorf("stats path is required, set it with --out"),
+		}
+	}
+	err = validateDirectory(filepath.Dir(opts.StatsPath), false)
+	if err != nil {
+		return nil, &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_OUTPUT_PATH,
+			InternalError: fmt.Errorf("stats directory of '%v' is missing or invalid: %v", opts.StatsPath, err),
+		}
+	}
+
+	if opts.StatsDir != "" {
+		for _, name := range revisionOnlyFlags {
+			if c.IsSet(name) {
+				return nil, fmt.Errorf("--dir can't be used with --%v, which applies to the files of a revision", name)
+			}
+		}
+		err = validateDirectory(opts.StatsDir, false)
+		if err != nil {
+			return nil, &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_CLONE_PATH,
+				InternalError: fmt.Errorf("stats directory at '%v' is missing or invalid: %v", opts.StatsDir, err),
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+// ValidateSource checks the clone and the --git-dir and --objects-dir overrides of its storage, when set
+func ValidateSource(clonePath string, gitDir string, objectsDir string) error {
+	if gitDir == "" {
+		err := ValidateClonePath(clonePath)
+		if err != nil {
+			return err
+		}
+	} else {
+		if clonePath == "" {
+			return &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_CLONE_PATH,
+				InternalError: fmt.Errorf("clone path is required, set it with --src"),
+			}
+		}
+		err := validateDirectory(clonePath, false)
+		if err != nil {
+			return &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_CLONE_PATH,
+				InternalError: fmt.Errorf("clone at '%v' is missing or invalid: %v", clonePath, err),
+			}
+		}
+		err = validateDirectory(gitDir, false)
+		if err != nil {
+			return &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_CLONE_PATH,
+				InternalError: fmt.Errorf("--git-dir at '%v' is missing or invalid: %v", gitDir, err),
+			}
+		}
+	}
+
+	if objectsDir != "" {
+		err := validateDirectory(objectsDir, false)
+		if err != nil {
+			return &util.ErrorWithCode{
+				StatusCode:    util.ERROR_BAD_CLONE_PATH,
+				InternalError: fmt.Errorf("--objects-dir at '%v' is missing or invalid: %v", objectsDir, err),
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateClonePath checks the clone and its .git are existing directories
+func ValidateClonePath(clonePath string) error {
+	if clonePath == "" {
+		return &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_CLONE_PATH,
+			InternalError: fmt.Errorf("clone path is required, set it with --src"),
+		}
+	}
+
+	err := validateDirectory(clonePath, false)
+	if err != nil {
+		return &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_CLONE_PATH,
+			InternalError: fmt.Errorf("clone at '%v' is missing or invalid: %v", clonePath, err),
+		}
+	}
+
+	_, err = util.ResolveGitDir(clonePath)
+	if err != nil {
+		return &util.ErrorWithCode{
+			StatusCode:    util.ERROR_BAD_CLONE_PATH,
+			InternalError: fmt.Errorf(".git at '%v' is missing or invalid, and it is not a bare repository: %v", clonePath, err),
+		}
+	}
+	return nil
 }
 
 func union(s1 []string, s2 []string) []string {
@@ -0,0 +1,261 @@
+package options
+
+import (
+	"errors"
+	"gitsnap/util"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writePatternsFile(t *testing.T, contents string) string {
+	patternsFilePath := filepath.Join(t.TempDir(), "patterns")
+	require.Nil(t, os.WriteFile(patternsFilePath, []byte(contents), 0644))
+	return patternsFilePath
+}
+
+func TestLoadPatternsFile(t *testing.T) {
+	patternsFilePath := writePatternsFile(t, "# sources\n**/*.go\n\n  **/*.java  \r\n#**/*.kt\n   \n!**/vendor/keep.go\n")
+	patterns, err := loadPatternsFile(patternsFilePath)
+	require.Nil(t, err)
+	require.Equal(t, []string{"**/*.go", "**/*.java", "!**/vendor/keep.go"}, patterns)
+
+	patterns, err = loadPatternsFile(writePatternsFile(t, "# nothing but comments\n\n"))
+	require.Nil(t, err)
+	require.Empty(t, patterns)
+
+	_, err = loadPatternsFile(filepath.Join(t.TempDir(), "missing"))
+	require.NotNil(t, err)
+}
+
+func TestWithPatternsFile(t *testing.T) {
+	patternsFilePath := writePatternsFile(t, "**/vendor/**\n# keep the license\n")
+
+	patterns, err := withPatternsFile([]string{"!**/vendor/LICENSE"}, patternsFilePath)
+	require.Nil(t, err)
+	// the inline patterns come last, so they decide over the file patterns
+	require.Equal(t, []string{"**/vendor/**", "!**/vendor/LICENSE"}, patterns)
+
+	patterns, err = withPatternsFile([]string{"*.go"}, "")
+	require.Nil(t, err)
+	require.Equal(t, []string{"*.go"}, patterns)
+}
+
+func TestParseArgsWithPatternsFiles(t *testing.T) {
+	clonePath := t.TempDir()
+	require.Nil(t, os.Mkdir(filepath.Join(clonePath, ".git"), 0755))
+	includeFilePath := writePatternsFile(t, "# sources\n**/*.go\n\n**/*.java\n")
+	excludeFilePath := writePatternsFile(t, "**/test/**\n")
+
+	opts, err := ParseArgs([]string{
+		"--src", clonePath,
+		"--rev", "HEAD",
+		"--out", t.TempDir(),
+		"--include-from", includeFilePath,
+		"--include", "pom.xml",
+		"--exclude-from", excludeFilePath,
+		"--exclude", "!**/test/keep.go",
+		"--include-noise-dirs",
+	})
+	require.Nil(t, err)
+	require.Equal(t, []string{"**/*.go", "**/*.java", "pom.xml"}, opts.IncludePatterns)
+	require.Equal(t, []string{"**/test/**", "!**/test/keep.go"}, opts.ExcludePatterns)
+
+	_, err = ParseArgs([]string{"--src", clonePath, "--rev", "HEAD", "--out", t.TempDir(), "--include-from", filepath.Join(t.TempDir(), "missing")})
+	require.NotNil(t, err)
+}
+
+func TestSplitListFlag(t *testing.T) {
+	require.Equal(t, []string{}, splitListFlag("", ","))
+	require.Equal(t, []string{"**/*.go", "pom.xml"}, splitListFlag("**/*.go,pom.xml", ","))
+	require.Equal(t, []string{"**/*.{js,ts}", "pom.xml"}, splitListFlag("**/*.{js,ts};pom.xml", ";"))
+	require.Equal(t, []string{"docs/a,b.md"}, splitListFlag("docs/a,b.md", "\n"))
+}
+
+func TestParseArgsWithPatternDelimiter(t *testing.T) {
+	clonePath := t.TempDir()
+	require.Nil(t, os.Mkdir(filepath.Join(clonePath, ".git"), 0755))
+	args := []string{"--src", clonePath, "--rev", "HEAD", "--out", t.TempDir(), "--include-noise-dirs"}
+
+	opts, err := ParseArgs(append(args, "--include", "**/*.{js,ts},pom.xml"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"**/*.{js", "ts}", "pom.xml"}, opts.IncludePatterns)
+
+	opts, err = ParseArgs(append(args, "--pattern-delimiter", ";", "--include", "**/*.{js,ts};pom.xml", "--exclude", "docs/a,b.md"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"**/*.{js,ts}", "pom.xml"}, opts.IncludePatterns)
+	require.Equal(t, []string{"docs/a,b.md"}, opts.ExcludePatterns)
+
+	_, err = ParseArgs(append(args, "--pattern-delimiter", ""))
+	require.NotNil(t, err)
+}
+
+func TestParseArgsWithDetectRenames(t *testing.T) {
+	clonePath := t.TempDir()
+	require.Nil(t, os.Mkdir(filepath.Join(clonePath, ".git"), 0755))
+	args := []string{"--src", clonePath, "--rev", "HEAD", "--out", t.TempDir(), "--detect-renames"}
+
+	opts, err := ParseArgs(append(args, "--base-rev", "HEAD~1"))
+	require.Nil(t, err)
+	require.True(t, opts.DetectRenames)
+	require.Equal(t, DEFAULT_RENAME_THRESHOLD, opts.RenameThreshold)
+
+	_, err = ParseArgs(args)
+	require.NotNil(t, err)
+
+	_, err = ParseArgs(append(args, "--base-rev", "HEAD~1", "--rename-threshold", "101"))
+	require.NotNil(t, err)
+}
+
+func TestValidateClonePath(t *testing.T) {
+	clonePath := t.TempDir()
+	require.Nil(t, os.Mkdir(filepath.Join(clonePath, ".git"), 0755))
+	require.Nil(t, ValidateClonePath(clonePath))
+
+	barePath := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(barePath, "HEAD"), []byte("ref: refs/heads/main\n"), 0644))
+	require.Nil(t, os.Mkdir(filepath.Join(barePath, "objects"), 0755))
+	require.Nil(t, os.Mkdir(filepath.Join(barePath, "refs"), 0755))
+	require.Nil(t, ValidateClonePath(barePath))
+
+	// a .git file pointing to a git directory relative to the clone
+	worktreePath := filepath.Join(t.TempDir(), "worktree")
+	require.Nil(t, os.MkdirAll(filepath.Join(filepath.Dir(worktreePath), "repository.git"), 0755))
+	require.Nil(t, os.Mkdir(worktreePath, 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(worktreePath, ".git"), []byte("gitdir: ../repository.git\n"), 0644))
+	require.Nil(t, ValidateClonePath(worktreePath))
+
+	danglingPath := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(danglingPath, ".git"), []byte("gitdir: /missing/worktrees/dangling\n"), 0644))
+
+	for _, invalidPath := range []string{"", t.TempDir(), filepath.Join(t.TempDir(), "missing"), danglingPath} {
+		err := ValidateClonePath(invalidPath)
+		var errorWithCode *util.ErrorWithCode
+		require.True(t, errors.As(err, &errorWithCode), "'%v' should be invalid", invalidPath)
+		require.Equal(t, util.ERROR_BAD_CLONE_PATH, errorWithCode.StatusCode)
+	}
+}
+
+func TestValidateSource(t *testing.T) {
+	// with --git-dir, the clone needs no .git
+	require.Nil(t, ValidateSource(t.TempDir(), t.TempDir(), ""))
+	require.Nil(t, ValidateSource(t.TempDir(), t.TempDir(), t.TempDir()))
+
+	for _, source := range [][]string{
+		{"", t.TempDir(), ""},
+		{t.TempDir(), filepath.Join(t.TempDir(), "missing"), ""},
+		{t.TempDir(), "", ""},
+		{t.TempDir(), t.TempDir(), filepath.Join(t.TempDir(), "missing")},
+	} {
+		err := ValidateSource(source[0], source[1], source[2])
+		var errorWithCode *util.ErrorWithCode
+		require.True(t, errors.As(err, &errorWithCode), "%v should be invalid", source)
+		require.Equal(t, util.ERROR_BAD_CLONE_PATH, errorWithCode.StatusCode)
+	}
+}
+
+func TestParseArgsWithIndex(t *testing.T) {
+	clonePath := t.TempDir()
+	require.Nil(t, os.Mkdir(filepath.Join(clonePath, ".git"), 0755))
+	indexPath := filepath.Join(t.TempDir(), "index.tsv")
+	args := []string{"--src", clonePath, "--rev", "HEAD"}
+
+	// the index is written along with the files
+	opts, err := ParseArgs(append(args, "--out", t.TempDir(), "--index", indexPath))
+	require.Nil(t, err)
+	require.Equal(t, indexPath, opts.OptionalIndexFilePath)
+	require.False(t, opts.IndexOnly)
+
+	// no files are written, so no output path is needed
+	opts, err = ParseArgs(append(args, "--index", indexPath, "--index-only"))
+	require.Nil(t, err)
+	require.Equal(t, indexPath, opts.OptionalIndexFilePath)
+	require.True(t, opts.IndexOnly)
+
+	_, err = ParseArgs(append(args, "--out", t.TempDir(), "--index-only"))
+	require.NotNil(t, err)
+
+	// aliases are read like the flag names
+	opts, err = ParseArgs(append(args, "--index-file", indexPath, "--xo", "--paths-file=-"))
+	require.Nil(t, err)
+	require.Equal(t, indexPath, opts.OptionalIndexFilePath)
+	require.True(t, opts.IndexOnly)
+	require.Equal(t, PATHS_FILE_STDIN, opts.PathsFileLocation)
+}
+
+func TestParseArgsWithOverwrite(t *testing.T) {
+	clonePath := t.TempDir()
+	require.Nil(t, os.Mkdir(filepath.Join(clonePath, ".git"), 0755))
+	args := []string{"--src", clonePath, "--rev", "HEAD"}
+
+	outputPath := t.TempDir()
+	opts, err := ParseArgs(append(args, "--out", outputPath))
+	require.Nil(t, err)
+	require.Equal(t, OVERWRITE_REPLACE, opts.Overwrite)
+
+	// an empty or missing output path is not overwritten
+	_, err = ParseArgs(append(args, "--out", outputPath, "--overwrite", OVERWRITE_FAIL))
+	require.Nil(t, err)
+	_, err = ParseArgs(append(args, "--out", filepath.Join(outputPath, "new"), "--overwrite", OVERWRITE_FAIL))
+	require.Nil(t, err)
+
+	require.Nil(t, os.WriteFile(filepath.Join(outputPath, "file.txt"), []byte("file"), 0644))
+	_, err = ParseArgs(append(args, "--out", outputPath, "--overwrite", OVERWRITE_FAIL))
+	var errorWithCode *util.ErrorWithCode
+	require.ErrorAs(t, err, &errorWithCode)
+	require.Equal(t, util.ERROR_BAD_OUTPUT_PATH, errorWithCode.StatusCode)
+	_, err = ParseArgs(append(args, "--out", filepath.Join(outputPath, "file.txt"), "--format", FORMAT_TAR, "--overwrite", OVERWRITE_FAIL))
+	require.NotNil(t, err)
+	// nothing is written to the output path
+	_, err = ParseArgs(append(args, "--out", outputPath, "--overwrite", OVERWRITE_FAIL, "--dry-run"))
+	require.Nil(t, err)
+
+	_, err = ParseArgs(append(args, "--out", outputPath, "--overwrite", OVERWRITE_SKIP))
+	require.Nil(t, err)
+	_, err = ParseArgs(append(args, "--out", outputPath, "--overwrite", "merge"))
+	require.NotNil(t, err)
+}
+
+func TestParseArgsWithDoubleCheck(t *testing.T) {
+	clonePath := t.TempDir()
+	require.Nil(t, os.Mkdir(filepath.Join(clonePath, ".git"), 0755))
+	args := []string{"--src", clonePath, "--rev", "HEAD", "--out", t.TempDir()}
+
+	opts, err := ParseArgs(args)
+	require.Nil(t, err)
+	require.Equal(t, DOUBLE_CHECK_COUNT, opts.DoubleCheck)
+
+	opts, err = ParseArgs(append(args, "--double-check", DOUBLE_CHECK_HASH))
+	require.Nil(t, err)
+	require.Equal(t, DOUBLE_CHECK_HASH, opts.DoubleCheck)
+
+	_, err = ParseArgs(append(args, "--double-check", "none"))
+	require.NotNil(t, err)
+}
+
+func TestParseStatsArgsWithDir(t *testing.T) {
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+	dirPath := t.TempDir()
+
+	opts, err := ParseStatsArgs([]string{"--out", statsPath, "--dir", dirPath, "--include", "**/*.go"})
+	require.Nil(t, err)
+	require.Equal(t, dirPath, opts.StatsDir)
+	require.Equal(t, []string{"**/*.go"}, opts.IncludePatterns)
+
+	// the clone and the revision are required only without --dir
+	_, err = ParseStatsArgs([]string{"--out", statsPath})
+	require.NotNil(t, err)
+	_, err = ParseStatsArgs([]string{"--out", statsPath, "--dir", dirPath, "--rev", "master"})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "--rev")
+	_, err = ParseStatsArgs([]string{"--out", statsPath, "--dir", dirPath, "--base-rev", "master"})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "--base-rev")
+
+	_, err = ParseStatsArgs([]string{"--out", statsPath, "--dir", filepath.Join(dirPath, "missing")})
+	var errorWithCode *util.ErrorWithCode
+	require.ErrorAs(t, err, &errorWithCode)
+	require.Equal(t, util.ERROR_BAD_CLONE_PATH, errorWithCode.StatusCode)
+}
@@ -0,0 +1,29 @@
+package parallel
+
+import "context"
+
+// Limiter runs jobs on the goroutines of their callers, allowing no more than a fixed number of them at once.
+// unlike JobQueue, a failed job doesn't affect the others.
+type Limiter struct {
+	slots chan struct{}
+}
+
+func NewLimiter(limit int) *Limiter {
+	if limit < 1 {
+		limit = 1
+	}
+	return &Limiter{
+		slots: make(chan struct{}, limit),
+	}
+}
+
+// Run waits for a free slot and runs the job in it, unless the context is done first
+func (limiter *Limiter) Run(ctx context.Context, job Job) error {
+	select {
+	case limiter.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-limiter.slots }()
+	return job()
+}
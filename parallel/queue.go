@@ -0,0 +1,73 @@
+package parallel
+
+import "sync"
+
+type Job func() error
+
+// JobQueue runs submitted jobs on a fixed pool of workers and keeps the first error returned by any of them.
+// once a job failed, jobs which did not start yet are dropped.
+type JobQueue struct {
+	jobs     chan Job
+	wg       sync.WaitGroup
+	errMutex sync.Mutex
+	err      error
+}
+
+func NewJobQueue(poolSize int) *JobQueue {
+	if poolSize < 1 {
+		poolSize = 1
+	}
+	queue := &JobQueue{
+		jobs: make(chan Job, poolSize),
+	}
+	queue.wg.Add(poolSize)
+	for i := 0; i < poolSize; i++ {
+		go queue.work()
+	}
+	return queue
+}
+
+func (queue *JobQueue) work() {
+	defer queue.wg.Done()
+	for job := range queue.jobs {
+		if queue.Err() != nil {
+			continue
+		}
+		err := job()
+		if err != nil {
+			queue.setErr(err)
+		}
+	}
+}
+
+func (queue *JobQueue) setErr(err error) {
+	queue.errMutex.Lock()
+	defer queue.errMutex.Unlock()
+	if queue.err == nil {
+		queue.err = err
+	}
+}
+
+// Err returns the first error observed so far, if any
+func (queue *JobQueue) Err() error {
+	queue.errMutex.Lock()
+	defer queue.errMutex.Unlock()
+	return queue.err
+}
+
+// Submit queues the job, unless a previous job already failed in which case that error is returned
+func (queue *JobQueue) Submit(job Job) error {
+	err := queue.Err()
+	if err != nil {
+		return err
+	}
+	queue.jobs <- job
+	return nil
+}
+
+// Wait stops accepting jobs, waits for the queued ones to finish and returns the first error observed
+func (queue *JobQueue) Wait() error {
+	close(queue.jobs)
+	queue.wg.Wait()
+	return queue.Err()
+}
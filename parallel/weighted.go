@@ -0,0 +1,48 @@
+package parallel
+
+import "sync"
+
+// Weighted is a semaphore of a total weight, such as bytes in memory, shared by jobs of different weights.
+// jobs acquire it in their order of arrival, so a heavy one is not starved by lighter ones.
+type Weighted struct {
+	capacity int64
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	inUse    int64
+	// tickets of the arrived and admitted jobs, for the order of arrival
+	nextTicket    uint64
+	servingTicket uint64
+}
+
+func NewWeighted(capacity int64) *Weighted {
+	weighted := &Weighted{capacity: capacity}
+	weighted.cond = sync.NewCond(&weighted.mutex)
+	return weighted
+}
+
+// Acquire waits until the weight fits and takes it. a weight over the capacity waits for the semaphore to be free
+// and then runs alone. it returns the weight taken, to be released.
+func (weighted *Weighted) Acquire(weight int64) int64 {
+	if weight > weighted.capacity {
+		weight = weighted.capacity
+	}
+	weighted.mutex.Lock()
+	defer weighted.mutex.Unlock()
+	ticket := weighted.nextTicket
+	weighted.nextTicket++
+	for ticket != weighted.servingTicket || weighted.inUse+weight > weighted.capacity {
+		weighted.cond.Wait()
+	}
+	weighted.servingTicket++
+	weighted.inUse += weight
+	// the next job may fit as well
+	weighted.cond.Broadcast()
+	return weight
+}
+
+func (weighted *Weighted) Release(weight int64) {
+	weighted.mutex.Lock()
+	defer weighted.mutex.Unlock()
+	weighted.inUse -= weight
+	weighted.cond.Broadcast()
+}
@@ -0,0 +1,44 @@
+package parallel
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// maxConcurrency runs jobs of the weights at once under the semaphore and returns the most of them which ran together
+func maxConcurrency(weighted *Weighted, weights []int64) int32 {
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	for _, weight := range weights {
+		wg.Add(1)
+		go func(weight int64) {
+			defer wg.Done()
+			taken := weighted.Acquire(weight)
+			defer weighted.Release(taken)
+			current := running.Add(1)
+			for {
+				max := maxRunning.Load()
+				if current <= max || maxRunning.CompareAndSwap(max, current) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+		}(weight)
+	}
+	wg.Wait()
+	return maxRunning.Load()
+}
+
+func TestWeighted(t *testing.T) {
+	weighted := NewWeighted(100)
+	// large jobs run one at a time, and a job over the capacity still runs
+	require.Equal(t, int32(1), maxConcurrency(weighted, []int64{80, 80, 80, 250}))
+	// small ones run together
+	require.Equal(t, int32(4), maxConcurrency(weighted, []int64{10, 10, 10, 10}))
+	require.Equal(t, int32(2), maxConcurrency(weighted, []int64{50, 50, 50, 50}))
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"gitsnap/git"
+	"gitsnap/options"
+	"gitsnap/parallel"
+	"gitsnap/util"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	SNAPSHOT_PATH      = "/snapshot"
+	TAR_CONTENT_TYPE   = "application/x-tar"
+	DEFAULT_ADDRESS    = ":8080"
+	REVISION_PARAMETER = "rev"
+	INCLUDE_PARAMETER  = "include"
+	EXCLUDE_PARAMETER  = "exclude"
+	PATTERNS_DELIMITER = ","
+)
+
+// Server serves tar snapshots of the revisions of a single clone over HTTP, at GET /snapshot?rev=...&include=...&exclude=...
+type Server struct {
+	clonePath string
+	// --git-dir and --objects-dir of the clone, empty when not overridden
+	gitDir     string
+	objectsDir string
+	limiter    *parallel.Limiter
+}
+
+// NewServer returns a server of the clone, running no more than maxConcurrency snapshots at once
+func NewServer(clonePath string, gitDir string, objectsDir string, maxConcurrency int) *Server {
+	return &Server{
+		clonePath:  clonePath,
+		gitDir:     gitDir,
+		objectsDir: objectsDir,
+		limiter:    parallel.NewLimiter(maxConcurrency),
+	}
+}
+
+func (server *Server) ListenAndServe(addr string) error {
+	log.Printf("serving snapshots of '%v' at %v%v", server.clonePath, addr, SNAPSHOT_PATH)
+	return http.ListenAndServe(addr, server)
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != SNAPSHOT_PATH {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, fmt.Sprintf("method %v is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	revision := query.Get(REVISION_PARAMETER)
+	if revision == "" {
+		http.Error(w, fmt.Sprintf("the %v parameter is required", REVISION_PARAMETER), http.StatusBadRequest)
+		return
+	}
+	args := []string{"--src", server.clonePath, "--rev", revision, "--out", options.OUTPUT_STDOUT, "--format", options.FORMAT_TAR}
+	if server.gitDir != "" {
+		args = append(args, "--git-dir", server.gitDir)
+	}
+	if server.objectsDir != "" {
+		args = append(args, "--objects-dir", server.objectsDir)
+	}
+	if include := query[INCLUDE_PARAMETER]; len(include) > 0 {
+		args = append(args, "--include", strings.Join(include, PATTERNS_DELIMITER))
+	}
+	if exclude := query[EXCLUDE_PARAMETER]; len(exclude) > 0 {
+		args = append(args, "--exclude", strings.Join(exclude, PATTERNS_DELIMITER))
+	}
+
+	// validated like the command line, so a bad request fails before taking a slot
+	opts, err := options.ParseArgs(args)
+	if err != nil {
+		http.Error(w, err.Error(), requestErrorStatus(err))
+		return
+	}
+	stream := &tarStream{writer: w}
+	opts.OutputWriter = stream
+
+	err = server.limiter.Run(r.Context(), func() error {
+		// a client which went away cancels its snapshot
+		return git.SnapshotContext(r.Context(), opts)
+	})
+	if err == nil {
+		// an empty snapshot still returns an empty archive
+		stream.start()
+		return
+	}
+	if stream.started {
+		// the status was sent already, so the client gets a truncated archive
+		log.Printf("failed streaming snapshot of '%v' after it started: %v", revision, err)
+		return
+	}
+	log.Printf("failed snapshot of '%v': %v", revision, err)
+	http.Error(w, err.Error(), snapshotErrorStatus(err))
+}
+
+// tarStream sends the response headers on the first write of the archive, so failures before it can still be reported
+type tarStream struct {
+	writer  http.ResponseWriter
+	started bool
+}
+
+func (stream *tarStream) start() {
+	if stream.started {
+		return
+	}
+	stream.started = true
+	stream.writer.Header().Set("Content-Type", TAR_CONTENT_TYPE)
+	stream.writer.WriteHeader(http.StatusOK)
+}
+
+func (stream *tarStream) Write(p []byte) (int, error) {
+	stream.start()
+	return stream.writer.Write(p)
+}
+
+func errorCode(err error) int {
+	var errorWithCode *util.ErrorWithCode
+	if errors.As(err, &errorWithCode) {
+		return errorWithCode.StatusCode
+	}
+	return 0
+}
+
+// requestErrorStatus maps errors of parsing the request, which are mostly invalid parameters
+func requestErrorStatus(err error) int {
+	switch errorCode(err) {
+	case util.ERROR_BAD_CLONE_PATH, util.ERROR_BAD_OUTPUT_PATH:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// snapshotErrorStatus maps errors of the snapshot, which are mostly failures of the server
+func snapshotErrorStatus(err error) int {
+	switch errorCode(err) {
+	case util.ERROR_NO_REVISION:
+		return http.StatusNotFound
+	case util.ERROR_NO_SHORT_SHA, util.ERROR_BAD_PATTERN:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"archive/tar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func createLocalRepo(t *testing.T, files map[string]string) string {
+	clonePath := t.TempDir()
+	for name, content := range files {
+		filePath := filepath.Join(clonePath, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(filePath), 0777))
+		require.Nil(t, os.WriteFile(filePath, []byte(content), 0644))
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"commit", "-q", "-m", "init"},
+	} {
+		proc := exec.Command("git", append([]string{"-c", "user.name=tester", "-c", "user.email=tester@example.com"}, args...)...)
+		proc.Dir = clonePath
+		output, err := proc.CombinedOutput()
+		require.Nil(t, err, string(output))
+	}
+	return clonePath
+}
+
+func requestSnapshot(t *testing.T, handler http.Handler, method string, query url.Values) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, SNAPSHOT_PATH+"?"+query.Encode(), nil))
+	return recorder
+}
+
+func tarEntries(t *testing.T, reader io.Reader) map[string]string {
+	entries := map[string]string{}
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return entries
+		}
+		require.Nil(t, err)
+		contents, err := io.ReadAll(archive)
+		require.Nil(t, err)
+		entries[header.Name] = string(contents)
+	}
+}
+
+func TestServeSnapshot(t *testing.T) {
+	clonePath := createLocalRepo(t, map[string]string{
+		"main.go":       "package main",
+		"docs/guide.md": "# guide",
+	})
+	server := NewServer(clonePath, "", "", 2)
+
+	response := requestSnapshot(t, server, http.MethodGet, url.Values{"rev": {"HEAD"}})
+	require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	require.Equal(t, TAR_CONTENT_TYPE, response.Header().Get("Content-Type"))
+	require.Equal(t, map[string]string{"main.go": "package main", "docs/guide.md": "# guide"}, tarEntries(t, response.Body))
+
+	response = requestSnapshot(t, server, http.MethodGet, url.Values{"rev": {"HEAD"}, "include": {"**/*.md"}})
+	require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	require.Equal(t, map[string]string{"docs/guide.md": "# guide"}, tarEntries(t, response.Body))
+
+	// nothing matched, but the archive is still valid
+	response = requestSnapshot(t, server, http.MethodGet, url.Values{"rev": {"HEAD"}, "include": {"*.java"}})
+	require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	require.Empty(t, tarEntries(t, response.Body))
+}
+
+func TestServeSnapshotErrors(t *testing.T) {
+	clonePath := createLocalRepo(t, map[string]string{
+		"main.go": "package main",
+	})
+	server := NewServer(clonePath, "", "", 1)
+
+	response := requestSnapshot(t, server, http.MethodGet, url.Values{})
+	require.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = requestSnapshot(t, server, http.MethodGet, url.Values{"rev": {"no-such-branch"}})
+	require.Equal(t, http.StatusNotFound, response.Code)
+
+	response = requestSnapshot(t, server, http.MethodGet, url.Values{"rev": {"HEAD"}, "include": {"[bad"}})
+	require.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = requestSnapshot(t, server, http.MethodPost, url.Values{"rev": {"HEAD"}})
+	require.Equal(t, http.StatusMethodNotAllowed, response.Code)
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/other?rev=HEAD", nil))
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+}
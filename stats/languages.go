@@ -0,0 +1,174 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	languageToExtensions = map[string][]string{
+		"java":        {".java"},
+		"kotlin":      {".kt", ".kts"},
+		"scala":       {".scala", ".sc"},
+		"csharp":      {".cs"},
+		"node":        {".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx"},
+		"python":      {".py"},
+		"ruby":        {".rb"},
+		"go":          {".go"},
+		"php":         {".php"},
+		"swift":       {".swift"},
+		"objective-c": {".m", ".mm"},
+		"cpp":         {".c", ".cc", ".cpp", ".cxx", ".h", ".hpp"},
+		"rust":        {".rs"},
+		"dart":        {".dart"},
+		"shell":       {".sh", ".bash", ".zsh"},
+		"perl":        {".pl", ".pm"},
+		"elixir":      {".ex", ".exs"},
+		"r":           {".r"},
+		"yaml":        {".yml", ".yaml"},
+		"sql":         {".sql"},
+		"lua":         {".lua"},
+		"haskell":     {".hs"},
+		"clojure":     {".clj", ".cljs", ".cljc"},
+		"lisp":        {".lisp", ".el"},
+		"html":        {".html", ".htm"},
+		"xml":         {".xml"},
+		"css":         {".css"},
+		"scss":        {".scss", ".less"},
+	}
+
+	extensionToLanguage map[string]string
+
+	interpreterToLanguage = map[string]string{
+		"python":  "python",
+		"ruby":    "ruby",
+		"node":    "node",
+		"nodejs":  "node",
+		"deno":    "node",
+		"php":     "php",
+		"perl":    "perl",
+		"sh":      "shell",
+		"bash":    "shell",
+		"zsh":     "shell",
+		"dash":    "shell",
+		"ksh":     "shell",
+		"lua":     "lua",
+		"elixir":  "elixir",
+		"rscript": "r",
+		"swift":   "swift",
+		"kotlin":  "kotlin",
+		"scala":   "scala",
+	}
+
+	interpreterVersionPattern = regexp.MustCompile(`[0-9.]+$`)
+)
+
+func init() {
+	buildExtensionToLanguage()
+}
+
+func buildExtensionToLanguage() {
+	extensionToLanguage = map[string]string{}
+	for language, extensions := range languageToExtensions {
+		for _, extension := range extensions {
+			extensionToLanguage[extension] = language
+		}
+	}
+}
+
+// LoadLanguageMap merges a JSON object of language names to lists of extensions into the known languages.
+// its extensions override the built-in ones, so a built-in extension can be moved to another language.
+// it isn't safe to call while languages are looked up.
+func LoadLanguageMap(languageMapPath string) error {
+	contents, err := os.ReadFile(languageMapPath)
+	if err != nil {
+		return fmt.Errorf("failed to read language map '%v': %v", languageMapPath, err)
+	}
+	languageMap := map[string][]string{}
+	err = json.Unmarshal(contents, &languageMap)
+	if err != nil {
+		return fmt.Errorf("invalid language map '%v', expected a JSON object of language names to lists of extensions such as {\"go\": [\".go\"]}: %v", languageMapPath, err)
+	}
+	err = mergeLanguageMap(languageMap)
+	if err != nil {
+		return fmt.Errorf("invalid language map '%v': %v", languageMapPath, err)
+	}
+	return nil
+}
+
+func mergeLanguageMap(languageMap map[string][]string) error {
+	mappedExtensions := map[string]string{}
+	for language, extensions := range languageMap {
+		if language == "" {
+			return fmt.Errorf("language name is empty")
+		}
+		for _, extension := range extensions {
+			extension = normalizeExtension(extension)
+			if extension == "." {
+				return fmt.Errorf("extension of '%v' is empty", language)
+			}
+			if otherLanguage, found := mappedExtensions[extension]; found && otherLanguage != language {
+				return fmt.Errorf("extension '%v' is mapped to both '%v' and '%v'", extension, otherLanguage, language)
+			}
+			mappedExtensions[extension] = language
+		}
+	}
+
+	for language, extensions := range languageToExtensions {
+		var keptExtensions []string
+		for _, extension := range extensions {
+			if _, found := mappedExtensions[extension]; !found {
+				keptExtensions = append(keptExtensions, extension)
+			}
+		}
+		languageToExtensions[language] = keptExtensions
+	}
+	for extension, language := range mappedExtensions {
+		languageToExtensions[language] = append(languageToExtensions[language], extension)
+	}
+
+	buildExtensionToLanguage()
+	return nil
+}
+
+func normalizeExtension(extension string) string {
+	extension = strings.ToLower(strings.TrimSpace(extension))
+	if !strings.HasPrefix(extension, ".") {
+		extension = "." + extension
+	}
+	return extension
+}
+
+func GetLanguageFromExtension(extension string) (string, bool) {
+	language, found := extensionToLanguage[strings.ToLower(extension)]
+	return language, found
+}
+
+// GetLanguageFromShebang maps the interpreter of a #! line, such as "#!/usr/bin/env python3", to a language
+func GetLanguageFromShebang(firstLine string) (string, bool) {
+	if !strings.HasPrefix(firstLine, "#!") {
+		return "", false
+	}
+	fields := strings.Fields(firstLine[2:])
+	if len(fields) == 0 {
+		return "", false
+	}
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" {
+		interpreter = ""
+		for _, field := range fields[1:] {
+			// skips env options such as -S and variable assignments
+			if !strings.HasPrefix(field, "-") && !strings.Contains(field, "=") {
+				interpreter = path.Base(field)
+				break
+			}
+		}
+	}
+	interpreter = interpreterVersionPattern.ReplaceAllString(strings.ToLower(interpreter), "")
+	language, found := interpreterToLanguage[interpreter]
+	return language, found
+}
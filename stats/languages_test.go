@@ -0,0 +1,101 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLanguageFromExtension(t *testing.T) {
+	for _, test := range []struct {
+		extension string
+		language  string
+	}{
+		{".java", "java"},
+		{".ts", "node"},
+		{".dart", "dart"},
+		{".sh", "shell"},
+		{".bash", "shell"},
+		{".pl", "perl"},
+		{".ex", "elixir"},
+		{".exs", "elixir"},
+		{".R", "r"},
+		{".yml", "yaml"},
+		{".yaml", "yaml"},
+		{".sql", "sql"},
+		{".SQL", "sql"},
+		{".lua", "lua"},
+		{".hs", "haskell"},
+		{".clj", "clojure"},
+		{".el", "lisp"},
+		{".html", "html"},
+		{".xml", "xml"},
+		{".css", "css"},
+		{".scss", "scss"},
+	} {
+		language, found := GetLanguageFromExtension(test.extension)
+		require.True(t, found, test.extension)
+		require.Equal(t, test.language, language, test.extension)
+	}
+
+	_, found := GetLanguageFromExtension(".md")
+	require.False(t, found)
+}
+
+func TestLoadLanguageMap(t *testing.T) {
+	builtIn := map[string][]string{}
+	for language, extensions := range languageToExtensions {
+		builtIn[language] = append([]string{}, extensions...)
+	}
+	defer func() {
+		languageToExtensions = builtIn
+		buildExtensionToLanguage()
+	}()
+
+	languageMapPath := filepath.Join(t.TempDir(), "languages.json")
+	require.Nil(t, os.WriteFile(languageMapPath, []byte(`{"apps-script": [".gs", "GSX"], "custom-tsx": [".tsx"]}`), 0644))
+	require.Nil(t, LoadLanguageMap(languageMapPath))
+
+	for extension, expectedLanguage := range map[string]string{".gs": "apps-script", ".gsx": "apps-script", ".tsx": "custom-tsx", ".ts": "node", ".java": "java"} {
+		language, found := GetLanguageFromExtension(extension)
+		require.True(t, found, extension)
+		require.Equal(t, expectedLanguage, language, extension)
+	}
+	require.NotContains(t, languageToExtensions["node"], ".tsx")
+
+	for contents, expectedError := range map[string]string{
+		`["go"]`:                     "expected a JSON object",
+		`{"a": [".x"], "b": [".X"]}`: "mapped to both",
+		`{"": [".x"]}`:               "language name is empty",
+		`{"a": [""]}`:                "extension of 'a' is empty",
+	} {
+		require.Nil(t, os.WriteFile(languageMapPath, []byte(contents), 0644))
+		err := LoadLanguageMap(languageMapPath)
+		require.NotNil(t, err, contents)
+		require.Contains(t, err.Error(), expectedError)
+		require.Contains(t, err.Error(), languageMapPath)
+	}
+	require.NotNil(t, LoadLanguageMap(filepath.Join(t.TempDir(), "missing.json")))
+}
+
+func TestGetLanguageFromShebang(t *testing.T) {
+	for firstLine, expectedLanguage := range map[string]string{
+		"#!/usr/bin/env python3":               "python",
+		"#!/usr/bin/python3.11 -u":             "python",
+		"#!/bin/bash":                          "shell",
+		"#! /bin/sh -e":                        "shell",
+		"#!/usr/bin/env -S node --no-warnings": "node",
+		"#!/usr/bin/env LANG=C perl":           "perl",
+		"#!/usr/local/bin/Rscript":             "r",
+		"#!/usr/bin/env unknown":               "",
+		"#!":                                   "",
+		"# just a comment":                     "",
+		"print('no shebang')":                  "",
+	} {
+		language, found := GetLanguageFromShebang(firstLine)
+		require.Equal(t, expectedLanguage != "", found, firstLine)
+		require.Equal(t, expectedLanguage, language, firstLine)
+	}
+}
@@ -0,0 +1,141 @@
+package stats
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	bytesInMb = 1024 * 1024
+
+	OTHER_LANGUAGES = "other"
+	// the language column of the last CSV row, which sums the rows of the languages
+	TOTAL_ROW = "total"
+)
+
+var csvHeaders = []string{"language", "numberOfFiles", "linesOfCode", "sizeBytes"}
+
+type LanguageStats struct {
+	NumberOfFiles int     `json:"numberOfFiles"`
+	LinesOfCode   float64 `json:"linesOfCode"`
+	CommentLines  float64 `json:"commentLines"`
+	BlankLines    float64 `json:"blankLines"`
+	SizeBytes     int64   `json:"sizeBytes"`
+}
+
+// FileStats is the per file record of --stats-detailed
+type FileStats struct {
+	Path        string `json:"path"`
+	Language    string `json:"language"`
+	LinesOfCode int    `json:"linesOfCode"`
+	SizeBytes   int64  `json:"sizeBytes"`
+}
+
+// CodeStats aggregates the files of a snapshot. call Finalize once all files were added.
+type CodeStats struct {
+	CountersByLanguage map[string]*LanguageStats `json:"countersByLanguage"`
+	SnapshotSizeInMb   float64                   `json:"snapshotSizeInMb"`
+	TotalLinesOfCode   float64                   `json:"totalLinesOfCode"`
+
+	totalSizeBytes int64
+}
+
+func NewCodeStats() *CodeStats {
+	return &CodeStats{
+		CountersByLanguage: map[string]*LanguageStats{},
+	}
+}
+
+// AddFile counts a file of the snapshot, an empty language counts only towards the snapshot size
+func (codeStats *CodeStats) AddFile(language string, sizeBytes int64, linesOfCode int, commentLines int, blankLines int) {
+	codeStats.totalSizeBytes += sizeBytes
+	if language == "" {
+		return
+	}
+	counters, found := codeStats.CountersByLanguage[language]
+	if !found {
+		counters = &LanguageStats{}
+		codeStats.CountersByLanguage[language] = counters
+	}
+	counters.NumberOfFiles++
+	counters.LinesOfCode += float64(linesOfCode)
+	counters.CommentLines += float64(commentLines)
+	counters.BlankLines += float64(blankLines)
+	counters.SizeBytes += sizeBytes
+}
+
+// Finalize computes the totals of the added files. the snapshot size is derived only from the bytes
+// accumulated by AddFile, rounded to 2 decimal places.
+func (codeStats *CodeStats) Finalize() {
+	codeStats.SnapshotSizeInMb = math.Round(float64(codeStats.totalSizeBytes)*100/bytesInMb) / 100
+	codeStats.TotalLinesOfCode = 0
+	for _, counters := range codeStats.CountersByLanguage {
+		codeStats.TotalLinesOfCode += counters.LinesOfCode
+	}
+}
+
+// KeepTopLanguages collapses all but the n languages with the most lines of code into a single "other" counter,
+// breaking ties by language name. n of 0 keeps all languages.
+func (codeStats *CodeStats) KeepTopLanguages(n int) {
+	if n <= 0 || len(codeStats.CountersByLanguage) <= n {
+		return
+	}
+
+	languages := make([]string, 0, len(codeStats.CountersByLanguage))
+	for language := range codeStats.CountersByLanguage {
+		languages = append(languages, language)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		first, second := codeStats.CountersByLanguage[languages[i]], codeStats.CountersByLanguage[languages[j]]
+		if first.LinesOfCode != second.LinesOfCode {
+			return first.LinesOfCode > second.LinesOfCode
+		}
+		return languages[i] < languages[j]
+	})
+
+	other := &LanguageStats{}
+	for _, language := range languages[n:] {
+		counters := codeStats.CountersByLanguage[language]
+		other.NumberOfFiles += counters.NumberOfFiles
+		other.LinesOfCode += counters.LinesOfCode
+		other.CommentLines += counters.CommentLines
+		other.BlankLines += counters.BlankLines
+		other.SizeBytes += counters.SizeBytes
+		delete(codeStats.CountersByLanguage, language)
+	}
+	codeStats.CountersByLanguage[OTHER_LANGUAGES] = other
+}
+
+// WriteCSV writes a header row, a row for every language sorted by name, and a row of their totals
+func (codeStats *CodeStats) WriteCSV(writer io.Writer) error {
+	languages := make([]string, 0, len(codeStats.CountersByLanguage))
+	for language := range codeStats.CountersByLanguage {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+
+	csvWriter := csv.NewWriter(writer)
+	rows := [][]string{csvHeaders}
+	total := &LanguageStats{}
+	for _, language := range languages {
+		counters := codeStats.CountersByLanguage[language]
+		rows = append(rows, csvRow(language, counters))
+		total.NumberOfFiles += counters.NumberOfFiles
+		total.LinesOfCode += counters.LinesOfCode
+		total.SizeBytes += counters.SizeBytes
+	}
+	rows = append(rows, csvRow(TOTAL_ROW, total))
+	return csvWriter.WriteAll(rows)
+}
+
+func csvRow(language string, counters *LanguageStats) []string {
+	return []string{
+		language,
+		strconv.Itoa(counters.NumberOfFiles),
+		strconv.FormatFloat(counters.LinesOfCode, 'f', -1, 64),
+		strconv.FormatInt(counters.SizeBytes, 10),
+	}
+}
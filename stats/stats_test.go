@@ -0,0 +1,81 @@
+package stats
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeStats(t *testing.T) {
+	codeStats := NewCodeStats()
+	sizes := []int64{3 * bytesInMb / 2, 700 * 1024, 12345}
+	codeStats.AddFile("java", sizes[0], 10, 2, 1)
+	codeStats.AddFile("go", sizes[1], 5, 0, 0)
+	codeStats.AddFile("", sizes[2], 0, 0, 0)
+	codeStats.Finalize()
+
+	totalBytes := sizes[0] + sizes[1] + sizes[2]
+	require.Equal(t, math.Round(float64(totalBytes)*100/bytesInMb)/100, codeStats.SnapshotSizeInMb)
+	require.Equal(t, 2.2, codeStats.SnapshotSizeInMb)
+	require.Equal(t, float64(15), codeStats.TotalLinesOfCode)
+	require.Len(t, codeStats.CountersByLanguage, 2)
+	require.Equal(t, sizes[0], codeStats.CountersByLanguage["java"].SizeBytes)
+	require.Equal(t, sizes[1], codeStats.CountersByLanguage["go"].SizeBytes)
+	// with the files of no language, the sizes of the languages sum to the total
+	languagesBytes := int64(0)
+	for _, counters := range codeStats.CountersByLanguage {
+		languagesBytes += counters.SizeBytes
+	}
+	require.Equal(t, totalBytes, languagesBytes+sizes[2])
+
+	// finalizing again doesn't count anything twice
+	codeStats.Finalize()
+	require.Equal(t, 2.2, codeStats.SnapshotSizeInMb)
+	require.Equal(t, float64(15), codeStats.TotalLinesOfCode)
+}
+
+func TestKeepTopLanguages(t *testing.T) {
+	codeStats := NewCodeStats()
+	codeStats.AddFile("java", 1000, 100, 10, 1)
+	codeStats.AddFile("go", 500, 50, 5, 1)
+	codeStats.AddFile("python", 400, 50, 0, 0)
+	codeStats.AddFile("ruby", 200, 20, 1, 0)
+	codeStats.AddFile("ruby", 50, 5, 0, 2)
+	codeStats.Finalize()
+
+	codeStats.KeepTopLanguages(0)
+	require.Len(t, codeStats.CountersByLanguage, 4)
+	codeStats.KeepTopLanguages(4)
+	require.Len(t, codeStats.CountersByLanguage, 4)
+
+	// go and python are tied, go wins by name
+	codeStats.KeepTopLanguages(2)
+	require.Equal(t, map[string]*LanguageStats{
+		"java":          {NumberOfFiles: 1, LinesOfCode: 100, CommentLines: 10, BlankLines: 1, SizeBytes: 1000},
+		"go":            {NumberOfFiles: 1, LinesOfCode: 50, CommentLines: 5, BlankLines: 1, SizeBytes: 500},
+		OTHER_LANGUAGES: {NumberOfFiles: 3, LinesOfCode: 75, CommentLines: 1, BlankLines: 2, SizeBytes: 650},
+	}, codeStats.CountersByLanguage)
+	require.Equal(t, float64(225), codeStats.TotalLinesOfCode)
+}
+
+func TestWriteCSV(t *testing.T) {
+	codeStats := NewCodeStats()
+	codeStats.AddFile("java", 1000, 100, 10, 1)
+	codeStats.AddFile("go", 500, 50, 5, 1)
+	codeStats.AddFile("go", 20, 2, 0, 0)
+	codeStats.AddFile("", 300, 0, 0, 0)
+	codeStats.Finalize()
+
+	buffer := &bytes.Buffer{}
+	require.Nil(t, codeStats.WriteCSV(buffer))
+	require.Equal(t, "language,numberOfFiles,linesOfCode,sizeBytes\n"+
+		"go,2,52,520\n"+
+		"java,1,100,1000\n"+
+		"total,3,152,1520\n", buffer.String())
+
+	buffer.Reset()
+	require.Nil(t, NewCodeStats().WriteCSV(buffer))
+	require.Equal(t, "language,numberOfFiles,linesOfCode,sizeBytes\ntotal,0,0,0\n", buffer.String())
+}
@@ -0,0 +1,84 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GIT_DIR_NAME        = ".git"
+	GIT_DIR_FILE_PREFIX = "gitdir:"
+)
+
+func isDirectory(dirPath string) bool {
+	info, err := os.Stat(dirPath)
+	return err == nil && info.IsDir()
+}
+
+// IsBareRepository checks whether the directory is a bare repository, holding the git directory
+// layout at its top level instead of under .git
+func IsBareRepository(dirPath string) bool {
+	info, err := os.Stat(filepath.Join(dirPath, "HEAD"))
+	if err != nil || info.IsDir() {
+		return false
+	}
+	return isDirectory(filepath.Join(dirPath, "objects")) && isDirectory(filepath.Join(dirPath, "refs"))
+}
+
+// ResolveGitDir returns the git directory of a clone: its .git directory, the directory its .git file points to
+// (linked worktrees and --separate-git-dir), or the clone itself when it is bare
+func ResolveGitDir(clonePath string) (string, error) {
+	dotGitPath := filepath.Join(clonePath, GIT_DIR_NAME)
+	info, err := os.Stat(dotGitPath)
+	if err != nil {
+		if os.IsNotExist(err) && IsBareRepository(clonePath) {
+			return clonePath, nil
+		}
+		return "", err
+	}
+	if info.IsDir() {
+		return dotGitPath, nil
+	}
+
+	contents, err := os.ReadFile(dotGitPath)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(strings.SplitN(string(contents), "\n", 2)[0])
+	if !strings.HasPrefix(line, GIT_DIR_FILE_PREFIX) {
+		return "", fmt.Errorf("%v file at '%v' has no %v line", GIT_DIR_NAME, dotGitPath, GIT_DIR_FILE_PREFIX)
+	}
+	gitDir := filepath.FromSlash(strings.TrimSpace(strings.TrimPrefix(line, GIT_DIR_FILE_PREFIX)))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(clonePath, gitDir)
+	}
+	if !isDirectory(gitDir) {
+		return "", fmt.Errorf("git directory '%v' of %v file at '%v' is missing", gitDir, GIT_DIR_NAME, dotGitPath)
+	}
+	return gitDir, nil
+}
+
+// CommonGitDir returns the git directory holding the objects, modules and LFS cache of a clone, which is
+// shared by all worktrees of a linked worktree
+func CommonGitDir(clonePath string) string {
+	gitDir, err := ResolveGitDir(clonePath)
+	if err != nil {
+		return filepath.Join(clonePath, GIT_DIR_NAME)
+	}
+	return CommonDirOf(gitDir)
+}
+
+// CommonDirOf returns the common git directory of a git directory, which is itself unless it is the one of a linked worktree
+func CommonDirOf(gitDir string) string {
+	contents, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+	commonDir := filepath.FromSlash(strings.TrimSpace(string(contents)))
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(gitDir, commonDir)
+	}
+	return filepath.Clean(commonDir)
+}
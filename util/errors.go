@@ -1,15 +1,21 @@
 package util
 
 const (
-	ERROR_BAD_CLONE_PATH     = 201
-	ERROR_BAD_CLONE_GIT      = 202
-	ERROR_BAD_OUTPUT_PATH    = 203
-	ERROR_NO_SHORT_SHA       = 204
-	ERROR_NO_REVISION        = 205
-	ERROR_FILES_DISCREPANCY  = 206
-	ERROR_HEAD_REF_NOT_FOUND = 207
-	ERROR_TREE_NOT_FOUND     = 208
-	ERROR_PATH_TOO_LONG      = 101
+	ERROR_BAD_CLONE_PATH          = 201
+	ERROR_BAD_CLONE_GIT           = 202
+	ERROR_BAD_OUTPUT_PATH         = 203
+	ERROR_NO_SHORT_SHA            = 204
+	ERROR_NO_REVISION             = 205
+	ERROR_FILES_DISCREPANCY       = 206
+	ERROR_HEAD_REF_NOT_FOUND      = 207
+	ERROR_TREE_NOT_FOUND          = 208
+	ERROR_BAD_SIGNATURE           = 209
+	ERROR_BAD_PATTERN             = 210
+	ERROR_MAX_TOTAL_SIZE_EXCEEDED = 211
+	ERROR_PATH_TRAVERSAL          = 212
+	ERROR_NO_FILES_WRITTEN        = 213
+	ERROR_CANCELED                = 214
+	ERROR_PATH_TOO_LONG           = 101
 )
 
 type ErrorWithCode struct {
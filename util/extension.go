@@ -1,6 +1,10 @@
 package util
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 var (
 	extensions = []string{
@@ -125,14 +129,39 @@ func NotTextExt(ext string) bool {
 		// we don't assume anything if there's no extension
 		return false
 	}
-	ext = ext[1:]
+	// the map has lowercase extensions, while file names may use any case
+	ext = strings.ToLower(ext[1:])
 	return extensionsMap[ext]
 }
 
+// NoiseDirectories returns the names of the directories excluded unless --include-noise-dirs is set
+func NoiseDirectories() []string {
+	return append([]string{}, noiseDirectories...)
+}
+
 func NoisyDirectoryExclusionPatterns() []string {
-	patterns := make([]string, len(noiseDirectories))
-	for i, dirname := range noiseDirectories {
+	return DirectoryExclusionPatterns(noiseDirectories)
+}
+
+// NoisyDirectoryExclusionRegexPatterns is the regex equivalent of NoisyDirectoryExclusionPatterns
+func NoisyDirectoryExclusionRegexPatterns() []string {
+	return DirectoryExclusionRegexPatterns(noiseDirectories)
+}
+
+// DirectoryExclusionPatterns returns glob patterns excluding directories with the names, at any depth
+func DirectoryExclusionPatterns(dirnames []string) []string {
+	patterns := make([]string, len(dirnames))
+	for i, dirname := range dirnames {
 		patterns[i] = fmt.Sprintf("**/%v/**", dirname)
 	}
 	return patterns
 }
+
+// DirectoryExclusionRegexPatterns is the regex equivalent of DirectoryExclusionPatterns
+func DirectoryExclusionRegexPatterns(dirnames []string) []string {
+	patterns := make([]string, len(dirnames))
+	for i, dirname := range dirnames {
+		patterns[i] = fmt.Sprintf("(^|/)%v/", regexp.QuoteMeta(dirname))
+	}
+	return patterns
+}
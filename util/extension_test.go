@@ -0,0 +1,17 @@
+package util
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotTextExt(t *testing.T) {
+	for _, fileName := range []string{"image.png", "IMAGE.PNG", "photo.JPG", "photo.Jpeg", "archive.Zip", "setup.EXE"} {
+		require.True(t, NotTextExt(filepath.Ext(fileName)), fileName)
+	}
+	for _, fileName := range []string{"main.go", "README.MD", "Makefile", "script.Sh"} {
+		require.False(t, NotTextExt(filepath.Ext(fileName)), fileName)
+	}
+}